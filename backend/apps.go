@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/redis/go-redis/v9"
+
+	"videochat/internal/app/analytics"
+	"videochat/internal/app/audit"
+	"videochat/internal/app/httpapi"
+	"videochat/internal/app/rooms"
+	"videochat/pkg/webrtc/ice"
+	"videochat/pkg/webrtc/protocol"
+	"videochat/pkg/webrtc/signaling"
+)
+
+// appConfig describes one product hosted on this server.
+type appConfig struct {
+	// Name selects the URL path prefix ("/{name}") and Redis namespace; empty is the root app.
+	Name        string
+	ICEServers  []protocol.ICEServer
+	ICEMode     string
+	PublicWSURL string
+}
+
+// app bundles the room store, hubs and client settings of one signaling namespace.
+type app struct {
+	name     string
+	prefix   string
+	rooms    rooms.Store
+	codes    rooms.CodeFormat
+	audit    audit.Logger
+	hubs     *hubManager
+	events   *analytics.StreamSink // nil unless ANALYTICS_STREAM_LEN is set
+	relays   *ice.HealthChecker    // nil unless TURN_HEALTH_INTERVAL is set
+	settings httpapi.Settings
+}
+
+func newApp(rdb *redis.Client, cfg config, ac appConfig) *app {
+	keyPrefix := "webrtc"
+	pathPrefix := ""
+	if ac.Name != "" {
+		keyPrefix = "webrtc:" + ac.Name
+		pathPrefix = "/" + ac.Name
+	}
+
+	codeFormat, err := rooms.NewCodeFormat(cfg.RoomCodeAlphabet, cfg.RoomCodeLength)
+	if err != nil {
+		log.Fatalf("invalid room code format: %v", err)
+	}
+	codeFormat.AcceptLegacy = cfg.RoomCodeLegacy
+	redisRooms := rooms.NewRedisStore(rdb, keyPrefix)
+	redisRooms.SetCodeFormat(codeFormat)
+	redisRooms.SetListScanCount(cfg.RoomListScanCount)
+	redisRooms.SetMaxRoomsPerOwner(cfg.MaxRoomsPerOwner)
+	roomStore := rooms.WithTimeout(redisRooms, cfg.StoreTimeout)
+	// Leave sink a nil interface when analytics is off, not a nil *StreamSink.
+	var sink signaling.EventSink
+	var events *analytics.StreamSink
+	if cfg.AnalyticsStreamLen > 0 {
+		events = analytics.NewStreamSink(rdb, keyPrefix, cfg.AnalyticsStreamLen, cfg.AnalyticsBuffer)
+		sink = events
+	}
+	relays := ice.NewHealthChecker(ac.ICEServers, cfg.TURNHealthInterval, cfg.TURNHealthTimeout)
+	var iceSource func() []protocol.ICEServer
+	if relays != nil {
+		iceSource = relays.Servers
+	}
+	hubs := newHubManager(rdb, hubManagerConfig{
+		KeyPrefix:       keyPrefix,
+		Rooms:           roomStore,
+		StoreTimeout:    cfg.StoreTimeout,
+		CloseGrace:      cfg.RoomCloseGrace,
+		ChatCapacity:    cfg.ChatHistorySize,
+		ChatTTL:         cfg.ChatHistoryTTL,
+		ClosingWarnings: cfg.RoomClosingWarnings,
+		UsernameKey:     cfg.UsernameKey,
+		Hub: signaling.HubOptions{
+			ICEServers:        ac.ICEServers,
+			ICEMode:           ac.ICEMode,
+			ICESource:         iceSource,
+			ElectRelay:        cfg.ElectRelay,
+			LogPayloads:       cfg.DebugLogPayloads,
+			DeferRoster:       cfg.DeferRoster,
+			MaxBroadcasters:   cfg.MaxBroadcasters,
+			MaxPeers:          cfg.MaxRoomPeers,
+			StrictDecoding:    cfg.StrictProtocol,
+			RenameCooldown:    cfg.RenameCooldown,
+			Region:            cfg.Region,
+			AutoBroadcastOff:  cfg.AutoBroadcastOff,
+			InboundLimiter:    signaling.NewInboundLimiter(cfg.MaxInboundRate),
+			UsernameRetention: cfg.UsernameRetention,
+			MaxConnLifetime:   cfg.MaxConnLifetime,
+			LeaveGrace:        cfg.LeaveGrace,
+			EmptyGrace:        cfg.EmptyGrace,
+			MaxFramesPerConn:  cfg.MaxFramesPerConn,
+			PongTimeout:       cfg.PongTimeout,
+			MaxMessageSize:    cfg.MaxMessageSize,
+			WriteTimeout:      cfg.WriteTimeout,
+			ControlWrite:      cfg.ControlWrite,
+			SignalWrite:       cfg.SignalWrite,
+			SlowWrite:         cfg.SlowWrite,
+			MaxSlowWrites:     cfg.MaxSlowWrites,
+			MaxPeerIDLength:   cfg.MaxPeerIDLength,
+			MaxJSONDepth:      cfg.MaxJSONDepth,
+			MaxJSONToken:      cfg.MaxJSONToken,
+			GuestPrefix:       cfg.GuestPrefix,
+			HandshakeTimeout:  cfg.HandshakeTimeout,
+			CompressAbove:     cfg.CompressAbove,
+			DuplicateSessions: cfg.DuplicateSessions,
+			ReadyTimeout:      cfg.ReadyTimeout,
+			Events:            sink,
+		},
+	})
+	hubs.startPresenceSweeper(cfg.PresenceSweepInterval)
+
+	var auditLog audit.Logger = audit.Nop{}
+	if cfg.AuditLogSize > 0 {
+		auditLog = audit.WithTimeout(audit.NewRedisLogger(rdb, keyPrefix, cfg.AuditLogSize, cfg.AuditLogTTL), cfg.StoreTimeout)
+	}
+
+	return &app{
+		name:   ac.Name,
+		prefix: pathPrefix,
+		rooms:  roomStore,
+		codes:  codeFormat,
+		audit:  auditLog,
+		hubs:   hubs,
+		events: events,
+		relays: relays,
+		settings: httpapi.Settings{
+			ICEMode:     ac.ICEMode,
+			ICEServers:  ac.ICEServers,
+			PublicWSURL: ac.PublicWSURL,
+			Region:      cfg.Region,
+			TURNHealth:  relays,
+		},
+	}
+}
+
+// appStats aggregates server-wide stats across every hosted app.
+type appStats []*app
+
+func (s appStats) HubCount() int {
+	total := 0
+	for _, a := range s {
+		total += a.hubs.HubCount()
+	}
+	return total
+}
+
+func (s appStats) ClientTotal() int {
+	total := 0
+	for _, a := range s {
+		total += a.hubs.ClientTotal()
+	}
+	return total
+}
+
+func (s appStats) MaxQueueDepth() int {
+	deepest := 0
+	for _, a := range s {
+		deepest = max(deepest, a.hubs.MaxQueueDepth())
+	}
+	return deepest
+}
+
+func (s appStats) RoomCount(ctx context.Context) (int, error) {
+	total := 0
+	for _, a := range s {
+		n, err := a.rooms.Count(ctx)
+		if err != nil {
+			return 0, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// handler serves the app's signaling, API and SPA routes relative to its prefix.
+func (a *app) handler(cfg config, limiter *httpapi.IPLimiter, identity *httpapi.Identity, drain *httpapi.Drain) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/ws", httpapi.WSHandler(a.hubs, a.rooms, httpapi.WSOptions{
+		Limiter:    limiter,
+		Identity:   identity,
+		AcceptHook: httpapi.BlockNets(cfg.BlockedNets),
+		Drain:      drain,
+	}))
+	mux.Handle("/api/settings", httpapi.SettingsHandler(a.settings, identity))
+	mux.Handle("/api/whoami", httpapi.WhoAmIHandler(identity))
+	mux.Handle("/api/rooms", httpapi.CreateRoomHandler(a.rooms, identity))
+	mux.Handle("/api/rooms/validate", httpapi.RoomCodeValidateHandler(a.codes))
+	mux.Handle("/api/rooms/list", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomListHandler(a.rooms)))
+	mux.Handle("/api/rooms/", httpapi.RoomLookupHandler(a.rooms))
+	mux.Handle("/api/rooms/{code}/pause", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomPauseHandler(a.hubs, a.rooms, a.audit)))
+	mux.Handle("/api/rooms/{code}/rename", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomRenameHandler(a.hubs, a.codes, a.audit)))
+	mux.Handle("/api/rooms/{code}/extend", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomExtendHandler(a.rooms, a.audit)))
+	mux.Handle("/api/rooms/{code}/warm", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomWarmHandler(a.hubs, a.rooms, a.audit)))
+	mux.Handle("/api/rooms/{code}/events", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomEventsHandler(a.hubs, a.rooms, cfg.MaxEventObservers)))
+	mux.Handle("/api/rooms/{code}/export", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomExportHandler(a.hubs, a.audit)))
+	mux.Handle("/api/rooms/{code}/import", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomImportHandler(a.hubs, a.audit)))
+	mux.Handle("/api/rooms/{code}/usernames", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomUsernamesHandler(a.hubs, a.audit)))
+	mux.Handle("/api/rooms/{code}/clients", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomClientsHandler(a.hubs)))
+	mux.Handle("/api/rooms/{code}/queues", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomQueuesHandler(a.hubs)))
+	mux.Handle("/api/rooms/{code}/audit", httpapi.RequireAdmin(cfg.AdminToken, httpapi.RoomAuditHandler(a.audit)))
+	mux.Handle("/api/", httpapi.APINotFoundHandler())
+	// /debug/ice exposes TURN URLs and credentials: off unless DEBUG_ENDPOINTS is set,
+	// and admin-only whenever an admin token exists.
+	debugICE := http.NotFoundHandler()
+	if cfg.DebugEndpoints {
+		debugICE = httpapi.DebugICEHandler(a.settings)
+		if cfg.AdminToken != "" {
+			debugICE = httpapi.RequireAdmin(cfg.AdminToken, debugICE)
+		}
+	}
+	mux.Handle("/debug/ice", debugICE)
+	mux.Handle("/debug/spawn", httpapi.RequireAdmin(cfg.AdminToken, httpapi.SpawnHandler(a.hubs, a.rooms, a.audit)))
+	mux.Handle("/", httpapi.SecurityHeaders(cfg.ContentSecurityPolicy, a.settings, httpapi.SPAHandler(cfg.StaticPath, cfg.BrandingDir)))
+	return httpapi.Mount(a.prefix, mux)
+}
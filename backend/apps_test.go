@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"videochat/internal/app/audit"
+)
+
+func TestRoomEventsRequireAdmin(t *testing.T) {
+	m, _, store := newTestManager(t)
+	code := createRoom(t, store)
+	a := &app{rooms: store, hubs: m, audit: audit.Nop{}}
+	h := a.handler(config{AdminToken: "secret", MaxEventObservers: 1}, nil, nil, nil)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/rooms/"+code+"/events", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("status without token = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"videochat/pkg/webrtc/signaling"
+)
+
+// loadConfigFile applies CONFIG_FILE, a JSON object keyed by the same names as the
+// env vars (e.g. {"ADDR": ":8080", "MAX_CONNS_PER_IP": 20, "APPS": ["a", "b"]}).
+// Values already present in the environment (including .env) win over the file, so
+// the file can hold the baseline and env vars override it per deployment. Strings,
+// numbers and booleans are used as-is; arrays are joined with commas.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return fmt.Errorf("parse %s: %w", path, err)
+	}
+
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	applied := 0
+	for _, key := range keys {
+		val, err := configFileValue(raw[key])
+		if err != nil {
+			return fmt.Errorf("%s: key %s: %w", path, key, err)
+		}
+		if _, exists := os.LookupEnv(key); exists {
+			continue
+		}
+		_ = os.Setenv(key, val)
+		applied++
+	}
+	log.Printf("config: loaded %d of %d settings from %s (env overrides the rest)", applied, len(keys), path)
+	return nil
+}
+
+func configFileValue(raw json.RawMessage) (string, error) {
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(string(raw)))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	switch t := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return t, nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case json.Number:
+		return t.String(), nil
+	case []interface{}:
+		parts := make([]string, 0, len(t))
+		for _, item := range t {
+			switch s := item.(type) {
+			case string:
+				parts = append(parts, s)
+			case json.Number:
+				parts = append(parts, s.String())
+			default:
+				return "", errors.New("arrays may only hold strings or numbers")
+			}
+		}
+		return strings.Join(parts, ","), nil
+	default:
+		return "", errors.New("nested objects are not supported; use the flat env var names")
+	}
+}
+
+// validateConfig rejects merged settings that cannot work, so a bad file or env var
+// fails at startup instead of misbehaving later.
+func validateConfig(cfg config) error {
+	var problems []string
+	if strings.TrimSpace(cfg.Addr) == "" {
+		problems = append(problems, "ADDR is empty")
+	}
+	if strings.TrimSpace(cfg.RedisAddr) == "" {
+		problems = append(problems, "REDIS_ADDR is empty")
+	}
+	if len(cfg.Apps) == 0 {
+		problems = append(problems, "APPS lists no valid app names")
+	}
+	for name, n := range map[string]int{
+		"MAX_CONNS_PER_IP":     cfg.MaxConnsPerIP,
+		"MAX_EVENT_OBSERVERS":  cfg.MaxEventObservers,
+		"MAX_ROOMS_PER_OWNER":  cfg.MaxRoomsPerOwner,
+		"MAX_BROADCASTERS":     cfg.MaxBroadcasters,
+		"MAX_ROOM_PEERS":       cfg.MaxRoomPeers,
+		"MAX_INBOUND_RATE":     cfg.MaxInboundRate,
+		"MAX_FRAMES_PER_CONN":  cfg.MaxFramesPerConn,
+		"CHAT_HISTORY_SIZE":    cfg.ChatHistorySize,
+		"ROOM_CODE_LENGTH":     cfg.RoomCodeLength,
+		"MAX_MESSAGE_SIZE":     cfg.MaxMessageSize,
+		"MAX_SLOW_WRITES":      cfg.MaxSlowWrites,
+		"MAX_PEER_ID_LENGTH":   cfg.MaxPeerIDLength,
+		"ROOM_LIST_SCAN_COUNT": cfg.RoomListScanCount,
+		"MAX_JSON_DEPTH":       cfg.MaxJSONDepth,
+		"MAX_JSON_TOKEN":       cfg.MaxJSONToken,
+		"AUDIT_LOG_SIZE":       cfg.AuditLogSize,
+		"ANALYTICS_STREAM_LEN": cfg.AnalyticsStreamLen,
+		"ANALYTICS_BUFFER":     cfg.AnalyticsBuffer,
+		"COMPRESS_ABOVE":       cfg.CompressAbove,
+	} {
+		if n < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative", name))
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"STORE_TIMEOUT":           cfg.StoreTimeout,
+		"ROOM_CLOSE_GRACE":        cfg.RoomCloseGrace,
+		"MAX_CONN_LIFETIME":       cfg.MaxConnLifetime,
+		"PEER_LEAVE_GRACE":        cfg.LeaveGrace,
+		"ROOM_EMPTY_GRACE":        cfg.EmptyGrace,
+		"RENAME_COOLDOWN":         cfg.RenameCooldown,
+		"PONG_TIMEOUT":            cfg.PongTimeout,
+		"HANDSHAKE_TIMEOUT":       cfg.HandshakeTimeout,
+		"READY_TIMEOUT":           cfg.ReadyTimeout,
+		"WRITE_TIMEOUT":           cfg.WriteTimeout,
+		"CONTROL_WRITE_TIMEOUT":   cfg.ControlWrite,
+		"SIGNAL_WRITE_TIMEOUT":    cfg.SignalWrite,
+		"PRESENCE_SWEEP_INTERVAL": cfg.PresenceSweepInterval,
+		"TURN_HEALTH_INTERVAL":    cfg.TURNHealthInterval,
+		"TURN_HEALTH_TIMEOUT":     cfg.TURNHealthTimeout,
+		"SLOW_WRITE_THRESHOLD":    cfg.SlowWrite,
+	} {
+		if d < 0 {
+			problems = append(problems, fmt.Sprintf("%s must not be negative", name))
+		}
+	}
+	switch cfg.DuplicateSessions {
+	case "", "allow", "reject", "replace":
+	default:
+		problems = append(problems, `DUPLICATE_SESSIONS must be "allow", "reject" or "replace"`)
+	}
+	if cfg.GuestPrefix != "" {
+		if _, ok := signaling.NormalizeUsername(cfg.GuestPrefix + "-000000"); !ok {
+			problems = append(problems, "GUEST_USERNAME_PREFIX is too long or contains control characters")
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return errors.New(strings.Join(problems, "; "))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// unsetenv clears key for the test and restores it afterwards.
+func unsetenv(t *testing.T, key string) {
+	t.Helper()
+	t.Setenv(key, "")
+	os.Unsetenv(key)
+}
+
+func writeConfigFile(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFileEnvOverridesFile(t *testing.T) {
+	for _, key := range []string{"ADDR", "APPS", "RELAY_ELECTION", "PEER_LEAVE_GRACE"} {
+		unsetenv(t, key)
+	}
+	t.Setenv("MAX_CONNS_PER_IP", "5")
+	path := writeConfigFile(t, `{
+		"ADDR": ":9090",
+		"MAX_CONNS_PER_IP": 20,
+		"APPS": ["alpha", "beta"],
+		"RELAY_ELECTION": true,
+		"PEER_LEAVE_GRACE": "3s"
+	}`)
+
+	if err := loadConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadConfig()
+	if cfg.Addr != ":9090" {
+		t.Errorf("Addr = %q, want the file's :9090", cfg.Addr)
+	}
+	if cfg.MaxConnsPerIP != 5 {
+		t.Errorf("MaxConnsPerIP = %d, want the env's 5 over the file's 20", cfg.MaxConnsPerIP)
+	}
+	if len(cfg.Apps) != 2 || cfg.Apps[0].Name != "alpha" || cfg.Apps[1].Name != "beta" {
+		t.Errorf("Apps = %+v, want alpha and beta from the array", cfg.Apps)
+	}
+	if !cfg.ElectRelay {
+		t.Error("ElectRelay = false, want the file's true")
+	}
+	if cfg.LeaveGrace.String() != "3s" {
+		t.Errorf("LeaveGrace = %s, want 3s", cfg.LeaveGrace)
+	}
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("merged config rejected: %v", err)
+	}
+}
+
+func TestConfigFileRejectsBadInput(t *testing.T) {
+	for name, body := range map[string]string{
+		"not json":      `ADDR=:8080`,
+		"nested object": `{"REDIS": {"ADDR": "localhost:6379"}}`,
+		"nested array":  `{"APPS": [["a"]]}`,
+	} {
+		if err := loadConfigFile(writeConfigFile(t, body)); err == nil {
+			t.Errorf("%s: loaded without error", name)
+		}
+	}
+	if err := loadConfigFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("missing file loaded without error")
+	}
+}
+
+func TestValidateConfigReportsEveryProblem(t *testing.T) {
+	cfg := config{
+		Addr:              ":8080",
+		RedisAddr:         "localhost:6379",
+		Apps:              []appConfig{{}},
+		MaxConnsPerIP:     -1,
+		LeaveGrace:        -1,
+		DuplicateSessions: "sometimes",
+	}
+	err := validateConfig(cfg)
+	if err == nil {
+		t.Fatal("invalid config accepted")
+	}
+	for _, want := range []string{"MAX_CONNS_PER_IP", "PEER_LEAVE_GRACE", "DUPLICATE_SESSIONS"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %s", err, want)
+		}
+	}
+
+	cfg.MaxConnsPerIP, cfg.LeaveGrace, cfg.DuplicateSessions = 0, 0, ""
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+}
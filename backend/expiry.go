@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"videochat/internal/app/rooms"
+	"videochat/pkg/webrtc/protocol"
+)
+
+// expiryPoll bounds how long the watcher goes without re-reading the room, so a TTL
+// added or extended from any instance is noticed.
+const expiryPoll = 15 * time.Second
+
+// watchExpiry runs for the lifetime of a hub. For rooms with a TTL it broadcasts
+// "room-closing-soon" as each configured warning point is crossed, sends
+// "room-closing-cancelled" if the room is extended after a warning, and closes the
+// remaining connections (1001 "room_expired") once the room is gone.
+func (m *hubManager) watchExpiry(code string, entry *hubEntry) {
+	var expiresAt *time.Time
+	var warned time.Duration // smallest warning point announced so far, 0 = none
+	for {
+		m.mu.Lock()
+		live := !m.closed && m.hubs[code] == entry
+		m.mu.Unlock()
+		if !live {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		room, err := m.roomStore.Get(ctx, code)
+		cancel()
+		switch {
+		case errors.Is(err, rooms.ErrNotFound):
+			if expiresAt != nil && time.Until(*expiresAt) < time.Second {
+				log.Printf("room %s expired, closing %d connections", code, entry.hub.ClientCount())
+				entry.hub.CloseAll(websocket.CloseGoingAway, "room_expired")
+			}
+			// Renamed: RenameRoom told its own instance's peers; send ours along too.
+			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+			newCode, err := m.rdb.Get(ctx, m.renamedKey(code)).Result()
+			cancel()
+			if err == nil && entry.hub.ClientCount() > 0 {
+				entry.hub.Relocate(newCode)
+			}
+			// Otherwise it was deleted; that path handles the peers.
+			return
+		case err != nil:
+			log.Printf("room %s expiry check: %v", code, err)
+		default:
+			expiresAt = room.ExpiresAt
+		}
+
+		wait := expiryPoll
+		if expiresAt == nil {
+			if warned > 0 {
+				_ = entry.hub.Broadcast(protocol.RoomClosingSoonMessage{Type: "room-closing-cancelled"})
+				warned = 0
+			}
+		} else {
+			remaining := time.Until(*expiresAt)
+			if warned > 0 && remaining > warned+expiryPoll {
+				_ = entry.hub.Broadcast(protocol.RoomClosingSoonMessage{Type: "room-closing-cancelled"})
+				warned = 0
+			}
+			// m.closingWarnings is sorted longest first.
+			var window, next time.Duration
+			for _, w := range m.closingWarnings {
+				if w >= remaining {
+					window = w
+				} else if next == 0 {
+					next = w
+				}
+			}
+			if window > 0 && (warned == 0 || window < warned) && remaining > 0 {
+				_ = entry.hub.Broadcast(protocol.RoomClosingSoonMessage{
+					Type:    "room-closing-soon",
+					Seconds: int((remaining + time.Second - 1) / time.Second),
+				})
+				warned = window
+			}
+			if until := remaining - next; until < wait {
+				wait = until
+			}
+			if wait < 100*time.Millisecond {
+				wait = 100 * time.Millisecond
+			}
+		}
+
+		select {
+		case <-m.stopLeases: // closed by Close
+			return
+		case <-time.After(wait):
+		}
+	}
+}
@@ -0,0 +1,629 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"videochat/internal/app/audit"
+	"videochat/internal/app/broadcast"
+	"videochat/internal/app/chat"
+	"videochat/internal/app/httpapi"
+	"videochat/internal/app/redislock"
+	"videochat/internal/app/rooms"
+	"videochat/internal/app/usernames"
+	"videochat/pkg/presence"
+	"videochat/pkg/webrtc/signaling"
+)
+
+// hubManager keeps one signaling Hub per room, each with isolated Redis keys.
+type hubEntry struct {
+	hub   *signaling.Hub
+	timer *time.Timer
+	store presence.Store
+	bcast broadcast.Store
+	names usernames.Store
+	chat  chat.Store
+	// gen is bumped whenever a join reuses the hub, invalidating cleanup timers
+	// scheduled before it.
+	gen uint64
+	// cleaning is non-nil while cleanupRoom resets the room; joins wait for it to close.
+	cleaning chan struct{}
+}
+
+type hubManager struct {
+	mu           sync.Mutex
+	hubs         map[string]*hubEntry
+	rdb          *redis.Client
+	keyPrefix    string
+	opts         signaling.HubOptions
+	roomStore    rooms.Store
+	storeTimeout time.Duration
+	// closeGrace keeps an idle room joinable (status "closing") before it is removed.
+	closeGrace time.Duration
+	// chatCapacity/chatTTL configure persisted chat history (capacity 0 disables it).
+	chatCapacity int
+	chatTTL      time.Duration
+	// usernameKey, when set, AES-GCM encrypts stored display names (see usernames.WithEncryption).
+	usernameKey []byte
+	// closingWarnings are the remaining-time points (longest first) at which peers of
+	// a room with a TTL get "room-closing-soon" (see expiry.go).
+	closingWarnings []time.Duration
+	// instanceID identifies this process in hub lease keys (see leases.go).
+	instanceID string
+	stopLeases chan struct{}
+	// closed stops new cleanup timers from being scheduled once Close has run.
+	closed bool
+	// creating holds a channel per room whose hub is being built; it closes when done.
+	creating map[string]chan struct{}
+}
+
+// hubManagerConfig configures a hubManager; zero values disable the optional features.
+type hubManagerConfig struct {
+	// KeyPrefix namespaces the manager's Redis keys (e.g. "webrtc" or "webrtc:app1").
+	KeyPrefix string
+	Rooms     rooms.Store
+	// StoreTimeout bounds each Redis call made for a hub (0 = no bound).
+	StoreTimeout time.Duration
+	// CloseGrace keeps an idle room joinable (status "closing") before it is removed.
+	CloseGrace time.Duration
+	// ChatCapacity/ChatTTL configure persisted chat history (capacity 0 disables it).
+	ChatCapacity int
+	ChatTTL      time.Duration
+	// ClosingWarnings are the remaining-time points at which peers of a room with a
+	// TTL get "room-closing-soon", in any order.
+	ClosingWarnings []time.Duration
+	// UsernameKey, when set, AES-GCM encrypts stored display names.
+	UsernameKey []byte
+	// Hub is the template every room's signaling.HubOptions starts from.
+	Hub signaling.HubOptions
+}
+
+func newHubManager(rdb *redis.Client, cfg hubManagerConfig) *hubManager {
+	warnings := append([]time.Duration(nil), cfg.ClosingWarnings...)
+	sort.Slice(warnings, func(i, j int) bool { return warnings[i] > warnings[j] })
+	m := &hubManager{
+		hubs:            make(map[string]*hubEntry),
+		creating:        make(map[string]chan struct{}),
+		rdb:             rdb,
+		keyPrefix:       cfg.KeyPrefix,
+		opts:            cfg.Hub,
+		roomStore:       cfg.Rooms,
+		storeTimeout:    cfg.StoreTimeout,
+		closeGrace:      cfg.CloseGrace,
+		chatCapacity:    cfg.ChatCapacity,
+		chatTTL:         cfg.ChatTTL,
+		closingWarnings: warnings,
+		usernameKey:     cfg.UsernameKey,
+		instanceID:      uuid.NewString(),
+		stopLeases:      make(chan struct{}),
+	}
+	go m.refreshLeases(m.stopLeases)
+	go m.sampleQueueDepths()
+	return m
+}
+
+// HubForRoom returns the room's hub, creating it if needed, or nil once the
+// manager is closed.
+func (m *hubManager) HubForRoom(code string) httpapi.Hub {
+	// Return a nil interface, not a nil *signaling.Hub, so callers' nil checks work.
+	if hub := m.hubForRoom(code); hub != nil {
+		return hub
+	}
+	return nil
+}
+
+func (m *hubManager) hubForRoom(code string) *signaling.Hub {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil
+	}
+
+	m.mu.Lock()
+	for {
+		if m.closed {
+			m.mu.Unlock()
+			return nil
+		}
+		if pending := m.creating[code]; pending != nil {
+			// Another join is building this room's hub; use that one.
+			m.mu.Unlock()
+			<-pending
+			m.mu.Lock()
+			continue
+		}
+		h := m.hubs[code]
+		if h == nil {
+			break
+		}
+		if h.cleaning != nil {
+			done := h.cleaning
+			m.mu.Unlock()
+			<-done
+			m.mu.Lock()
+			continue
+		}
+		if h.timer != nil {
+			h.timer.Stop()
+			h.timer = nil
+		}
+		h.gen++
+		hub := h.hub
+		m.mu.Unlock()
+		return hub
+	}
+	// Build the hub without holding mu: the room lock below may wait seconds on a
+	// contended room, which must not stall joins to every other room.
+	created := make(chan struct{})
+	m.creating[code] = created
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.creating, code)
+		close(created)
+		m.mu.Unlock()
+	}()
+
+	stores := m.roomStores(code)
+	presenceStore, bcastStore, namesStore := stores.presence, stores.bcast, stores.names
+
+	lockCtx, cancelLock := context.WithTimeout(context.Background(), 3*time.Second)
+	lock, lockErr := m.lockRoom(lockCtx, code)
+	cancelLock()
+	// The lock wait may have used up its deadline; the state work gets its own.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	// The room lock keeps another instance's reset or cleanup from interleaving with
+	// ours; without it we keep the state rather than risk wiping a live room.
+	if lockErr != nil {
+		log.Printf("room %s lock for reset: %v", code, lockErr)
+		m.acquireLease(ctx, code)
+	} else {
+		// Only clear leftover state when no other instance is serving this room.
+		if m.soleOwner(ctx, code) {
+			if err := presenceStore.Reset(ctx); err != nil {
+				log.Printf("presence reset for room %s: %v", code, err)
+			}
+			if err := bcastStore.Reset(ctx); err != nil {
+				log.Printf("broadcast reset for room %s: %v", code, err)
+			}
+			if err := namesStore.Reset(ctx); err != nil {
+				log.Printf("usernames reset for room %s: %v", code, err)
+			}
+		} else {
+			log.Printf("room %s is live on another instance, keeping its state", code)
+		}
+		m.acquireLease(ctx, code)
+		if err := lock.Release(); err != nil {
+			log.Printf("room %s lock release: %v", code, err)
+		}
+	}
+
+	opts := m.opts
+	getCtx, cancelGet := context.WithTimeout(context.Background(), 3*time.Second)
+	room, err := m.roomStore.Get(getCtx, code)
+	cancelGet()
+	if err != nil {
+		log.Printf("room lookup for hub %s: %v", code, err)
+	} else {
+		opts.Features = room.Features
+		opts.Paused = room.Paused
+		opts.Locked = room.Locked
+		opts.ICETransportPolicy = room.ICETransportPolicy
+		opts.Topology = room.Topology
+		opts.RequireName = room.RequireName
+	}
+	opts.Room = code
+	if opts.Logger == nil {
+		// Tag hub lines with the room so they can be told apart (and filtered in /admin/logs).
+		opts.Logger = log.New(log.Writer(), fmt.Sprintf("[room %s] ", code), log.Flags()|log.Lmsgprefix)
+	}
+	opts.OnEmpty = func() {
+		m.scheduleCleanup(code)
+	}
+	opts.OnLockChange = func(locked bool) {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		defer cancel()
+		if err := m.roomStore.SetLocked(ctx, code, locked); err != nil {
+			log.Printf("room %s lock=%v: %v", code, locked, err)
+		}
+	}
+	opts.Broadcasts = bcastStore
+	opts.Usernames = namesStore
+	// Chat history outlives hubs (that is its point), so it is not reset above.
+	if stores.chat != nil {
+		opts.ChatHistory = stores.chat
+	}
+
+	hub := signaling.NewHub(presenceStore, opts)
+	entry := &hubEntry{hub: hub, store: presenceStore, bcast: bcastStore, names: namesStore, chat: stores.chat}
+	m.mu.Lock()
+	if m.closed {
+		// Close ran while the hub was being built; it missed this one.
+		m.mu.Unlock()
+		hub.Shutdown()
+		m.releaseLease(ctx, code)
+		return nil
+	}
+	m.hubs[code] = entry
+	m.mu.Unlock()
+	go m.watchExpiry(code, entry)
+	return hub
+}
+
+// roomStoreSet holds the per-room Redis stores; chat is nil when history is disabled.
+type roomStoreSet struct {
+	presence presence.Store
+	bcast    broadcast.Store
+	names    usernames.Store
+	chat     chat.Store
+}
+
+func (m *hubManager) roomStores(code string) roomStoreSet {
+	prefix := fmt.Sprintf("%s:room:%s", m.keyPrefix, code)
+	s := roomStoreSet{
+		presence: presence.WithTimeout(presence.NewRedisStore(m.rdb, prefix), m.storeTimeout),
+		bcast:    broadcast.WithTimeout(broadcast.NewRedisStore(m.rdb, prefix), m.storeTimeout),
+	}
+	var names usernames.Store = usernames.NewRedisStore(m.rdb, prefix)
+	if len(m.usernameKey) > 0 {
+		// The key was validated at startup, so this cannot fail.
+		names, _ = usernames.WithEncryption(names, m.usernameKey, code)
+	}
+	s.names = usernames.WithTimeout(names, m.storeTimeout)
+	if m.chatCapacity > 0 {
+		s.chat = chat.WithTimeout(chat.NewRedisStore(m.rdb, prefix, m.chatCapacity, m.chatTTL), m.storeTimeout)
+	}
+	return s
+}
+
+// warmIdleTimeout is how long a warmed hub waits for its first peer before it is
+// cleaned up like any idle room.
+const warmIdleTimeout = 10 * time.Minute
+
+// WarmRoom starts code's hub ahead of its first peer (see httpapi.RoomWarmHandler)
+// and schedules its cleanup after warmIdleTimeout; the first join cancels it.
+func (m *hubManager) WarmRoom(code string) httpapi.Hub {
+	hub := m.hubForRoom(code)
+	if hub == nil {
+		return nil
+	}
+	if hub.ClientCount() == 0 {
+		m.scheduleCleanupAfter(strings.TrimSpace(code), warmIdleTimeout)
+	}
+	return hub
+}
+
+func (m *hubManager) scheduleCleanup(code string) {
+	m.scheduleCleanupAfter(code, 30*time.Second)
+}
+
+func (m *hubManager) scheduleCleanupAfter(code string, delay time.Duration) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	entry := m.hubs[code]
+	if entry == nil {
+		m.mu.Unlock()
+		return
+	}
+	if entry.timer != nil {
+		m.mu.Unlock()
+		return
+	}
+
+	gen := entry.gen
+	entry.timer = time.AfterFunc(delay, func() {
+		m.cleanupRoom(code, gen)
+	})
+	m.mu.Unlock()
+}
+
+// roomStateKeys lists the presence, broadcast and username keys under a room prefix.
+func (m *hubManager) roomStateKeys(prefix string) []string {
+	keys := presence.NewRedisStore(m.rdb, prefix).Keys()
+	keys = append(keys, broadcast.NewRedisStore(m.rdb, prefix).Keys()...)
+	return append(keys, usernames.NewRedisStore(m.rdb, prefix).Keys()...)
+}
+
+// renamedKey marks a renamed room with its new code, so hubs for it on other
+// instances can send their peers along (see watchExpiry).
+func (m *hubManager) renamedKey(code string) string {
+	return fmt.Sprintf("%s:room:%s:renamed", m.keyPrefix, code)
+}
+
+// RenameRoom moves a room (with its chat history, audit log, and presence,
+// broadcast and username state) to newCode and sends this instance's peers a
+// "room-renamed" notice before disconnecting them; other instances do the same
+// for theirs within expiryPoll. The old hub then empties and is cleaned up like
+// any idle room. A newCode that belongs to a closing room is reclaimed (see
+// rooms.Store.Rename).
+func (m *hubManager) RenameRoom(ctx context.Context, oldCode, newCode string) error {
+	oldPrefix := fmt.Sprintf("%s:room:%s", m.keyPrefix, oldCode)
+	newPrefix := fmt.Sprintf("%s:room:%s", m.keyPrefix, newCode)
+	moves := map[string]string{
+		chat.Key(oldPrefix):  chat.Key(newPrefix),
+		audit.Key(oldPrefix): audit.Key(newPrefix),
+	}
+	oldKeys, newKeys := m.roomStateKeys(oldPrefix), m.roomStateKeys(newPrefix)
+	for i := range oldKeys {
+		moves[oldKeys[i]] = newKeys[i]
+	}
+	// Hold the target's room lock so a cleanup of newCode in progress elsewhere
+	// finishes (leaving the code closing, hence reclaimable) before we take it over,
+	// rather than soft-deleting the renamed room afterwards.
+	lock, err := m.lockRoom(ctx, newCode)
+	if err != nil {
+		return fmt.Errorf("lock room %s: %w", newCode, err)
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Printf("room %s lock release: %v", newCode, err)
+		}
+	}()
+	if err := m.roomStore.Rename(ctx, oldCode, newCode, moves); err != nil {
+		return err
+	}
+	if err := m.rdb.Set(ctx, m.renamedKey(oldCode), newCode, 2*expiryPoll).Err(); err != nil {
+		log.Printf("room %s rename marker: %v", oldCode, err)
+	}
+	if len(m.usernameKey) > 0 {
+		m.resealUsernames(ctx, newPrefix, oldCode, newCode)
+	}
+	m.mu.Lock()
+	entry := m.hubs[oldCode]
+	m.mu.Unlock()
+	if entry != nil {
+		entry.hub.Relocate(newCode)
+	}
+	return nil
+}
+
+// resealUsernames re-encrypts the names moved under prefix, which are still bound
+// to oldCode, for newCode (see usernames.WithEncryption).
+func (m *hubManager) resealUsernames(ctx context.Context, prefix, oldCode, newCode string) {
+	// The key was validated at startup, so these cannot fail.
+	from, _ := usernames.WithEncryption(usernames.NewRedisStore(m.rdb, prefix), m.usernameKey, oldCode)
+	to, _ := usernames.WithEncryption(usernames.NewRedisStore(m.rdb, prefix), m.usernameKey, newCode)
+	names, err := from.Usernames(ctx)
+	if err == nil && len(names) > 0 {
+		err = to.SetUsernames(ctx, names)
+	}
+	if err != nil {
+		log.Printf("room %s usernames re-encrypt: %v", newCode, err)
+	}
+}
+
+// Migrate sends the peers of every hub on this instance to url (see Hub.Migrate)
+// and returns how many were told.
+func (m *hubManager) Migrate(url string) int {
+	m.mu.Lock()
+	hubs := make([]*signaling.Hub, 0, len(m.hubs))
+	for _, entry := range m.hubs {
+		hubs = append(hubs, entry.hub)
+	}
+	m.mu.Unlock()
+
+	total := 0
+	for _, h := range hubs {
+		total += h.Migrate(url)
+	}
+	return total
+}
+
+// ExistingHub returns the room's hub if one is running on this instance, without creating it.
+func (m *hubManager) ExistingHub(code string) httpapi.Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry := m.hubs[strings.TrimSpace(code)]; entry != nil {
+		return entry.hub
+	}
+	return nil
+}
+
+// HubCount returns the number of hubs currently instantiated.
+func (m *hubManager) HubCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.hubs)
+}
+
+// ClientTotal sums the in-memory connection count of every hub.
+func (m *hubManager) ClientTotal() int {
+	m.mu.Lock()
+	hubs := make([]*signaling.Hub, 0, len(m.hubs))
+	for _, entry := range m.hubs {
+		hubs = append(hubs, entry.hub)
+	}
+	m.mu.Unlock()
+
+	total := 0
+	for _, h := range hubs {
+		total += h.ClientCount()
+	}
+	return total
+}
+
+// Close stops every pending cleanup timer and prevents new ones, so no cleanup runs
+// against a Redis client that is shutting down, and shuts every hub down so late
+// WebSocket upgrades get a 503 instead of joining a dead hub.
+func (m *hubManager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return
+	}
+	m.closed = true
+	close(m.stopLeases)
+	codes := make([]string, 0, len(m.hubs))
+	hubs := make([]*signaling.Hub, 0, len(m.hubs))
+	for code, entry := range m.hubs {
+		hubs = append(hubs, entry.hub)
+		if entry.timer != nil {
+			entry.timer.Stop()
+			entry.timer = nil
+		}
+		codes = append(codes, code)
+	}
+	m.dropLeases(codes)
+	// Shutdown only queues close frames, so holding mu here is safe.
+	for _, h := range hubs {
+		h.Shutdown()
+	}
+}
+
+// queueSampleInterval is how often the MetricQueueDepthMax gauge is refreshed.
+const queueSampleInterval = 10 * time.Second
+
+// sampleQueueDepths keeps the MetricQueueDepthMax gauge current until the manager
+// is closed. It does nothing without a Stats sink.
+func (m *hubManager) sampleQueueDepths() {
+	if m.opts.Stats == nil {
+		return
+	}
+	ticker := time.NewTicker(queueSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stopLeases: // closed by Close
+			return
+		case <-ticker.C:
+		}
+		m.MaxQueueDepth()
+	}
+}
+
+// MaxQueueDepth samples every hub's connections, returns the deepest outbound queue
+// and reports it as the MetricQueueDepthMax gauge.
+func (m *hubManager) MaxQueueDepth() int {
+	m.mu.Lock()
+	hubs := make([]*signaling.Hub, 0, len(m.hubs))
+	for _, entry := range m.hubs {
+		hubs = append(hubs, entry.hub)
+	}
+	m.mu.Unlock()
+
+	deepest := 0
+	for _, h := range hubs {
+		for _, d := range h.QueueDepths() {
+			deepest = max(deepest, d.Send, d.Signal)
+		}
+	}
+	if m.opts.Stats != nil {
+		m.opts.Stats.SetGauge(signaling.MetricQueueDepthMax, float64(deepest))
+	}
+	return deepest
+}
+
+// roomLockTTL bounds how long a crashed instance can hold a room's lock.
+const roomLockTTL = 10 * time.Second
+
+// lockRoom serializes destructive per-room operations (state reset, cleanup) across instances.
+func (m *hubManager) lockRoom(ctx context.Context, code string) (*redislock.Lock, error) {
+	return redislock.Acquire(ctx, m.rdb, fmt.Sprintf("%s:room:%s:lock", m.keyPrefix, code), roomLockTTL)
+}
+
+func (m *hubManager) cleanupRoom(code string, gen uint64) {
+	m.mu.Lock()
+	entry := m.hubs[code]
+	if m.closed || entry == nil || entry.gen != gen {
+		// Shutting down, or a join reclaimed the hub after this timer was scheduled.
+		m.mu.Unlock()
+		return
+	}
+	entry.timer = nil
+	if entry.hub.ClientCount() > 0 {
+		m.mu.Unlock()
+		return
+	}
+	// Joins arriving from here on wait for the cleanup instead of reusing a hub whose
+	// stores are about to be reset.
+	entry.cleaning = make(chan struct{})
+	m.mu.Unlock()
+
+	removed, retry := false, false
+	defer func() {
+		m.mu.Lock()
+		if removed {
+			delete(m.hubs, code)
+		}
+		close(entry.cleaning)
+		entry.cleaning = nil
+		m.mu.Unlock()
+		if retry {
+			// Still idle and still ours: try again later rather than leak the hub.
+			m.scheduleCleanup(code)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lock, err := m.lockRoom(ctx, code)
+	if err != nil {
+		log.Printf("room %s lock for cleanup: %v", code, err)
+		retry = true
+		return
+	}
+	defer func() {
+		if err := lock.Release(); err != nil {
+			log.Printf("room %s lock release: %v", code, err)
+		}
+	}()
+
+	peers, err := entry.store.Peers(ctx)
+	if err != nil {
+		log.Printf("cleanup state error for room %s: %v", code, err)
+	}
+	if len(peers) > 0 {
+		return
+	}
+	if !m.soleOwner(ctx, code) {
+		// Another instance holds a hub for this room (a join may be in flight there):
+		// drop only our local hub and leave the shared state and the room alone.
+		m.releaseLease(ctx, code)
+		removed = true
+		log.Printf("room %s idle here but live on another instance, dropping local hub", code)
+		return
+	}
+
+	if err := entry.store.Reset(ctx); err != nil {
+		log.Printf("cleanup presence reset failed for room %s: %v", code, err)
+	}
+	if err := entry.bcast.Reset(ctx); err != nil {
+		log.Printf("cleanup broadcast reset failed for room %s: %v", code, err)
+	}
+	if err := entry.names.Reset(ctx); err != nil {
+		log.Printf("cleanup usernames reset failed for room %s: %v", code, err)
+	}
+	if m.closeGrace > 0 {
+		// Keep chat history while the room can still be rejoined; its TTL bounds it.
+		if err := m.roomStore.MarkClosing(ctx, code, m.closeGrace); err != nil && !errors.Is(err, rooms.ErrNotFound) {
+			log.Printf("cleanup room soft-delete failed for room %s: %v", code, err)
+		}
+	} else {
+		if err := m.roomStore.Delete(ctx, code); err != nil && !errors.Is(err, rooms.ErrNotFound) {
+			log.Printf("cleanup room delete failed for room %s: %v", code, err)
+		}
+		if entry.chat != nil {
+			if err := entry.chat.Reset(ctx); err != nil {
+				log.Printf("cleanup chat history reset failed for room %s: %v", code, err)
+			}
+		}
+	}
+
+	m.releaseLease(ctx, code)
+	removed = true
+	log.Printf("room %s cleaned up after inactivity", code)
+}
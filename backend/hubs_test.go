@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"videochat/internal/app/rooms"
+	"videochat/pkg/presence"
+	"videochat/pkg/webrtc/signaling"
+)
+
+func newTestManager(t *testing.T) (*hubManager, *redis.Client, rooms.Store) {
+	t.Helper()
+	return newTestManagerWith(t, hubManagerConfig{})
+}
+
+// newTestManagerWith builds a manager on a fresh miniredis; cfg's key prefix, room
+// store and logger are filled in.
+func newTestManagerWith(t *testing.T, cfg hubManagerConfig) (*hubManager, *redis.Client, rooms.Store) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	roomStore := rooms.NewRedisStore(rdb, "webrtc")
+	cfg.KeyPrefix = "webrtc"
+	cfg.Rooms = roomStore
+	cfg.Hub.Logger = log.New(io.Discard, "", 0)
+	m := newHubManager(rdb, cfg)
+	t.Cleanup(func() {
+		m.Close()
+		rdb.Close()
+	})
+	return m, rdb, roomStore
+}
+
+func createRoom(t *testing.T, store rooms.Store) string {
+	t.Helper()
+	room, err := store.Create(context.Background(), rooms.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return room.Code
+}
+
+func holdRoomLock(t *testing.T, rdb *redis.Client, code string) {
+	t.Helper()
+	key := fmt.Sprintf("webrtc:room:%s:lock", code)
+	if err := rdb.Set(context.Background(), key, "elsewhere", time.Minute).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHubForRoomSharesOneHubPerRoom(t *testing.T) {
+	m, _, store := newTestManager(t)
+	code := createRoom(t, store)
+
+	hubs := make([]*signaling.Hub, 8)
+	var wg sync.WaitGroup
+	for i := range hubs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			hubs[i] = m.hubForRoom(code)
+		}(i)
+	}
+	wg.Wait()
+	for i, h := range hubs {
+		if h == nil || h != hubs[0] {
+			t.Fatalf("join %d got hub %p, want %p", i, h, hubs[0])
+		}
+	}
+	if n := m.HubCount(); n != 1 {
+		t.Fatalf("HubCount = %d, want 1", n)
+	}
+}
+
+func TestHubForRoomLockWaitKeepsStateAndPolicy(t *testing.T) {
+	m, rdb, store := newTestManager(t)
+	ctx := context.Background()
+	contended, other := createRoom(t, store), createRoom(t, store)
+	if err := store.SetPaused(ctx, contended, true); err != nil {
+		t.Fatal(err)
+	}
+	peers := presence.NewRedisStore(rdb, "webrtc:room:"+contended)
+	if err := peers.AddPeer(ctx, "remote-peer"); err != nil {
+		t.Fatal(err)
+	}
+	holdRoomLock(t, rdb, contended)
+
+	done := make(chan *signaling.Hub, 1)
+	go func() { done <- m.hubForRoom(contended) }()
+	time.Sleep(100 * time.Millisecond)
+
+	// The contended room waits on its lock without holding up other rooms.
+	start := time.Now()
+	if m.hubForRoom(other) == nil {
+		t.Fatal("no hub for the uncontended room")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Fatalf("uncontended room waited %s behind another room's lock", waited)
+	}
+
+	var hub *signaling.Hub
+	select {
+	case hub = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("hubForRoom never gave up on the lock")
+	}
+	if hub == nil {
+		t.Fatal("no hub for the contended room")
+	}
+	// The lock wait used up its own deadline, not the room lookup's.
+	if !hub.Paused() {
+		t.Fatal("room policy was dropped: hub not paused")
+	}
+	// Without the lock the shared state must be left alone.
+	got, err := peers.Peers(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "remote-peer" {
+		t.Fatalf("presence = %v, want it kept", got)
+	}
+}
+
+func TestCleanupRoomReschedulesWhenLocked(t *testing.T) {
+	m, rdb, store := newTestManager(t)
+	code := createRoom(t, store)
+	if m.hubForRoom(code) == nil {
+		t.Fatal("no hub")
+	}
+	m.mu.Lock()
+	gen := m.hubs[code].gen
+	m.mu.Unlock()
+
+	holdRoomLock(t, rdb, code)
+	m.cleanupRoom(code, gen)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry := m.hubs[code]
+	if entry == nil {
+		t.Fatal("hub dropped although cleanup could not take the lock")
+	}
+	if entry.cleaning != nil {
+		t.Fatal("cleanup left the entry marked as cleaning")
+	}
+	if entry.timer == nil {
+		t.Fatal("cleanup was not rescheduled")
+	}
+}
@@ -0,0 +1,112 @@
+// Package analytics forwards room events (joins, leaves, broadcasts, chat) to a
+// Redis Stream for an analytics pipeline to consume.
+package analytics
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"videochat/pkg/webrtc/signaling"
+)
+
+// writeTimeout bounds each XADD so a stalled Redis only delays the queue.
+const writeTimeout = 3 * time.Second
+
+// StreamSink appends events to the stream "{keyPrefix}:analytics" from a single
+// background writer. Emit never blocks: when the queue is full the event is
+// dropped and counted, so analytics lag cannot stall signaling.
+type StreamSink struct {
+	rdb     *redis.Client
+	key     string
+	maxLen  int64
+	dropped atomic.Uint64
+	done    chan struct{}
+	// mu guards queue against Emit racing Close; closed is set once queue is closed.
+	mu     sync.RWMutex
+	queue  chan signaling.Event
+	closed bool
+}
+
+// NewStreamSink starts a sink that queues up to buffer events and trims the
+// stream to roughly maxLen entries (non-positive = untrimmed).
+func NewStreamSink(rdb *redis.Client, keyPrefix string, maxLen, buffer int) *StreamSink {
+	p := strings.TrimSuffix(strings.TrimSpace(keyPrefix), ":")
+	if p == "" {
+		p = "webrtc"
+	}
+	if buffer <= 0 {
+		buffer = 1024
+	}
+	s := &StreamSink{
+		rdb:    rdb,
+		key:    p + ":analytics",
+		maxLen: int64(maxLen),
+		queue:  make(chan signaling.Event, buffer),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *StreamSink) Emit(e signaling.Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- e:
+	default:
+		// Logged at powers of two so a long outage doesn't flood the log.
+		if n := s.dropped.Add(1); n&(n-1) == 0 {
+			log.Printf("analytics: queue full, %d events dropped", n)
+		}
+	}
+}
+
+// Dropped returns how many events were discarded because the queue was full.
+func (s *StreamSink) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops accepting events (later ones are discarded) and waits for the
+// queued ones to be written.
+func (s *StreamSink) Close() {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.mu.Unlock()
+	<-s.done
+}
+
+func (s *StreamSink) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		args := &redis.XAddArgs{
+			Stream: s.key,
+			Values: map[string]interface{}{
+				"room": e.Room,
+				"peer": e.Peer,
+				"type": e.Type,
+				"ts":   e.Time.UnixMilli(),
+			},
+		}
+		if s.maxLen > 0 {
+			args.MaxLen = s.maxLen
+			args.Approx = true
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		if err := s.rdb.XAdd(ctx, args).Err(); err != nil {
+			log.Printf("analytics: xadd: %v", err)
+		}
+		cancel()
+	}
+}
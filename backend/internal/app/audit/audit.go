@@ -0,0 +1,109 @@
+// Package audit records administrative actions taken on rooms (pause, rename,
+// imports, ...) so they can be reviewed later.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Entry is one audited action.
+type Entry struct {
+	Time time.Time `json:"time"`
+	Room string    `json:"room"`
+	// Action names what was done, e.g. "pause", "rename", "import".
+	Action string `json:"action"`
+	// Actor identifies who did it: the X-Admin-Actor header when sent, else "admin".
+	Actor string `json:"actor"`
+	// IP is the caller's address.
+	IP string `json:"ip,omitempty"`
+	// Target is what the action applied to (a peer ID, the new code, ...), if anything.
+	Target string `json:"target,omitempty"`
+	// Detail carries action-specific context such as a duration or count.
+	Detail string `json:"detail,omitempty"`
+}
+
+// Logger stores audit entries per room.
+type Logger interface {
+	Record(ctx context.Context, e Entry) error
+	// Recent returns up to limit entries for room, newest first (limit <= 0 = all kept).
+	Recent(ctx context.Context, room string, limit int) ([]Entry, error)
+}
+
+// Nop discards entries; used when auditing is disabled.
+type Nop struct{}
+
+func (Nop) Record(context.Context, Entry) error { return nil }
+
+func (Nop) Recent(context.Context, string, int) ([]Entry, error) { return nil, nil }
+
+// RedisLogger keeps a capped list of entries per room that expires ttl after the
+// last entry.
+type RedisLogger struct {
+	rdb      *redis.Client
+	prefix   string
+	capacity int
+	ttl      time.Duration
+}
+
+// NewRedisLogger stores up to capacity entries per room under
+// "{keyPrefix}:room:{code}:audit". A non-positive ttl keeps lists forever.
+func NewRedisLogger(rdb *redis.Client, keyPrefix string, capacity int, ttl time.Duration) *RedisLogger {
+	p := strings.TrimSuffix(strings.TrimSpace(keyPrefix), ":")
+	if p == "" {
+		p = "webrtc"
+	}
+	return &RedisLogger{rdb: rdb, prefix: p, capacity: capacity, ttl: ttl}
+}
+
+// Key returns the Redis key holding the audit log for a room prefix
+// (e.g. "webrtc:room:abc123"), for moving it along with a renamed room.
+func Key(roomPrefix string) string {
+	return fmt.Sprintf("%s:audit", strings.TrimSuffix(strings.TrimSpace(roomPrefix), ":"))
+}
+
+func (l *RedisLogger) key(room string) string {
+	return Key(fmt.Sprintf("%s:room:%s", l.prefix, room))
+}
+
+func (l *RedisLogger) Record(ctx context.Context, e Entry) error {
+	raw, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	key := l.key(e.Room)
+	_, err = l.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, raw)
+		pipe.LTrim(ctx, key, 0, int64(l.capacity-1))
+		if l.ttl > 0 {
+			pipe.Expire(ctx, key, l.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+func (l *RedisLogger) Recent(ctx context.Context, room string, limit int) ([]Entry, error) {
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(limit - 1)
+	}
+	vals, err := l.rdb.LRange(ctx, l.key(room), 0, stop).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Entry, 0, len(vals))
+	for _, v := range vals {
+		var e Entry
+		if err := json.Unmarshal([]byte(v), &e); err != nil {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out, nil
+}
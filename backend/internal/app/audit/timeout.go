@@ -0,0 +1,32 @@
+package audit
+
+import (
+	"context"
+	"time"
+)
+
+// WithTimeout wraps l so every call runs under a context bounded by d.
+// A non-positive d returns l unchanged.
+func WithTimeout(l Logger, d time.Duration) Logger {
+	if d <= 0 {
+		return l
+	}
+	return &timeoutLogger{next: l, timeout: d}
+}
+
+type timeoutLogger struct {
+	next    Logger
+	timeout time.Duration
+}
+
+func (l *timeoutLogger) Record(ctx context.Context, e Entry) error {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	return l.next.Record(ctx, e)
+}
+
+func (l *timeoutLogger) Recent(ctx context.Context, room string, limit int) ([]Entry, error) {
+	ctx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+	return l.next.Recent(ctx, room, limit)
+}
@@ -2,6 +2,7 @@ package broadcast
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -13,13 +14,17 @@ type Store interface {
 	Reset(ctx context.Context) error
 	RemovePeer(ctx context.Context, id string) error
 	SetBroadcast(ctx context.Context, id string, enabled bool) error
+	SetBroadcastCapped(ctx context.Context, id string, max int) (bool, error)
 	Broadcasting(ctx context.Context) ([]string, error)
+	SetBroadcastMeta(ctx context.Context, id string, meta []byte) error
+	BroadcastMeta(ctx context.Context) (map[string]json.RawMessage, error)
 }
 
-// RedisStore implements Store using a Redis set.
+// RedisStore implements Store using a Redis set, with stream metadata in a hash.
 type RedisStore struct {
 	rdb           *redis.Client
 	keyBroadcasts string
+	keyMeta       string
 }
 
 // NewRedisStore builds a Store backed by Redis. Prefix is optional (e.g., "webrtc:room:abc123").
@@ -31,22 +36,55 @@ func NewRedisStore(rdb *redis.Client, prefix string) *RedisStore {
 	return &RedisStore{
 		rdb:           rdb,
 		keyBroadcasts: fmt.Sprintf("%s:broadcasting", p),
+		keyMeta:       fmt.Sprintf("%s:broadcast_meta", p),
 	}
 }
 
+// Keys lists the Redis keys the store writes, e.g. for moving them with a renamed room.
+func (s *RedisStore) Keys() []string {
+	return []string{s.keyBroadcasts, s.keyMeta}
+}
+
 func (s *RedisStore) Reset(ctx context.Context) error {
-	return s.rdb.Del(ctx, s.keyBroadcasts).Err()
+	return s.rdb.Del(ctx, s.keyBroadcasts, s.keyMeta).Err()
 }
 
 func (s *RedisStore) RemovePeer(ctx context.Context, id string) error {
-	return s.rdb.SRem(ctx, s.keyBroadcasts, id).Err()
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, s.keyBroadcasts, id)
+		pipe.HDel(ctx, s.keyMeta, id)
+		return nil
+	})
+	return err
 }
 
 func (s *RedisStore) SetBroadcast(ctx context.Context, id string, enabled bool) error {
 	if enabled {
 		return s.rdb.SAdd(ctx, s.keyBroadcasts, id).Err()
 	}
-	return s.rdb.SRem(ctx, s.keyBroadcasts, id).Err()
+	return s.RemovePeer(ctx, id)
+}
+
+// setCappedScript adds ARGV[1] to the set unless it already holds ARGV[2] other members.
+var setCappedScript = redis.NewScript(`
+if redis.call('SISMEMBER', KEYS[1], ARGV[1]) == 1 then
+	return 1
+end
+if redis.call('SCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('SADD', KEYS[1], ARGV[1])
+return 1
+`)
+
+// SetBroadcastCapped atomically marks id as broadcasting unless max peers already are,
+// reporting whether id is broadcasting afterwards.
+func (s *RedisStore) SetBroadcastCapped(ctx context.Context, id string, max int) (bool, error) {
+	ok, err := setCappedScript.Run(ctx, s.rdb, []string{s.keyBroadcasts}, id, max).Int()
+	if err != nil {
+		return false, err
+	}
+	return ok == 1, nil
 }
 
 func (s *RedisStore) Broadcasting(ctx context.Context) ([]string, error) {
@@ -56,3 +94,22 @@ func (s *RedisStore) Broadcasting(ctx context.Context) ([]string, error) {
 	}
 	return vals, nil
 }
+
+// SetBroadcastMeta stores an opaque JSON blob describing id's stream; it is cleared
+// when the peer stops broadcasting or leaves.
+func (s *RedisStore) SetBroadcastMeta(ctx context.Context, id string, meta []byte) error {
+	return s.rdb.HSet(ctx, s.keyMeta, id, meta).Err()
+}
+
+// BroadcastMeta returns the stored metadata blobs keyed by peer ID.
+func (s *RedisStore) BroadcastMeta(ctx context.Context) (map[string]json.RawMessage, error) {
+	vals, err := s.rdb.HGetAll(ctx, s.keyMeta).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]json.RawMessage, len(vals))
+	for id, raw := range vals {
+		out[id] = json.RawMessage(raw)
+	}
+	return out, nil
+}
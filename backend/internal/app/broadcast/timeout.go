@@ -0,0 +1,63 @@
+package broadcast
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// WithTimeout wraps s so every call runs under a context bounded by d.
+// A non-positive d returns s unchanged.
+func WithTimeout(s Store, d time.Duration) Store {
+	if d <= 0 {
+		return s
+	}
+	return &timeoutStore{next: s, timeout: d}
+}
+
+type timeoutStore struct {
+	next    Store
+	timeout time.Duration
+}
+
+func (s *timeoutStore) Reset(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Reset(ctx)
+}
+
+func (s *timeoutStore) RemovePeer(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.RemovePeer(ctx, id)
+}
+
+func (s *timeoutStore) SetBroadcast(ctx context.Context, id string, enabled bool) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.SetBroadcast(ctx, id, enabled)
+}
+
+func (s *timeoutStore) SetBroadcastCapped(ctx context.Context, id string, max int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.SetBroadcastCapped(ctx, id, max)
+}
+
+func (s *timeoutStore) Broadcasting(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Broadcasting(ctx)
+}
+
+func (s *timeoutStore) SetBroadcastMeta(ctx context.Context, id string, meta []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.SetBroadcastMeta(ctx, id, meta)
+}
+
+func (s *timeoutStore) BroadcastMeta(ctx context.Context) (map[string]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.BroadcastMeta(ctx)
+}
@@ -0,0 +1,77 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Store keeps a room's recent chat messages so they can be replayed to joiners.
+// Only relayed chat frames are stored; signaling payloads never are.
+type Store interface {
+	Append(ctx context.Context, msg []byte) error
+	Recent(ctx context.Context) ([][]byte, error)
+	Reset(ctx context.Context) error
+}
+
+// RedisStore implements Store with a capped Redis list (newest first) that expires
+// ttl after the last message.
+type RedisStore struct {
+	rdb      *redis.Client
+	keyChat  string
+	capacity int
+	ttl      time.Duration
+}
+
+// NewRedisStore builds a Store keeping up to capacity messages under the provided
+// prefix (e.g., "webrtc:room:abc123"). A non-positive ttl keeps the list until reset.
+func NewRedisStore(rdb *redis.Client, prefix string, capacity int, ttl time.Duration) *RedisStore {
+	p := strings.TrimSuffix(strings.TrimSpace(prefix), ":")
+	if p == "" {
+		p = "webrtc"
+	}
+	return &RedisStore{
+		rdb:      rdb,
+		keyChat:  Key(p),
+		capacity: capacity,
+		ttl:      ttl,
+	}
+}
+
+// Key returns the Redis key holding the history for a room prefix, e.g. when moving
+// it along with a renamed room.
+func Key(prefix string) string {
+	return fmt.Sprintf("%s:chat", strings.TrimSuffix(strings.TrimSpace(prefix), ":"))
+}
+
+func (s *RedisStore) Append(ctx context.Context, msg []byte) error {
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, s.keyChat, msg)
+		pipe.LTrim(ctx, s.keyChat, 0, int64(s.capacity-1))
+		if s.ttl > 0 {
+			pipe.Expire(ctx, s.keyChat, s.ttl)
+		}
+		return nil
+	})
+	return err
+}
+
+// Recent returns the stored messages oldest first.
+func (s *RedisStore) Recent(ctx context.Context) ([][]byte, error) {
+	vals, err := s.rdb.LRange(ctx, s.keyChat, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make([][]byte, len(vals))
+	for i, v := range vals {
+		out[len(vals)-1-i] = []byte(v)
+	}
+	return out, nil
+}
+
+func (s *RedisStore) Reset(ctx context.Context) error {
+	return s.rdb.Del(ctx, s.keyChat).Err()
+}
@@ -0,0 +1,38 @@
+package chat
+
+import (
+	"context"
+	"time"
+)
+
+// WithTimeout wraps s so every call runs under a context bounded by d.
+// A non-positive d returns s unchanged.
+func WithTimeout(s Store, d time.Duration) Store {
+	if d <= 0 {
+		return s
+	}
+	return &timeoutStore{next: s, timeout: d}
+}
+
+type timeoutStore struct {
+	next    Store
+	timeout time.Duration
+}
+
+func (s *timeoutStore) Append(ctx context.Context, msg []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Append(ctx, msg)
+}
+
+func (s *timeoutStore) Recent(ctx context.Context) ([][]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Recent(ctx)
+}
+
+func (s *timeoutStore) Reset(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Reset(ctx)
+}
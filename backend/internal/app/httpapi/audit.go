@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"videochat/internal/app/audit"
+)
+
+const maxAuditActor = 64
+
+// recordAudit stores an audit entry for an admin action on room. It runs after the
+// action succeeded, on its own context so a client hanging up cannot skip it.
+func recordAudit(logger audit.Logger, r *http.Request, room, action, target, detail string) {
+	actor := strings.TrimSpace(r.Header.Get("X-Admin-Actor"))
+	if actor == "" {
+		actor = "admin"
+	}
+	if len(actor) > maxAuditActor {
+		actor = actor[:maxAuditActor]
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := logger.Record(ctx, audit.Entry{
+		Time:   time.Now().UTC(),
+		Room:   room,
+		Action: action,
+		Actor:  actor,
+		IP:     clientIP(r),
+		Target: target,
+		Detail: detail,
+	})
+	if err != nil {
+		log.Printf("audit record %s on room %s: %v", action, room, err)
+	}
+}
+
+// RoomAuditHandler returns a room's audit log, newest first
+// (GET /api/rooms/{code}/audit[?limit=N]).
+func RoomAuditHandler(logger audit.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		limit := 0
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeJSONError(w, http.StatusBadRequest, "limit must be a non-negative integer")
+				return
+			}
+			limit = n
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+		entries, err := logger.Recent(ctx, code, limit)
+		if err != nil {
+			log.Printf("audit read error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to read audit log")
+			return
+		}
+		if entries == nil {
+			entries = []audit.Entry{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"room": code, "entries": entries})
+	})
+}
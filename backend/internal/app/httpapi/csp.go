@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SecurityHeaders sets Content-Security-Policy and related hardening headers on SPA
+// responses. An empty policy builds a default whose connect-src allows the page's own
+// origin, the advertised WebSocket origin and the configured STUN/TURN hosts.
+func SecurityHeaders(policy string, settings Settings, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		csp := policy
+		if csp == "" {
+			csp = defaultCSP(settings, r)
+		}
+		w.Header().Set("Content-Security-Policy", csp)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// Room URLs carry the private room code; keep it out of cross-origin referrers.
+		w.Header().Set("Referrer-Policy", "same-origin")
+		h.ServeHTTP(w, r)
+	})
+}
+
+func defaultCSP(settings Settings, r *http.Request) string {
+	connect := []string{"'self'"}
+	if u, err := url.Parse(resolveWSURL(settings, r)); err == nil && u.Host != "" {
+		connect = append(connect, u.Scheme+"://"+u.Host)
+	}
+	seen := make(map[string]bool)
+	for _, s := range settings.ICEServers {
+		for _, raw := range s.URLs {
+			if host := iceHost(raw); host != "" && !seen[host] {
+				seen[host] = true
+				connect = append(connect, host)
+			}
+		}
+	}
+	return strings.Join([]string{
+		"default-src 'self'",
+		"connect-src " + strings.Join(connect, " "),
+		"img-src 'self' data: blob:",
+		"media-src 'self' blob: mediastream:",
+		"style-src 'self' 'unsafe-inline'",
+		"frame-ancestors 'self'",
+		"base-uri 'self'",
+		"object-src 'none'",
+	}, "; ")
+}
+
+// iceHost extracts host[:port] from a STUN/TURN URL such as "turn:host:3478?transport=udp".
+func iceHost(raw string) string {
+	_, rest, ok := strings.Cut(strings.TrimSpace(raw), ":")
+	if !ok {
+		return ""
+	}
+	rest, _, _ = strings.Cut(rest, "?")
+	rest = strings.TrimPrefix(rest, "//")
+	if rest == "" || strings.ContainsAny(rest, " ;,'") {
+		return ""
+	}
+	return rest
+}
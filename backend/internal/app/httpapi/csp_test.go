@@ -0,0 +1,88 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"videochat/pkg/webrtc/protocol"
+)
+
+// connectSrc returns the sources listed in policy's connect-src directive.
+func connectSrc(t *testing.T, policy string) []string {
+	t.Helper()
+	for _, d := range strings.Split(policy, ";") {
+		fields := strings.Fields(d)
+		if len(fields) > 0 && fields[0] == "connect-src" {
+			return fields[1:]
+		}
+	}
+	t.Fatalf("no connect-src in %q", policy)
+	return nil
+}
+
+func hasSource(sources []string, want string) bool {
+	for _, s := range sources {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+func serveSPA(h http.Handler) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://chat.example/rooms/ABC123", nil))
+	return rec
+}
+
+func TestSecurityHeadersDefaultPolicy(t *testing.T) {
+	settings := Settings{ICEServers: []protocol.ICEServer{
+		{URLs: []string{"stun:stun.example:3478", "turn:turn.example:3478?transport=udp"}},
+		{URLs: []string{"turns:turn.example:3478?transport=tcp"}},
+	}}
+	rec := serveSPA(SecurityHeaders("", settings, http.NotFoundHandler()))
+
+	if got := rec.Header().Get("X-Content-Type-Options"); got != "nosniff" {
+		t.Errorf("X-Content-Type-Options = %q", got)
+	}
+	if got := rec.Header().Get("Referrer-Policy"); got != "same-origin" {
+		t.Errorf("Referrer-Policy = %q", got)
+	}
+	policy := rec.Header().Get("Content-Security-Policy")
+	if !strings.Contains(policy, "default-src 'self'") || !strings.Contains(policy, "object-src 'none'") {
+		t.Errorf("policy = %q, want the default directives", policy)
+	}
+	sources := connectSrc(t, policy)
+	for _, want := range []string{"'self'", "ws://chat.example", "stun.example:3478", "turn.example:3478"} {
+		if !hasSource(sources, want) {
+			t.Errorf("connect-src %v is missing %s", sources, want)
+		}
+	}
+	if len(sources) != 4 {
+		t.Errorf("connect-src %v should list each ICE host once", sources)
+	}
+}
+
+func TestSecurityHeadersUsePublicWSURL(t *testing.T) {
+	settings := Settings{PublicWSURL: "wss://signal.example:8443/ws"}
+	rec := serveSPA(SecurityHeaders("", settings, http.NotFoundHandler()))
+	sources := connectSrc(t, rec.Header().Get("Content-Security-Policy"))
+	if !hasSource(sources, "wss://signal.example:8443") {
+		t.Fatalf("connect-src %v is missing the public WebSocket origin", sources)
+	}
+	if hasSource(sources, "ws://chat.example") {
+		t.Fatalf("connect-src %v lists the request host instead of PUBLIC_WS_URL", sources)
+	}
+}
+
+func TestSecurityHeadersCustomPolicy(t *testing.T) {
+	rec := serveSPA(SecurityHeaders("default-src 'none'", Settings{}, http.NotFoundHandler()))
+	if got := rec.Header().Get("Content-Security-Policy"); got != "default-src 'none'" {
+		t.Fatalf("policy = %q, want the configured one verbatim", got)
+	}
+	if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Fatal("related headers should be set with a custom policy too")
+	}
+}
@@ -0,0 +1,154 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"videochat/internal/app/rooms"
+	"videochat/pkg/webrtc/protocol"
+)
+
+const (
+	// eventsResync re-reads the room even without a change notification, to pick up
+	// a hub started after the observer connected or state changed on another instance.
+	eventsResync    = 5 * time.Second
+	eventsKeepAlive = 15 * time.Second
+)
+
+// eventObservers counts open event streams per room so one room can't tie up
+// unbounded connections. A non-positive max allows any number.
+type eventObservers struct {
+	mu     sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+func (o *eventObservers) acquire(code string) bool {
+	if o.max <= 0 {
+		return true
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.counts[code] >= o.max {
+		return false
+	}
+	o.counts[code]++
+	return true
+}
+
+func (o *eventObservers) release(code string) {
+	if o.max <= 0 {
+		return
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if n := o.counts[code]; n > 1 {
+		o.counts[code] = n - 1
+	} else {
+		delete(o.counts, code)
+	}
+}
+
+// RoomEventsHandler streams a room's presence as Server-Sent Events
+// (GET /api/rooms/{code}/events), emitting a "snapshot" event on connect and whenever
+// the roster, broadcasters or usernames change, as notified by the room's hub on
+// this instance (see signaling.Hub.Watch). Observers never join the room, so they
+// don't count toward occupancy or keep the hub alive; at most maxObservers may follow
+// one room at a time (0 = unlimited), others get 429.
+func RoomEventsHandler(hubs HubManager, store rooms.Store, maxObservers int) http.Handler {
+	observers := &eventObservers{max: maxObservers, counts: make(map[string]int)}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			writeJSONError(w, http.StatusInternalServerError, "streaming unsupported")
+			return
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		lookupCtx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		_, err := store.Get(lookupCtx, code)
+		cancel()
+		if err != nil {
+			if errors.Is(err, rooms.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			log.Printf("room events lookup error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to lookup room")
+			return
+		}
+		if !observers.acquire(code) {
+			writeJSONError(w, http.StatusTooManyRequests, "too many observers for this room")
+			return
+		}
+		defer observers.release(code)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		var (
+			hub     Hub
+			changed <-chan struct{}
+			stop    = func() {}
+		)
+		defer func() { stop() }()
+		resync := time.NewTicker(eventsResync)
+		defer resync.Stop()
+		lastWrite := time.Now()
+		var last []byte
+		for {
+			// Follow the hub running now: it may have started, or been replaced, since.
+			if cur := hubs.ExistingHub(code); cur != hub {
+				stop()
+				hub, changed, stop = cur, nil, func() {}
+				if hub != nil {
+					changed, stop = hub.Watch()
+				}
+			}
+			snapshot := protocol.StateMessage{Type: "snapshot"}
+			if hub != nil {
+				snapshot = hub.Snapshot(r.Context())
+			}
+			payload, err := json.Marshal(snapshot)
+			if err != nil {
+				log.Printf("room events encode: %v", err)
+				return
+			}
+			if !bytes.Equal(payload, last) {
+				if _, err := fmt.Fprintf(w, "event: snapshot\ndata: %s\n\n", payload); err != nil {
+					return
+				}
+				flusher.Flush()
+				last, lastWrite = payload, time.Now()
+			} else if time.Since(lastWrite) >= eventsKeepAlive {
+				if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+					return
+				}
+				flusher.Flush()
+				lastWrite = time.Now()
+			}
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-changed:
+			case <-resync.C:
+			}
+		}
+	})
+}
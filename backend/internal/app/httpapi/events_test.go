@@ -0,0 +1,173 @@
+package httpapi
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+
+	"videochat/internal/app/rooms"
+	"videochat/pkg/presence"
+	"videochat/pkg/webrtc/protocol"
+	"videochat/pkg/webrtc/signaling"
+)
+
+// oneHub serves a single real hub for every room, or none while hub is nil.
+type oneHub struct {
+	HubManager
+	hub *signaling.Hub
+}
+
+func (m *oneHub) ExistingHub(string) Hub {
+	if m.hub == nil {
+		return nil
+	}
+	return m.hub
+}
+
+// newEventsServer starts a room with a running hub, the events endpoint and a
+// WebSocket endpoint joining that hub. It returns the events URL and the ws URL.
+func newEventsServer(t *testing.T, maxObservers int) (eventsURL, wsURL string) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	store := rooms.NewRedisStore(rdb, "test")
+	code := createTestRoom(t, store)
+	hub := signaling.NewHub(presence.NewRedisStore(rdb, "test:room:"+code), signaling.HubOptions{
+		Logger: log.New(io.Discard, "", 0),
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle("/api/rooms/{code}/events", RoomEventsHandler(&oneHub{hub: hub}, store, maxObservers))
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		hub.ServeWS(w, r, signaling.ConnOptions{})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		hub.Shutdown()
+		srv.Close()
+	})
+	return srv.URL + "/api/rooms/" + code + "/events", "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+// openEvents starts an event stream; cancel ends it.
+func openEvents(t *testing.T, url string) (*http.Response, *bufio.Reader, context.CancelFunc) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		resp.Body.Close()
+	})
+	return resp, bufio.NewReader(resp.Body), cancel
+}
+
+// nextSnapshot reads the next "snapshot" event, failing after timeout.
+func nextSnapshot(t *testing.T, events *bufio.Reader, timeout time.Duration) protocol.StateMessage {
+	t.Helper()
+	got := make(chan protocol.StateMessage, 1)
+	failed := make(chan error, 1)
+	go func() {
+		event := ""
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				failed <- err
+				return
+			}
+			line = strings.TrimRight(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: ") && event == "snapshot":
+				var msg protocol.StateMessage
+				if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &msg); err != nil {
+					failed <- err
+					return
+				}
+				got <- msg
+				return
+			}
+		}
+	}()
+	select {
+	case msg := <-got:
+		return msg
+	case err := <-failed:
+		t.Fatalf("reading events: %v", err)
+	case <-time.After(timeout):
+		t.Fatal("no snapshot event in time")
+	}
+	return protocol.StateMessage{}
+}
+
+func TestRoomEventsFollowJoinsAndLeaves(t *testing.T) {
+	eventsURL, wsURL := newEventsServer(t, 0)
+	resp, events, _ := openEvents(t, eventsURL)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status = %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if first := nextSnapshot(t, events, time.Second); len(first.Peers) != 0 {
+		t.Fatalf("initial peers = %v, want none", first.Peers)
+	}
+
+	// Well under eventsResync: updates must come from the hub's notifications.
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	joined := nextSnapshot(t, events, time.Second)
+	if len(joined.Peers) != 1 {
+		t.Fatalf("peers after join = %v, want one", joined.Peers)
+	}
+	conn.Close()
+	if left := nextSnapshot(t, events, time.Second); len(left.Peers) != 0 {
+		t.Fatalf("peers after leave = %v, want none", left.Peers)
+	}
+}
+
+func TestRoomEventsCapObserversPerRoom(t *testing.T) {
+	eventsURL, _ := newEventsServer(t, 1)
+	_, events, cancel := openEvents(t, eventsURL)
+	nextSnapshot(t, events, time.Second)
+
+	resp, err := http.Get(eventsURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("second observer status = %d, want 429", resp.StatusCode)
+	}
+
+	// Closing the first stream frees its slot.
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for {
+		resp, events, _ := openEvents(t, eventsURL)
+		if resp.StatusCode == http.StatusOK {
+			nextSnapshot(t, events, time.Second)
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("observer slot not released, status = %d", resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,167 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"videochat/internal/app/audit"
+	"videochat/internal/app/rooms"
+	"videochat/pkg/webrtc/signaling"
+)
+
+// RoomExportVersion is the blob format written by RoomExportHandler.
+const RoomExportVersion = 1
+
+// ErrRoomBusy is returned when importing into a room that still has connected peers
+// or is already being imported into.
+var ErrRoomBusy = errors.New("room has connected peers")
+
+// RoomExport is a room's full server-side state, for debugging and migration.
+// Media (mic/camera) state is not included: it is relayed, never stored.
+type RoomExport struct {
+	Version       int                        `json:"version"`
+	Code          string                     `json:"code"`
+	ExportedAt    time.Time                  `json:"exportedAt"`
+	CreatedAt     time.Time                  `json:"createdAt"`
+	Features      map[string]bool            `json:"features,omitempty"`
+	Paused        bool                       `json:"paused,omitempty"`
+	Peers         []string                   `json:"peers"`
+	Broadcasting  []string                   `json:"broadcasting"`
+	BroadcastMeta map[string]json.RawMessage `json:"broadcastMeta,omitempty"`
+	Usernames     map[string]string          `json:"usernames,omitempty"`
+	PeerMeta      map[string]json.RawMessage `json:"peerMeta,omitempty"`
+	// Chat holds persisted chat frames, oldest first.
+	Chat []json.RawMessage `json:"chat,omitempty"`
+}
+
+// Validate checks that the blob is internally consistent before it is imported.
+func (e *RoomExport) Validate() error {
+	if e.Version != RoomExportVersion {
+		return fmt.Errorf("unsupported version %d", e.Version)
+	}
+	peers := make(map[string]bool, len(e.Peers))
+	for _, id := range e.Peers {
+		if strings.TrimSpace(id) == "" {
+			return errors.New("empty peer id")
+		}
+		peers[id] = true
+	}
+	for _, id := range e.Broadcasting {
+		if !peers[id] {
+			return fmt.Errorf("broadcaster %q is not a peer", id)
+		}
+	}
+	for id := range e.BroadcastMeta {
+		if !peers[id] {
+			return fmt.Errorf("broadcast meta for %q, which is not a peer", id)
+		}
+	}
+	for id, meta := range e.PeerMeta {
+		if !peers[id] {
+			return fmt.Errorf("peer meta for %q, which is not a peer", id)
+		}
+		var attrs map[string]string
+		if err := json.Unmarshal(meta, &attrs); err != nil {
+			return fmt.Errorf("peer meta for %q is not a string map", id)
+		}
+	}
+	for id, name := range e.Usernames {
+		if strings.TrimSpace(id) == "" {
+			return errors.New("username for empty peer id")
+		}
+		if _, ok := signaling.NormalizeUsername(name); !ok {
+			return fmt.Errorf("invalid username for %q", id)
+		}
+	}
+	for i, raw := range e.Chat {
+		var frame struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(raw, &frame); err != nil || frame.Type != "chat" {
+			return fmt.Errorf("chat entry %d is not a chat frame", i)
+		}
+	}
+	return nil
+}
+
+// RoomExportHandler returns a room's state as a RoomExport blob
+// (GET /api/rooms/{code}/export). It reads Redis directly, so it works on any instance.
+func RoomExportHandler(hubs HubManager, auditLog audit.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+
+		blob, err := hubs.ExportRoom(ctx, code)
+		if err != nil {
+			if errors.Is(err, rooms.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			log.Printf("room export error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to export room")
+			return
+		}
+		log.Printf("admin: exported room %s (%d peers)", code, len(blob.Peers))
+		recordAudit(auditLog, r, code, "export", "", "")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="room-%s.json"`, code))
+		_ = json.NewEncoder(w).Encode(blob)
+	})
+}
+
+// RoomImportHandler restores a RoomExport blob into an existing room with no
+// connected peers (POST /api/rooms/{code}/import). Imported peers are presence
+// entries only; the room is cleaned up as usual if nobody joins.
+func RoomImportHandler(hubs HubManager, auditLog audit.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		var blob RoomExport
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&blob); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid export blob")
+			return
+		}
+		if err := blob.Validate(); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		if err := hubs.ImportRoom(ctx, code, &blob); err != nil {
+			switch {
+			case errors.Is(err, rooms.ErrNotFound):
+				writeJSONError(w, http.StatusNotFound, "room not found")
+			case errors.Is(err, ErrRoomBusy):
+				writeJSONError(w, http.StatusConflict, err.Error())
+			default:
+				log.Printf("room import error: %v", err)
+				writeJSONError(w, http.StatusInternalServerError, "failed to import room")
+			}
+			return
+		}
+		log.Printf("admin: imported room %s from %s (%d peers)", code, blob.Code, len(blob.Peers))
+		recordAudit(auditLog, r, code, "import", "", fmt.Sprintf("from=%s peers=%d", blob.Code, len(blob.Peers)))
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "peers": len(blob.Peers)})
+	})
+}
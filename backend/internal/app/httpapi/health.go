@@ -0,0 +1,41 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ReadyHandler reports readiness from a Redis ping: "ok" when it answers within
+// threshold, "degraded" (still 200) when slower, and "down" with 503 when it fails.
+// The measured latency is included so autoscalers can act on it.
+func ReadyHandler(ping func(ctx context.Context) error, threshold time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+
+		start := time.Now()
+		err := ping(ctx)
+		latency := time.Since(start)
+
+		status, code := "ok", http.StatusOK
+		payload := map[string]interface{}{
+			"redisLatencyMs": float64(latency.Microseconds()) / 1000,
+		}
+		switch {
+		case err != nil:
+			log.Printf("readiness: redis ping failed after %s: %v", latency, err)
+			status, code = "down", http.StatusServiceUnavailable
+			payload["error"] = "redis unavailable"
+		case threshold > 0 && latency > threshold:
+			status = "degraded"
+		}
+		payload["status"] = status
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		_ = json.NewEncoder(w).Encode(payload)
+	})
+}
@@ -5,32 +5,100 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/gorilla/websocket"
+
 	"videochat/internal/app/rooms"
+	"videochat/pkg/webrtc/ice"
 	"videochat/pkg/webrtc/protocol"
+	"videochat/pkg/webrtc/signaling"
 )
 
 type Settings struct {
 	ICEMode     string
 	ICEServers  []protocol.ICEServer
 	PublicWSURL string
+	// Region names the server's deployment region/edge (empty when unset).
+	Region string
+	// TURNHealth, when set, leaves TURN servers failing their health check out of
+	// the advertised ICE servers.
+	TURNHealth *ice.HealthChecker
+}
+
+// advertisedICEServers is ICEServers without the TURN URLs TURNHealth reports down.
+func (s Settings) advertisedICEServers() []protocol.ICEServer {
+	if s.TURNHealth != nil {
+		return s.TURNHealth.Servers()
+	}
+	return s.ICEServers
 }
 
 type Hub interface {
-	HTTPHandler() http.Handler
+	ServeWS(w http.ResponseWriter, r *http.Request, opts signaling.ConnOptions)
+	SetPaused(paused bool)
+	SetUsernames(ctx context.Context, names map[string]string) error
+	Snapshot(ctx context.Context) protocol.StateMessage
+	SpawnSynthetic(ctx context.Context, count int, ttl time.Duration) ([]string, error)
+	ClientIDs() []string
+	QueueDepths() map[string]signaling.QueueDepth
+	// Watch signals after every change the hub announces to its peers.
+	Watch() (changed <-chan struct{}, stop func())
 }
 
 type HubManager interface {
 	HubForRoom(code string) Hub
+	// ExistingHub returns the running hub for code, or nil, without creating one.
+	ExistingHub(code string) Hub
+	// WarmRoom starts a room's hub before anyone joins; it is cleaned up if nobody does.
+	WarmRoom(code string) Hub
+	// RenameRoom moves a room to a new code and tells its connected peers to follow.
+	RenameRoom(ctx context.Context, oldCode, newCode string) error
+	// ExportRoom reads a room's full state; ImportRoom restores one into an idle room.
+	ExportRoom(ctx context.Context, code string) (*RoomExport, error)
+	ImportRoom(ctx context.Context, code string, blob *RoomExport) error
+}
+
+type basePathKey struct{}
+
+// Mount serves h under prefix, stripping it from the request path and recording it so
+// generated URLs (room links, WebSocket URL) keep the prefix. An empty prefix returns h.
+func Mount(prefix string, h http.Handler) http.Handler {
+	if prefix == "" {
+		return h
+	}
+	strip := http.StripPrefix(prefix, h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), basePathKey{}, prefix)
+		strip.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func basePath(r *http.Request) string {
+	p, _ := r.Context().Value(basePathKey{}).(string)
+	return p
+}
+
+// brandingFiles are the assets SPAHandler lets operators override, with the content
+// types they must be served as (nosniff is set, and .webmanifest is not in every mime table).
+var brandingFiles = map[string]string{
+	"/favicon.ico":          "image/x-icon",
+	"/manifest.webmanifest": "application/manifest+json",
 }
 
-func SPAHandler(staticDir string) http.Handler {
+// SPAHandler serves the built frontend from staticDir, falling back to index.html for
+// client-side routes. When brandingDir is set, /favicon.ico and /manifest.webmanifest
+// are served from it if present there, so branding can change without a rebuild.
+func SPAHandler(staticDir, brandingDir string) http.Handler {
 	fs := http.FileServer(http.Dir(staticDir))
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -39,6 +107,20 @@ func SPAHandler(staticDir string) http.Handler {
 			return
 		}
 
+		if ctype, ok := brandingFiles[r.URL.Path]; ok {
+			for _, dir := range []string{brandingDir, staticDir} {
+				if dir == "" {
+					continue
+				}
+				path := filepath.Join(dir, r.URL.Path)
+				if info, err := os.Stat(path); err == nil && !info.IsDir() {
+					w.Header().Set("Content-Type", ctype)
+					http.ServeFile(w, r, path)
+					return
+				}
+			}
+		}
+
 		path := filepath.Join(staticDir, filepath.Clean(r.URL.Path))
 		if info, err := os.Stat(path); err == nil && !info.IsDir() {
 			fs.ServeHTTP(w, r)
@@ -57,18 +139,36 @@ func DebugICEHandler(settings Settings) http.Handler {
 			"mode":       settings.ICEMode,
 			"iceServers": settings.ICEServers,
 		}
+		if settings.TURNHealth != nil {
+			payload["advertised"] = settings.advertisedICEServers()
+		}
 		_ = json.NewEncoder(w).Encode(payload)
 	})
 }
 
-func SettingsHandler(settings Settings) http.Handler {
+func SettingsHandler(settings Settings, identity *Identity) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			methodNotAllowed(w, http.MethodGet, http.MethodHead)
+			return
+		}
+		identity.Ensure(w, r)
 		wsURL := resolveWSURL(settings, r)
+		iceServers := settings.advertisedICEServers()
+		if strings.EqualFold(r.URL.Query().Get("transport"), "tcp") {
+			// The client cannot use UDP: put TCP/TLS TURN relays first, and carry the
+			// hint on the WS URL so the welcome's iceServers come in the same order.
+			iceServers = ice.PreferTCPRelays(iceServers)
+			wsURL = withQuery(wsURL, "transport", "tcp")
+		}
 		w.Header().Set("Content-Type", "application/json")
 		payload := map[string]interface{}{
 			"wsURL":      wsURL,
 			"iceMode":    settings.ICEMode,
-			"iceServers": settings.ICEServers,
+			"iceServers": iceServers,
+			"region":     settings.Region,
+			// Matches signaling.NormalizeUsername, so clients can enforce it up front.
+			"maxUsernameLength": protocol.MaxUsernameLength,
 		}
 		if err := json.NewEncoder(w).Encode(payload); err != nil {
 			log.Printf("settings encode error: %v", err)
@@ -76,36 +176,85 @@ func SettingsHandler(settings Settings) http.Handler {
 	})
 }
 
+// withQuery sets key=value in raw's query, returning raw unchanged if it does not parse.
+func withQuery(raw, key, value string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
 func resolveWSURL(settings Settings, r *http.Request) string {
 	if settings.PublicWSURL != "" {
 		return settings.PublicWSURL
 	}
 
 	proto := "ws"
-	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+	if isHTTPS(r) {
 		proto = "wss"
 	}
 
-	host := r.Host
-	if host == "" {
-		host = "localhost:8080"
-	}
+	return fmt.Sprintf("%s://%s%s/ws", proto, urlHost(requestHost(r)), basePath(r))
+}
 
-	return fmt.Sprintf("%s://%s/ws", proto, host)
+// WSOptions configures admission checks applied before a WebSocket upgrade.
+type WSOptions struct {
+	// Limiter caps concurrent connections per client IP (nil disables the cap).
+	Limiter *IPLimiter
+	// Identity, when set, reuses the signed peer_id cookie as the connection's peer ID.
+	Identity *Identity
+	// AcceptHook, when set, runs before the room lookup and upgrade (e.g. for geo or
+	// IP blocking); a non-nil error is answered with 403 and the error as reason.
+	AcceptHook func(r *http.Request) error
+	// Drain, while active, refuses new connections with 503 (see MigrateAdminHandler).
+	Drain *Drain
 }
 
-func WSHandler(hubs HubManager, roomStore rooms.Store) http.Handler {
+func WSHandler(hubs HubManager, roomStore rooms.Store, opts WSOptions) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !websocket.IsWebSocketUpgrade(r) {
+			w.Header().Set("Upgrade", "websocket")
+			w.Header().Set("Connection", "Upgrade")
+			http.Error(w, "this endpoint speaks WebSocket only; connect with a WebSocket client to /ws?room={code}", http.StatusUpgradeRequired)
+			return
+		}
 		roomCode := strings.TrimSpace(r.URL.Query().Get("room"))
 		if roomCode == "" {
 			http.Error(w, "missing room code", http.StatusBadRequest)
 			return
 		}
+		if opts.Drain.Active() {
+			http.Error(w, "instance draining", http.StatusServiceUnavailable)
+			return
+		}
+		if opts.AcceptHook != nil {
+			if err := opts.AcceptHook(r); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+		}
+
+		ip := clientIP(r)
+		if !opts.Limiter.Acquire(ip) {
+			http.Error(w, "too many connections", http.StatusTooManyRequests)
+			return
+		}
+		admitted := false
+		defer func() {
+			if !admitted {
+				opts.Limiter.Release(ip)
+			}
+		}()
 
 		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 		defer cancel()
 
-		if _, err := roomStore.Get(ctx, roomCode); err != nil {
+		room, err := roomStore.Get(ctx, roomCode)
+		if err != nil {
 			if errors.Is(err, rooms.ErrNotFound) {
 				http.Error(w, "room not found", http.StatusNotFound)
 				return
@@ -114,29 +263,76 @@ func WSHandler(hubs HubManager, roomStore rooms.Store) http.Handler {
 			http.Error(w, "room lookup failed", http.StatusInternalServerError)
 			return
 		}
+		if room.Locked && hubs.ExistingHub(roomCode) == nil {
+			// Nobody here to let back in; a running hub checks the lock (and lets its
+			// own peers reconnect) in ServeWS.
+			http.Error(w, "room locked", http.StatusForbidden)
+			return
+		}
+		reopened := false
+		if room.Status == rooms.StatusClosing {
+			// Someone came back during the grace window: keep the room, and say so in
+			// the welcome.
+			log.Printf("room %s rejoined while closing; reopening", roomCode)
+			if err := roomStore.Reopen(ctx, roomCode); err != nil {
+				log.Printf("room reopen error: %v", err)
+			} else {
+				reopened = true
+			}
+		}
 
 		hub := hubs.HubForRoom(roomCode)
 		if hub == nil {
-			http.Error(w, "room not available", http.StatusInternalServerError)
+			// The server is shutting down.
+			http.Error(w, "room not available", http.StatusServiceUnavailable)
 			return
 		}
 
-		hub.HTTPHandler().ServeHTTP(w, r)
+		admitted = true
+		connOpts := signaling.ConnOptions{
+			OnClose:  func() { opts.Limiter.Release(ip) },
+			Reopened: reopened,
+		}
+		if id, ok := opts.Identity.PeerID(r); ok {
+			connOpts.ID = id
+		}
+		// ServeWS runs the remaining checks (peer ID, lock, capacity) and answers
+		// refusals itself.
+		hub.ServeWS(w, r, connOpts)
 	})
 }
 
-func CreateRoomHandler(store rooms.Store) http.Handler {
+// CreateRoomHandler creates rooms (POST /api/rooms). With identity set, the room is
+// owned by the caller's signed peer ID (issued if missing), which the store's
+// per-owner cap counts against; past it the request gets 429.
+func CreateRoomHandler(store rooms.Store, identity *Identity) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		var opts rooms.CreateOptions
+		// An empty body (including a chunked one) means no options.
+		if err := json.NewDecoder(r.Body).Decode(&opts); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "invalid room options", http.StatusBadRequest)
 			return
 		}
+		opts.OwnerID = identity.Ensure(w, r)
 
 		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
 		defer cancel()
 
-		room, err := store.Create(ctx)
+		room, err := store.Create(ctx, opts)
 		if err != nil {
+			if errors.Is(err, rooms.ErrInvalidOptions) {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			if errors.Is(err, rooms.ErrOwnerLimit) {
+				writeJSONError(w, http.StatusTooManyRequests, err.Error())
+				return
+			}
 			log.Printf("room create error: %v", err)
 			http.Error(w, "failed to create room", http.StatusInternalServerError)
 			return
@@ -144,8 +340,21 @@ func CreateRoomHandler(store rooms.Store) http.Handler {
 
 		w.Header().Set("Content-Type", "application/json")
 		payload := map[string]interface{}{
-			"code": room.Code,
-			"url":  roomURL(r, room.Code),
+			"code":     room.Code,
+			"url":      roomURL(r, room.Code),
+			"features": room.Features,
+		}
+		if room.ExpiresAt != nil {
+			payload["expiresAt"] = room.ExpiresAt
+		}
+		if room.ICETransportPolicy != "" {
+			payload["iceTransportPolicy"] = room.ICETransportPolicy
+		}
+		if room.Topology != "" {
+			payload["topology"] = room.Topology
+		}
+		if room.RequireName {
+			payload["requireName"] = true
 		}
 		_ = json.NewEncoder(w).Encode(payload)
 	})
@@ -154,7 +363,7 @@ func CreateRoomHandler(store rooms.Store) http.Handler {
 func RoomLookupHandler(store rooms.Store) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
-			w.WriteHeader(http.StatusMethodNotAllowed)
+			methodNotAllowed(w, http.MethodGet)
 			return
 		}
 
@@ -166,7 +375,7 @@ func RoomLookupHandler(store rooms.Store) http.Handler {
 		room, err := store.Get(ctx, code)
 		if err != nil {
 			if errors.Is(err, rooms.ErrNotFound) {
-				http.NotFound(w, r)
+				writeJSONError(w, http.StatusNotFound, "room not found")
 				return
 			}
 			log.Printf("room lookup error: %v", err)
@@ -176,22 +385,128 @@ func RoomLookupHandler(store rooms.Store) http.Handler {
 
 		w.Header().Set("Content-Type", "application/json")
 		payload := map[string]interface{}{
-			"code":      room.Code,
-			"createdAt": room.CreatedAt,
-			"url":       roomURL(r, room.Code),
+			"code":               room.Code,
+			"createdAt":          room.CreatedAt,
+			"createdAtMs":        room.CreatedAt.UnixMilli(),
+			"url":                roomURL(r, room.Code),
+			"features":           room.Features,
+			"status":             room.Status,
+			"closesAt":           room.ClosesAt,
+			"paused":             room.Paused,
+			"locked":             room.Locked,
+			"expiresAt":          room.ExpiresAt,
+			"iceTransportPolicy": room.ICETransportPolicy,
+			"topology":           room.Topology,
+			"requireName":        room.RequireName,
+		}
+		_ = json.NewEncoder(w).Encode(payload)
+	})
+}
+
+// RoomCodeValidateHandler reports whether ?code= is well-formed for this app without
+// touching Redis, so join forms can flag typos instantly.
+func RoomCodeValidateHandler(format rooms.CodeFormat) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		payload := map[string]interface{}{"valid": true}
+		if err := format.Validate(strings.TrimSpace(r.URL.Query().Get("code"))); err != nil {
+			payload = map[string]interface{}{"valid": false, "reason": err.Error()}
 		}
+		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(payload)
 	})
 }
 
+// RoomListHandler pages through the app's rooms
+// (GET /api/rooms/list?limit=N&cursor=C). Pass the returned cursor back until it
+// comes back empty.
+func RoomListHandler(store rooms.Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		limit := 0
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n <= 0 {
+				writeJSONError(w, http.StatusBadRequest, "limit must be a positive integer")
+				return
+			}
+			limit = n
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+
+		codes, next, err := store.List(ctx, r.URL.Query().Get("cursor"), limit)
+		if err != nil {
+			if errors.Is(err, rooms.ErrInvalidCursor) {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			log.Printf("room list error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to list rooms")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"rooms": codes, "cursor": next})
+	})
+}
+
+// APINotFoundHandler answers unmatched /api/ paths with a JSON 404 instead of the SPA.
+func APINotFoundHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "not found")
+	})
+}
+
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": msg,
+	})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
 func roomURL(r *http.Request, code string) string {
 	proto := "http"
-	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+	if isHTTPS(r) {
 		proto = "https"
 	}
-	host := r.Host
+	return fmt.Sprintf("%s://%s%s/rooms/%s", proto, urlHost(requestHost(r)), basePath(r), code)
+}
+
+// urlHost normalizes a Host header value for embedding in a URL. IPv6 literals are
+// bracketed exactly once (with any zone escaped), a missing or empty port is dropped,
+// and an empty host falls back to localhost:8080.
+func urlHost(host string) string {
+	host = strings.TrimSpace(host)
 	if host == "" {
-		host = "localhost:8080"
+		return "localhost:8080"
+	}
+	name, port, err := net.SplitHostPort(host)
+	if err != nil {
+		// No port (or an unbracketed IPv6 literal): treat the whole value as the name.
+		name, port = host, ""
+	}
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "["), "]")
+	if strings.Contains(name, ":") {
+		if !strings.Contains(name, "%25") {
+			name = strings.Replace(name, "%", "%25", 1)
+		}
+		name = "[" + name + "]"
+	}
+	if port == "" {
+		return name
 	}
-	return fmt.Sprintf("%s://%s/rooms/%s", proto, host, code)
+	return name + ":" + port
 }
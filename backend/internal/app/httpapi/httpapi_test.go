@@ -0,0 +1,395 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+
+	"videochat/internal/app/rooms"
+	"videochat/pkg/webrtc/signaling"
+)
+
+// fakeHub records ServeWS calls instead of upgrading; other Hub methods are unused.
+// With keep set, connections stay open until hangUp runs their OnClose.
+type fakeHub struct {
+	Hub
+	mu      sync.Mutex
+	served  int
+	lastID  string
+	names   map[string]string
+	keep    bool
+	closers []func()
+}
+
+func (h *fakeHub) ServeWS(w http.ResponseWriter, _ *http.Request, opts signaling.ConnOptions) {
+	h.mu.Lock()
+	h.served++
+	h.lastID = opts.ID
+	keep := h.keep && opts.OnClose != nil
+	if keep {
+		h.closers = append(h.closers, opts.OnClose)
+	}
+	h.mu.Unlock()
+	if !keep && opts.OnClose != nil {
+		opts.OnClose()
+	}
+	w.WriteHeader(http.StatusSwitchingProtocols)
+}
+
+func (h *fakeHub) SetUsernames(_ context.Context, names map[string]string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.names = names
+	return nil
+}
+
+// hangUp closes every connection kept open so far.
+func (h *fakeHub) hangUp() {
+	h.mu.Lock()
+	closers := h.closers
+	h.closers = nil
+	h.mu.Unlock()
+	for _, fn := range closers {
+		fn()
+	}
+}
+
+// fakeHubs hands out one fakeHub per room, or none once closed (shutting down).
+type fakeHubs struct {
+	HubManager
+	mu     sync.Mutex
+	keep   bool
+	closed bool
+	hubs   map[string]*fakeHub
+}
+
+func newFakeHubs() *fakeHubs {
+	return &fakeHubs{hubs: make(map[string]*fakeHub)}
+}
+
+func (m *fakeHubs) HubForRoom(code string) Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	if m.hubs[code] == nil {
+		m.hubs[code] = &fakeHub{keep: m.keep}
+	}
+	return m.hubs[code]
+}
+
+func (m *fakeHubs) ExistingHub(code string) Hub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h := m.hubs[code]; h != nil {
+		return h
+	}
+	return nil
+}
+
+func newTestRooms(t *testing.T) rooms.Store {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rooms.NewRedisStore(rdb, "test")
+}
+
+func createTestRoom(t *testing.T, store rooms.Store) string {
+	t.Helper()
+	room, err := store.Create(context.Background(), rooms.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return room.Code
+}
+
+// wsRequest builds a WebSocket upgrade request for room from remoteIP.
+func wsRequest(room, remoteIP string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/ws?room="+room, nil)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.RemoteAddr = remoteIP + ":51234"
+	return r
+}
+
+func TestIPLimiterCapsAndReleases(t *testing.T) {
+	l := NewIPLimiter(2)
+	if !l.Acquire("10.0.0.1") || !l.Acquire("10.0.0.1") {
+		t.Fatal("slots under the cap were refused")
+	}
+	if l.Acquire("10.0.0.1") {
+		t.Fatal("third connection from the same IP was allowed")
+	}
+	if !l.Acquire("10.0.0.2") {
+		t.Fatal("another IP was refused")
+	}
+	l.Release("10.0.0.1")
+	if !l.Acquire("10.0.0.1") {
+		t.Fatal("released slot was not reusable")
+	}
+	l.Release("10.0.0.1")
+	l.Release("10.0.0.1")
+	l.Release("10.0.0.2")
+	if len(l.counts) != 0 {
+		t.Fatalf("counts = %v, want empty after releasing everything", l.counts)
+	}
+
+	var unlimited *IPLimiter
+	if !unlimited.Acquire("10.0.0.1") || !NewIPLimiter(0).Acquire("10.0.0.1") {
+		t.Fatal("nil or zero limiter should allow everything")
+	}
+}
+
+func TestWSHandlerCapsConnectionsPerIP(t *testing.T) {
+	store := newTestRooms(t)
+	code := createTestRoom(t, store)
+	hubs := newFakeHubs()
+	hubs.keep = true
+	h := WSHandler(hubs, store, WSOptions{Limiter: NewIPLimiter(1)})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, wsRequest(code, "198.51.100.7"))
+	if rec.Code != http.StatusSwitchingProtocols {
+		t.Fatalf("first connection status = %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, wsRequest(code, "198.51.100.7"))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second connection status = %d, want 429", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, wsRequest(code, "198.51.100.8"))
+	if rec.Code != http.StatusSwitchingProtocols {
+		t.Fatalf("other IP status = %d", rec.Code)
+	}
+
+	// Closing the connection hands its slot back.
+	hubs.hubs[code].hangUp()
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, wsRequest(code, "198.51.100.7"))
+	if rec.Code != http.StatusSwitchingProtocols {
+		t.Fatalf("after close status = %d, want the upgrade", rec.Code)
+	}
+}
+
+func TestWSHandlerReleasesSlotOnRefusal(t *testing.T) {
+	store := newTestRooms(t)
+	hubs := newFakeHubs()
+	limiter := NewIPLimiter(1)
+	h := WSHandler(hubs, store, WSOptions{Limiter: limiter})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, wsRequest("NOPE42", "198.51.100.7"))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+	if !limiter.Acquire("198.51.100.7") {
+		t.Fatal("refused connection kept its slot")
+	}
+}
+
+func TestWSHandlerAcceptHookBlocksNetwork(t *testing.T) {
+	store := newTestRooms(t)
+	code := createTestRoom(t, store)
+	hubs := newFakeHubs()
+	limiter := NewIPLimiter(1)
+	h := WSHandler(hubs, store, WSOptions{
+		Limiter:    limiter,
+		AcceptHook: BlockNets([]netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}),
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, wsRequest(code, "203.0.113.7"))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", rec.Code)
+	}
+	if body := strings.TrimSpace(rec.Body.String()); body != errBlockedNetwork.Error() {
+		t.Fatalf("body = %q, want the hook's reason", body)
+	}
+	if hubs.ExistingHub(code) != nil {
+		t.Fatal("a blocked client must not start the room's hub")
+	}
+	// Refused before the limiter, so it holds no slot.
+	if !limiter.Acquire("203.0.113.7") {
+		t.Fatal("blocked client kept a connection slot")
+	}
+}
+
+func TestWSHandlerAcceptHookAllowsOtherNetworks(t *testing.T) {
+	store := newTestRooms(t)
+	code := createTestRoom(t, store)
+	hubs := newFakeHubs()
+	h := WSHandler(hubs, store, WSOptions{
+		AcceptHook: BlockNets([]netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}),
+	})
+
+	for _, ip := range []string{"198.51.100.7", "::ffff:198.51.100.8"} {
+		rec := httptest.NewRecorder()
+		r := wsRequest(code, ip)
+		if strings.Contains(ip, ":") {
+			r.RemoteAddr = "[" + ip + "]:51234"
+		}
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusSwitchingProtocols {
+			t.Fatalf("%s: status = %d, want the upgrade", ip, rec.Code)
+		}
+	}
+	if n := hubs.hubs[code].served; n != 2 {
+		t.Fatalf("ServeWS calls = %d, want 2", n)
+	}
+}
+
+func TestBlockNetsMatchesMappedIPv4(t *testing.T) {
+	hook := BlockNets([]netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")})
+	r := wsRequest("abc", "203.0.113.9")
+	r.RemoteAddr = "[::ffff:203.0.113.9]:51234"
+	if err := hook(r); err == nil {
+		t.Fatal("IPv4-mapped address in a blocked network was allowed")
+	}
+	if BlockNets(nil) != nil {
+		t.Fatal("BlockNets(nil) should disable the hook")
+	}
+}
+
+func TestCreateRoomHandlerCapsRoomsPerOwner(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	store := rooms.NewRedisStore(rdb, "test")
+	store.SetMaxRoomsPerOwner(1)
+	h := CreateRoomHandler(store, NewIdentity("secret"))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/rooms", nil))
+	if rec.Code != http.StatusOK && rec.Code != http.StatusCreated {
+		t.Fatalf("first create status = %d", rec.Code)
+	}
+	cookies := rec.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("no identity cookie issued")
+	}
+
+	again := httptest.NewRequest(http.MethodPost, "/api/rooms", nil)
+	for _, c := range cookies {
+		again.AddCookie(c)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, again)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("second create status = %d, want 429", rec.Code)
+	}
+
+	// A different browser has its own allowance.
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/rooms", nil))
+	if rec.Code == http.StatusTooManyRequests {
+		t.Fatal("a new identity was refused")
+	}
+}
+
+func TestRoomCodeValidateHandler(t *testing.T) {
+	custom, err := rooms.NewCodeFormat("ABCDEFGH", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		format rooms.CodeFormat
+		code   string
+		valid  bool
+	}{
+		{"default format", rooms.CodeFormat{}, "aB3-_xYz", true},
+		{"surrounding spaces", rooms.CodeFormat{}, "%20aB3-_xYz%20", true},
+		{"empty", rooms.CodeFormat{}, "", false},
+		{"too short", rooms.CodeFormat{}, "abc", false},
+		{"invalid characters", rooms.CodeFormat{}, "abc%21defg", false},
+		{"custom alphabet", custom, "ABCHGF", true},
+		{"outside custom alphabet", custom, "ABCXYZ", false},
+		{"wrong custom length", custom, "ABCDEFGH", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			RoomCodeValidateHandler(tt.format).ServeHTTP(rec,
+				httptest.NewRequest(http.MethodGet, "/api/rooms/validate?code="+tt.code, nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d", rec.Code)
+			}
+			var body struct {
+				Valid  bool   `json:"valid"`
+				Reason string `json:"reason"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Valid != tt.valid {
+				t.Fatalf("valid = %v (%s), want %v", body.Valid, body.Reason, tt.valid)
+			}
+			if !body.Valid && body.Reason == "" {
+				t.Fatal("an invalid code should come with a reason")
+			}
+		})
+	}
+}
+
+func TestWSHandlerPreUpgradeRejections(t *testing.T) {
+	store := newTestRooms(t)
+	open := createTestRoom(t, store)
+	locked := createTestRoom(t, store)
+	if err := store.SetLocked(context.Background(), locked, true); err != nil {
+		t.Fatal(err)
+	}
+	draining := &Drain{}
+	draining.active.Store(true)
+
+	tests := []struct {
+		name   string
+		room   string
+		hubs   *fakeHubs
+		drain  *Drain
+		status int
+		reason string
+	}{
+		{"missing code", "", newFakeHubs(), nil, http.StatusBadRequest, "missing room code"},
+		{"unknown room", "NOPE4242", newFakeHubs(), nil, http.StatusNotFound, "room not found"},
+		{"locked room", locked, newFakeHubs(), nil, http.StatusForbidden, "room locked"},
+		{"draining instance", open, newFakeHubs(), draining, http.StatusServiceUnavailable, "instance draining"},
+		{"shutting down", open, &fakeHubs{closed: true}, nil, http.StatusServiceUnavailable, "room not available"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := NewIPLimiter(1)
+			h := WSHandler(tt.hubs, store, WSOptions{Limiter: limiter, Drain: tt.drain})
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, wsRequest(tt.room, "198.51.100.7"))
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.status)
+			}
+			if body := strings.TrimSpace(rec.Body.String()); body != tt.reason {
+				t.Fatalf("body = %q, want %q", body, tt.reason)
+			}
+			if !limiter.Acquire("198.51.100.7") {
+				t.Fatal("rejected connection kept its slot")
+			}
+		})
+	}
+
+	// A running hub decides for itself: its peers may come back to a locked room.
+	hubs := newFakeHubs()
+	hubs.HubForRoom(locked)
+	rec := httptest.NewRecorder()
+	WSHandler(hubs, store, WSOptions{}).ServeHTTP(rec, wsRequest(locked, "198.51.100.7"))
+	if rec.Code != http.StatusSwitchingProtocols {
+		t.Fatalf("locked room with a running hub: status = %d, want it handed to the hub", rec.Code)
+	}
+}
@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const (
+	identityCookieName   = "peer_id"
+	identityCookieMaxAge = 365 * 24 * 60 * 60
+)
+
+// Identity issues and verifies an HMAC-signed cookie carrying an anonymous but
+// stable peer ID, so a returning browser keeps its identity across reconnects.
+// A nil *Identity disables the feature.
+type Identity struct {
+	secret []byte
+}
+
+// NewIdentity returns an Identity signing with secret, or nil when secret is empty.
+func NewIdentity(secret string) *Identity {
+	if secret == "" {
+		return nil
+	}
+	return &Identity{secret: []byte(secret)}
+}
+
+// PeerID returns the verified peer ID from the request cookie, if any.
+func (i *Identity) PeerID(r *http.Request) (string, bool) {
+	if i == nil {
+		return "", false
+	}
+	cookie, err := r.Cookie(identityCookieName)
+	if err != nil {
+		return "", false
+	}
+	id, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || id == "" {
+		return "", false
+	}
+	want := i.sign(id)
+	if !hmac.Equal([]byte(sig), []byte(want)) {
+		return "", false
+	}
+	return id, true
+}
+
+// Ensure returns the request's verified peer ID, issuing a fresh signed cookie when
+// the request has none (or a tampered one).
+func (i *Identity) Ensure(w http.ResponseWriter, r *http.Request) string {
+	if i == nil {
+		return ""
+	}
+	if id, ok := i.PeerID(r); ok {
+		return id
+	}
+	id := uuid.NewString()
+	http.SetCookie(w, &http.Cookie{
+		Name:     identityCookieName,
+		Value:    id + "." + i.sign(id),
+		Path:     "/",
+		MaxAge:   identityCookieMaxAge,
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return id
+}
+
+func (i *Identity) sign(id string) string {
+	mac := hmac.New(sha256.New, i.secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// WhoAmIHandler returns the caller's stable peer ID, issuing the identity cookie if needed.
+func WhoAmIHandler(identity *Identity) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if identity == nil {
+			writeJSONError(w, http.StatusNotFound, "identity cookies are disabled")
+			return
+		}
+		id := identity.Ensure(w, r)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"id": id,
+		})
+	})
+}
@@ -0,0 +1,86 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func whoAmI(t *testing.T, h http.Handler, cookies ...*http.Cookie) (string, *httptest.ResponseRecorder) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/api/whoami", nil)
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("whoami status = %d", rec.Code)
+	}
+	var body struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return body.ID, rec
+}
+
+func TestIdentityCookieIssuedAndReused(t *testing.T) {
+	h := WhoAmIHandler(NewIdentity("secret"))
+
+	id, rec := whoAmI(t, h)
+	cookies := rec.Result().Cookies()
+	if id == "" || len(cookies) != 1 || cookies[0].Name != identityCookieName || !cookies[0].HttpOnly {
+		t.Fatalf("first call: id %q, cookies %v", id, cookies)
+	}
+
+	again, rec := whoAmI(t, h, cookies[0])
+	if again != id {
+		t.Fatalf("returning browser got %q, want %q", again, id)
+	}
+	if len(rec.Result().Cookies()) != 0 {
+		t.Fatal("a valid cookie should not be reissued")
+	}
+
+	tampered := *cookies[0]
+	tampered.Value = "someone-else" + tampered.Value[len(id):]
+	if other, _ := whoAmI(t, h, &tampered); other == id || other == "someone-else" {
+		t.Fatalf("tampered cookie yielded %q", other)
+	}
+
+	// A cookie signed with another secret is not trusted either.
+	if other, _ := whoAmI(t, WhoAmIHandler(NewIdentity("other")), cookies[0]); other == id {
+		t.Fatal("cookie verified under a different secret")
+	}
+}
+
+func TestWSHandlerUsesIdentityCookie(t *testing.T) {
+	store := newTestRooms(t)
+	code := createTestRoom(t, store)
+	identity := NewIdentity("secret")
+	id, rec := whoAmI(t, WhoAmIHandler(identity))
+	cookie := rec.Result().Cookies()[0]
+
+	hubs := newFakeHubs()
+	h := WSHandler(hubs, store, WSOptions{Identity: identity})
+	for i := 0; i < 2; i++ {
+		r := wsRequest(code, "198.51.100.7")
+		r.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+		if rec.Code != http.StatusSwitchingProtocols {
+			t.Fatalf("connect %d status = %d", i, rec.Code)
+		}
+		if got := hubs.hubs[code].lastID; got != id {
+			t.Fatalf("connect %d used peer id %q, want %q", i, got, id)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, wsRequest(code, "198.51.100.7"))
+	if got := hubs.hubs[code].lastID; got != "" {
+		t.Fatalf("cookieless connect got id %q, want the hub to pick one", got)
+	}
+}
@@ -0,0 +1,92 @@
+package httpapi
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+)
+
+// IPLimiter caps the number of concurrent connections per remote IP.
+// A nil limiter or a non-positive max allows every connection.
+type IPLimiter struct {
+	mu     sync.Mutex
+	max    int
+	counts map[string]int
+}
+
+// NewIPLimiter builds an in-process limiter allowing up to max connections per IP.
+func NewIPLimiter(max int) *IPLimiter {
+	return &IPLimiter{max: max, counts: make(map[string]int)}
+}
+
+// Acquire reserves a connection slot for ip, returning false when the cap is reached.
+func (l *IPLimiter) Acquire(ip string) bool {
+	if l == nil || l.max <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.counts[ip] >= l.max {
+		return false
+	}
+	l.counts[ip]++
+	return true
+}
+
+// Release frees a slot previously reserved with Acquire.
+func (l *IPLimiter) Release(ip string) {
+	if l == nil || l.max <= 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := l.counts[ip]; n > 1 {
+		l.counts[ip] = n - 1
+	} else {
+		delete(l.counts, ip)
+	}
+}
+
+// clientIP returns the originating client IP: the connection's address, or behind
+// a trusted proxy (see TrustProxy) the last X-Forwarded-For entry, which that proxy
+// appended. Earlier entries come from the client and are not trusted.
+func clientIP(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" && proxyTrusted(r) {
+		hops := strings.Split(fwd, ",")
+		if last := strings.TrimSpace(hops[len(hops)-1]); last != "" {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// errBlockedNetwork is the 403 reason for clients refused by BlockNets.
+var errBlockedNetwork = errors.New("connections from this network are blocked")
+
+// BlockNets returns a WSOptions.AcceptHook refusing clients whose IP (see clientIP)
+// falls inside any of nets, or nil when nets is empty.
+func BlockNets(nets []netip.Prefix) func(r *http.Request) error {
+	if len(nets) == 0 {
+		return nil
+	}
+	return func(r *http.Request) error {
+		addr, err := netip.ParseAddr(clientIP(r))
+		if err != nil {
+			return nil
+		}
+		addr = addr.Unmap()
+		for _, n := range nets {
+			if n.Contains(addr) {
+				return errBlockedNetwork
+			}
+		}
+		return nil
+	}
+}
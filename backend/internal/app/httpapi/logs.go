@@ -0,0 +1,99 @@
+package httpapi
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"videochat/internal/app/logstream"
+)
+
+const (
+	// logsBuffer is how many lines a log tail may fall behind before lines are dropped.
+	logsBuffer       = 256
+	logsWriteTimeout = 10 * time.Second
+)
+
+var logsUpgrader = websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 4096}
+
+// AdminLogsHandler streams the server log over a WebSocket (GET /admin/logs), one
+// text message per line. ?room= keeps the lines of one room's hub (tagged
+// "[room CODE]") and ?peer= the lines mentioning a peer ID. An admin that falls
+// behind misses lines rather than stalling logging, and is told how many with a
+// "-- N lines dropped --" message.
+func AdminLogsHandler(logs *logstream.Broadcaster) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+		if !websocket.IsWebSocketUpgrade(r) {
+			http.Error(w, "connect with a WebSocket client", http.StatusUpgradeRequired)
+			return
+		}
+		var filters [][]byte
+		if room := strings.TrimSpace(r.URL.Query().Get("room")); room != "" {
+			filters = append(filters, []byte("[room "+room+"]"))
+		}
+		if peer := strings.TrimSpace(r.URL.Query().Get("peer")); peer != "" {
+			filters = append(filters, []byte(peer))
+		}
+
+		conn, err := logsUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// Upgrade has already replied; logging here would feed other tails.
+			return
+		}
+		defer conn.Close()
+		sub := logs.Subscribe(logsBuffer)
+		defer sub.Close()
+
+		// Reads only notice the admin going away; anything it sends is ignored.
+		gone := make(chan struct{})
+		go func() {
+			defer close(gone)
+			for {
+				if _, _, err := conn.NextReader(); err != nil {
+					return
+				}
+			}
+		}()
+
+		write := func(msg []byte) bool {
+			_ = conn.SetWriteDeadline(time.Now().Add(logsWriteTimeout))
+			return conn.WriteMessage(websocket.TextMessage, msg) == nil
+		}
+		for {
+			select {
+			case <-gone:
+				return
+			case line, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				if n := sub.Dropped(); n > 0 && !write([]byte(fmt.Sprintf("-- %d lines dropped --", n))) {
+					return
+				}
+				if !matchesAll(line, filters) {
+					continue
+				}
+				if !write(bytes.TrimRight(line, "\n")) {
+					return
+				}
+			}
+		}
+	})
+}
+
+func matchesAll(line []byte, filters [][]byte) bool {
+	for _, f := range filters {
+		if !bytes.Contains(line, f) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,119 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const defaultMaintenancePage = `<!doctype html>
+<html><head><meta charset="utf-8"><title>Down for maintenance</title></head>
+<body><h1>Down for maintenance</h1><p>We'll be back shortly.</p></body></html>
+`
+
+// Maintenance holds the maintenance-mode toggle and the page served while it is on.
+type Maintenance struct {
+	enabled    atomic.Bool
+	page       []byte
+	retryAfter time.Duration
+}
+
+// NewMaintenance builds a toggle, loading the page from pagePath (a built-in page is used when empty or unreadable).
+func NewMaintenance(enabled bool, pagePath string, retryAfter time.Duration) *Maintenance {
+	m := &Maintenance{page: []byte(defaultMaintenancePage), retryAfter: retryAfter}
+	if pagePath != "" {
+		page, err := os.ReadFile(pagePath)
+		if err != nil {
+			log.Printf("maintenance page %s: %v; using built-in page", pagePath, err)
+		} else {
+			m.page = page
+		}
+	}
+	m.enabled.Store(enabled)
+	return m
+}
+
+// Enabled reports whether maintenance mode is on.
+func (m *Maintenance) Enabled() bool {
+	return m.enabled.Load()
+}
+
+// SetEnabled flips maintenance mode.
+func (m *Maintenance) SetEnabled(enabled bool) {
+	m.enabled.Store(enabled)
+}
+
+// MaintenanceHandler answers with 503 while maintenance mode is on: API, WebSocket and
+// debug routes get JSON, everything else gets the maintenance page. /healthz, /readyz,
+// /admin/ and requests bearing adminToken (the RequireAdmin routes, e.g. /debug/stats
+// and the room admin API) always pass through.
+func MaintenanceHandler(m *Maintenance, adminToken string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.Enabled() || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" || strings.HasPrefix(r.URL.Path, "/admin/") || adminAuthorized(r, adminToken) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(m.retryAfter.Seconds())))
+		}
+		if isAPIPath(r.URL.Path) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": "maintenance",
+			})
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write(m.page)
+	})
+}
+
+// MaintenanceAdminHandler reports (GET) or flips (POST {"enabled":bool}) maintenance mode.
+func MaintenanceAdminHandler(m *Maintenance) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var body struct {
+				Enabled *bool `json:"enabled"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+				http.Error(w, "expected {\"enabled\": bool}", http.StatusBadRequest)
+				return
+			}
+			m.SetEnabled(*body.Enabled)
+			log.Printf("maintenance mode set to %v", *body.Enabled)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"enabled": m.Enabled(),
+		})
+	})
+}
+
+// HealthHandler reports process liveness.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "ok",
+		})
+	})
+}
+
+// isAPIPath matches API, WebSocket and debug routes, including those mounted under an app prefix.
+func isAPIPath(path string) bool {
+	return strings.HasSuffix(path, "/ws") || strings.Contains(path, "/api/") || strings.Contains(path, "/debug/")
+}
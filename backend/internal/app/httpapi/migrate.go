@@ -0,0 +1,77 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Migrator hands the peers of every room on this instance off to another one.
+type Migrator interface {
+	// Migrate sends each peer a "migrate" message naming url, closes it, and returns
+	// how many peers were told.
+	Migrate(url string) int
+}
+
+// Drain marks an instance that is being decommissioned: /readyz fails so load
+// balancers stop routing to it, and /ws refuses new joins with 503.
+type Drain struct {
+	active atomic.Bool
+}
+
+// Active reports whether drain mode is on; a nil Drain is never active.
+func (d *Drain) Active() bool {
+	return d != nil && d.active.Load()
+}
+
+// MigrateAdminHandler drains this instance and moves its peers to another one
+// (POST /admin/migrate with an optional {"url": "wss://.../ws"}, defaulting to
+// defaultURL), or leaves drain mode again (DELETE). GET reports the state.
+func MigrateAdminHandler(drain *Drain, defaultURL string, migrators []Migrator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]interface{}{}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var body struct {
+				URL string `json:"url"`
+			}
+			// An empty body (including a chunked one) means the default URL.
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil && !errors.Is(err, io.EOF) {
+				writeJSONError(w, http.StatusBadRequest, `expected {"url": "..."} or no body`)
+				return
+			}
+			url := strings.TrimSpace(body.URL)
+			if url == "" {
+				url = defaultURL
+			}
+			if !strings.HasPrefix(url, "ws://") && !strings.HasPrefix(url, "wss://") {
+				writeJSONError(w, http.StatusBadRequest, "a ws:// or wss:// target url is required (body or MIGRATE_WS_URL)")
+				return
+			}
+			// Drain first so migrated peers can't land back here.
+			drain.active.Store(true)
+			peers := 0
+			for _, m := range migrators {
+				peers += m.Migrate(url)
+			}
+			log.Printf("admin: draining, migrated %d peers to %s", peers, url)
+			payload["url"] = url
+			payload["migrated"] = peers
+		case http.MethodDelete:
+			drain.active.Store(false)
+			log.Printf("admin: drain mode off")
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost, http.MethodDelete)
+			return
+		}
+
+		payload["draining"] = drain.Active()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(payload)
+	})
+}
@@ -0,0 +1,69 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeMigrator records the URLs it was asked to migrate peers to.
+type fakeMigrator struct {
+	urls []string
+}
+
+func (m *fakeMigrator) Migrate(url string) int {
+	m.urls = append(m.urls, url)
+	return 2
+}
+
+func TestMigrateAdminHandlerBodies(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		body    io.Reader
+		chunked bool
+		status  int
+		url     string
+	}{
+		{"no body", nil, false, http.StatusOK, "wss://default.example/ws"},
+		{"empty chunked body", strings.NewReader(""), true, http.StatusOK, "wss://default.example/ws"},
+		{"url", strings.NewReader(`{"url": "wss://b.example/ws"}`), false, http.StatusOK, "wss://b.example/ws"},
+		{"chunked url", strings.NewReader(`{"url": "wss://b.example/ws"}`), true, http.StatusOK, "wss://b.example/ws"},
+		{"malformed", strings.NewReader(`{"url":`), false, http.StatusBadRequest, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			drain := &Drain{}
+			m := &fakeMigrator{}
+			h := MigrateAdminHandler(drain, "wss://default.example/ws", []Migrator{m})
+			r := httptest.NewRequest(http.MethodPost, "/admin/migrate", tc.body)
+			if tc.chunked {
+				// Chunked requests report an unknown (-1) length.
+				r.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, r)
+			if rec.Code != tc.status {
+				t.Fatalf("status = %d, want %d (%s)", rec.Code, tc.status, rec.Body)
+			}
+			if tc.status != http.StatusOK {
+				if drain.Active() || len(m.urls) != 0 {
+					t.Fatal("a rejected request must not drain or migrate")
+				}
+				return
+			}
+			var got struct {
+				URL      string `json:"url"`
+				Migrated int    `json:"migrated"`
+				Draining bool   `json:"draining"`
+			}
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if got.URL != tc.url || got.Migrated != 2 || !got.Draining {
+				t.Fatalf("response = %+v, want url %s, 2 migrated, draining", got, tc.url)
+			}
+		})
+	}
+}
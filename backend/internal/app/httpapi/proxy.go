@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+type trustProxyKey struct{}
+
+// TrustProxy marks requests as coming through a trusted reverse proxy so public URLs are
+// built from X-Forwarded-Host / Forwarded instead of r.Host. Only enable it when the
+// server is reachable exclusively through a proxy that sets those headers; otherwise a
+// client could spoof the host advertised in room and WebSocket URLs.
+func TrustProxy(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), trustProxyKey{}, true)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func proxyTrusted(r *http.Request) bool {
+	ok, _ := r.Context().Value(trustProxyKey{}).(bool)
+	return ok
+}
+
+// requestHost returns the host clients used to reach the server: the proxy-supplied
+// host when the proxy is trusted, r.Host otherwise.
+func requestHost(r *http.Request) string {
+	if proxyTrusted(r) {
+		if host := forwardedParam(r, "host"); host != "" {
+			return host
+		}
+		if fwd := r.Header.Get("X-Forwarded-Host"); fwd != "" {
+			if host := strings.TrimSpace(strings.Split(fwd, ",")[0]); host != "" {
+				return host
+			}
+		}
+	}
+	return r.Host
+}
+
+// isHTTPS reports whether the client connection was made over TLS, directly or at a proxy.
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		return true
+	}
+	return proxyTrusted(r) && strings.EqualFold(forwardedParam(r, "proto"), "https")
+}
+
+// forwardedParam reads a parameter from the first element of an RFC 7239 Forwarded
+// header, e.g. host from `Forwarded: for=1.2.3.4;host=example.com;proto=https`.
+func forwardedParam(r *http.Request, name string) string {
+	header := r.Header.Get("Forwarded")
+	if header == "" {
+		return ""
+	}
+	first := strings.Split(header, ",")[0]
+	for _, pair := range strings.Split(first, ";") {
+		key, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || !strings.EqualFold(key, name) {
+			continue
+		}
+		return strings.Trim(strings.TrimSpace(val), `"`)
+	}
+	return ""
+}
@@ -0,0 +1,84 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// throughProxy returns r as handlers see it behind TrustProxy.
+func throughProxy(r *http.Request) *http.Request {
+	var seen *http.Request
+	TrustProxy(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		seen = r
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	return seen
+}
+
+func TestPublicURLsBehindProxy(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted bool
+		headers map[string]string
+		room    string
+		ws      string
+	}{
+		{
+			name: "no proxy headers",
+			room: "http://internal:8080/rooms/ABC123",
+			ws:   "ws://internal:8080/ws",
+		},
+		{
+			name:    "untrusted X-Forwarded-Host is ignored",
+			headers: map[string]string{"X-Forwarded-Host": "evil.example"},
+			room:    "http://internal:8080/rooms/ABC123",
+			ws:      "ws://internal:8080/ws",
+		},
+		{
+			name:    "untrusted Forwarded is ignored",
+			headers: map[string]string{"Forwarded": "host=evil.example;proto=https"},
+			room:    "http://internal:8080/rooms/ABC123",
+			ws:      "ws://internal:8080/ws",
+		},
+		{
+			name:    "trusted X-Forwarded-Host",
+			trusted: true,
+			headers: map[string]string{"X-Forwarded-Host": "chat.example, internal", "X-Forwarded-Proto": "https"},
+			room:    "https://chat.example/rooms/ABC123",
+			ws:      "wss://chat.example/ws",
+		},
+		{
+			name:    "trusted Forwarded wins over X-Forwarded-Host",
+			trusted: true,
+			headers: map[string]string{
+				"Forwarded":        `for=192.0.2.1;host="chat.example:8443";proto=https`,
+				"X-Forwarded-Host": "other.example",
+			},
+			room: "https://chat.example:8443/rooms/ABC123",
+			ws:   "wss://chat.example:8443/ws",
+		},
+		{
+			name:    "trusted proxy without headers",
+			trusted: true,
+			room:    "http://internal:8080/rooms/ABC123",
+			ws:      "ws://internal:8080/ws",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "http://internal:8080/api/rooms", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			if tt.trusted {
+				r = throughProxy(r)
+			}
+			if got := roomURL(r, "ABC123"); got != tt.room {
+				t.Errorf("roomURL = %q, want %q", got, tt.room)
+			}
+			if got := resolveWSURL(Settings{}, r); got != tt.ws {
+				t.Errorf("resolveWSURL = %q, want %q", got, tt.ws)
+			}
+		})
+	}
+}
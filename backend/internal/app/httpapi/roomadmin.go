@@ -0,0 +1,429 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"videochat/internal/app/audit"
+	"videochat/internal/app/rooms"
+	"videochat/pkg/webrtc/signaling"
+)
+
+// RoomPauseHandler pauses or resumes signal/chat fanout for a room
+// (POST /api/rooms/{code}/pause with {"paused": bool}). The flag is stored on the
+// room so a hub created later starts in the same state.
+func RoomPauseHandler(hubs HubManager, store rooms.Store, auditLog audit.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		var body struct {
+			Paused *bool `json:"paused"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Paused == nil {
+			writeJSONError(w, http.StatusBadRequest, `expected {"paused": true|false}`)
+			return
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+
+		if err := store.SetPaused(ctx, code, *body.Paused); err != nil {
+			if errors.Is(err, rooms.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			log.Printf("room pause error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to update room")
+			return
+		}
+		if hub := hubs.ExistingHub(code); hub != nil {
+			hub.SetPaused(*body.Paused)
+		}
+		log.Printf("admin: room %s paused=%v", code, *body.Paused)
+		action := "resume"
+		if *body.Paused {
+			action = "pause"
+		}
+		recordAudit(auditLog, r, code, action, "", "")
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "paused": *body.Paused})
+	})
+}
+
+// RoomExtendHandler pushes a room's expiry to ttl from now
+// (POST /api/rooms/{code}/extend with {"ttl": "30m"}). Peers that were warned the
+// room is closing get "room-closing-cancelled" on the next expiry check.
+func RoomExtendHandler(store rooms.Store, auditLog audit.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		var body struct {
+			TTL string `json:"ttl"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, `expected {"ttl": "<duration>"}`)
+			return
+		}
+		ttl, err := time.ParseDuration(body.TTL)
+		if err != nil || ttl <= 0 {
+			writeJSONError(w, http.StatusBadRequest, "ttl must be a positive duration such as 30m")
+			return
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+
+		if err := store.Extend(ctx, code, ttl); err != nil {
+			if errors.Is(err, rooms.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			log.Printf("room extend error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to update room")
+			return
+		}
+		expiresAt := time.Now().UTC().Add(ttl)
+		log.Printf("admin: room %s extended by %s", code, ttl)
+		recordAudit(auditLog, r, code, "extend", "", "ttl="+ttl.String())
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "expiresAt": expiresAt})
+	})
+}
+
+// RoomWarmHandler starts a room's hub before anyone joins (POST /api/rooms/{code}/warm),
+// so the store resets and Redis setup are done ahead of the first participant. A warm
+// hub nobody joins is cleaned up after a while (see HubManager.WarmRoom).
+func RoomWarmHandler(hubs HubManager, store rooms.Store, auditLog audit.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+
+		room, err := store.Get(ctx, code)
+		if err != nil {
+			if errors.Is(err, rooms.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			log.Printf("room warm lookup error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "room lookup failed")
+			return
+		}
+		if room.Status == rooms.StatusClosing {
+			writeJSONError(w, http.StatusConflict, "room is closing")
+			return
+		}
+		warm := hubs.ExistingHub(code) != nil
+		if hubs.WarmRoom(code) == nil {
+			writeJSONError(w, http.StatusInternalServerError, "room not available")
+			return
+		}
+		if !warm {
+			log.Printf("admin: room %s warmed", code)
+			recordAudit(auditLog, r, code, "warm", "", "")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "alreadyRunning": warm})
+	})
+}
+
+// RoomUsernamesHandler sets many display names at once for a room with a running hub
+// (POST /api/rooms/{code}/usernames with {"usernames": {"peerID": "name", ...}}).
+// Every entry is validated first; if any is invalid nothing is written and the
+// offending peer IDs are returned.
+func RoomUsernamesHandler(hubs HubManager, auditLog audit.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		var body struct {
+			Usernames map[string]string `json:"usernames"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.Usernames) == 0 {
+			writeJSONError(w, http.StatusBadRequest, `expected {"usernames": {"peerID": "name"}}`)
+			return
+		}
+
+		names := make(map[string]string, len(body.Usernames))
+		invalid := make(map[string]string)
+		for id, name := range body.Usernames {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				invalid[id] = "empty peer id"
+				continue
+			}
+			normalized, ok := signaling.NormalizeUsername(name)
+			if !ok || normalized == "" {
+				invalid[id] = "invalid username"
+				continue
+			}
+			names[id] = normalized
+		}
+		if len(invalid) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":   "invalid entries",
+				"invalid": invalid,
+			})
+			return
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		hub := hubs.ExistingHub(code)
+		if hub == nil {
+			// Stores are reset when a hub starts, so names written without one would be lost.
+			writeJSONError(w, http.StatusConflict, "room has no active hub")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+		if err := hub.SetUsernames(ctx, names); err != nil {
+			log.Printf("room usernames error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to set usernames")
+			return
+		}
+		log.Printf("admin: room %s set %d usernames", code, len(names))
+		// Names stay out of the audit trail; it may outlive encrypted username storage.
+		for id := range names {
+			recordAudit(auditLog, r, code, "set-username", id, "")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "set": len(names)})
+	})
+}
+
+// RoomRenameHandler moves a room to a new code (POST /api/rooms/{code}/rename with
+// {"newCode": "..."}), e.g. to fix a typo'd vanity code. The new code must be
+// well-formed and unused; connected peers get a "room-renamed" message and are
+// disconnected so they rejoin under the new code.
+func RoomRenameHandler(hubs HubManager, format rooms.CodeFormat, auditLog audit.Logger) http.Handler {
+	// New codes must be in the current format; legacy codes are only for old rooms.
+	format.AcceptLegacy = false
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+
+		var body struct {
+			NewCode string `json:"newCode"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeJSONError(w, http.StatusBadRequest, `expected {"newCode": "..."}`)
+			return
+		}
+		code := strings.TrimSpace(r.PathValue("code"))
+		newCode := strings.TrimSpace(body.NewCode)
+		if err := format.Validate(newCode); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid newCode: "+err.Error())
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+		if err := hubs.RenameRoom(ctx, code, newCode); err != nil {
+			switch {
+			case errors.Is(err, rooms.ErrNotFound):
+				writeJSONError(w, http.StatusNotFound, "room not found")
+			case errors.Is(err, rooms.ErrExists):
+				writeJSONError(w, http.StatusConflict, "target code already exists")
+			default:
+				log.Printf("room rename error: %v", err)
+				writeJSONError(w, http.StatusInternalServerError, "failed to rename room")
+			}
+			return
+		}
+		log.Printf("admin: room %s renamed to %s", code, newCode)
+		// The audit log moved with the room, so record under the new code.
+		recordAudit(auditLog, r, newCode, "rename", newCode, "from="+code)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"code": newCode,
+			"url":  roomURL(r, newCode),
+		})
+	})
+}
+
+// RoomClientsHandler lists the peers connected to this instance's hub for a room next
+// to the Redis presence set (GET /api/rooms/{code}/clients), to spot drift.
+// "presenceOnly" can legitimately hold peers connected to other instances or inside
+// PEER_LEAVE_GRACE; "connectedOnly" should always be empty.
+func RoomClientsHandler(hubs HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		hub := hubs.ExistingHub(code)
+		if hub == nil {
+			writeJSONError(w, http.StatusNotFound, "room has no active hub on this instance")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+		connected := hub.ClientIDs()
+		presence := hub.Snapshot(ctx).Peers
+		if presence == nil {
+			presence = []string{}
+		}
+		connectedOnly, presenceOnly := diffIDs(connected, presence)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"room":          code,
+			"connected":     connected,
+			"presence":      presence,
+			"connectedOnly": connectedOnly,
+			"presenceOnly":  presenceOnly,
+			"drift":         len(connectedOnly) > 0 || len(presenceOnly) > 0,
+		})
+	})
+}
+
+// RoomQueuesHandler reports each connection's outbound queue depth on this instance
+// (GET /api/rooms/{code}/queues). Peers whose depth nears capacity are about to have
+// frames dropped or be disconnected as slow consumers.
+func RoomQueuesHandler(hubs HubManager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		code := strings.TrimSpace(r.PathValue("code"))
+		hub := hubs.ExistingHub(code)
+		if hub == nil {
+			writeJSONError(w, http.StatusNotFound, "room has no active hub on this instance")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"room":  code,
+			"peers": hub.QueueDepths(),
+		})
+	})
+}
+
+// diffIDs returns the IDs only in a and only in b.
+func diffIDs(a, b []string) (onlyA, onlyB []string) {
+	inA := make(map[string]bool, len(a))
+	for _, id := range a {
+		inA[id] = true
+	}
+	inB := make(map[string]bool, len(b))
+	for _, id := range b {
+		inB[id] = true
+		if !inA[id] {
+			onlyB = append(onlyB, id)
+		}
+	}
+	for _, id := range a {
+		if !inB[id] {
+			onlyA = append(onlyA, id)
+		}
+	}
+	if onlyA == nil {
+		onlyA = []string{}
+	}
+	if onlyB == nil {
+		onlyB = []string{}
+	}
+	return onlyA, onlyB
+}
+
+const (
+	maxSyntheticPeers   = 500
+	defaultSyntheticTTL = time.Minute
+	maxSyntheticTTL     = 10 * time.Minute
+)
+
+// SpawnHandler registers synthetic peers in a room for load testing
+// (POST /debug/spawn?room=&count=[&ttl=]). Synthetic peers carry the "synthetic-" ID
+// prefix and leave on their own after ttl (default 1m, max 10m).
+func SpawnHandler(hubs HubManager, store rooms.Store, auditLog audit.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, http.MethodPost)
+			return
+		}
+		q := r.URL.Query()
+		code := strings.TrimSpace(q.Get("room"))
+		count, err := strconv.Atoi(q.Get("count"))
+		if code == "" || err != nil || count <= 0 || count > maxSyntheticPeers {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("room and count (1-%d) are required", maxSyntheticPeers))
+			return
+		}
+		ttl := defaultSyntheticTTL
+		if v := q.Get("ttl"); v != "" {
+			ttl, err = time.ParseDuration(v)
+			if err != nil || ttl <= 0 || ttl > maxSyntheticTTL {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("ttl must be a duration up to %s", maxSyntheticTTL))
+				return
+			}
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+		if _, err := store.Get(ctx, code); err != nil {
+			if errors.Is(err, rooms.ErrNotFound) {
+				writeJSONError(w, http.StatusNotFound, "room not found")
+				return
+			}
+			log.Printf("spawn room lookup error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to lookup room")
+			return
+		}
+
+		hub := hubs.HubForRoom(code)
+		if hub == nil {
+			writeJSONError(w, http.StatusNotFound, "room not found")
+			return
+		}
+		ids, err := hub.SpawnSynthetic(ctx, count, ttl)
+		if err != nil {
+			log.Printf("spawn synthetic peers in %s: %v", code, err)
+		}
+		log.Printf("admin: spawned %d synthetic peers in room %s", len(ids), code)
+		recordAudit(auditLog, r, code, "spawn", "", fmt.Sprintf("count=%d ttl=%s", len(ids), ttl))
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"room":  code,
+			"peers": ids,
+			"ttl":   ttl.String(),
+		})
+	})
+}
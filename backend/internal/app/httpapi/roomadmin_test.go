@@ -0,0 +1,64 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"videochat/internal/app/audit"
+)
+
+func postUsernames(h http.Handler, code, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/api/rooms/"+code+"/usernames", strings.NewReader(body))
+	r.SetPathValue("code", code)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	return rec
+}
+
+func TestRoomUsernamesHandlerSetsAll(t *testing.T) {
+	hubs := newFakeHubs()
+	hub := hubs.HubForRoom("ABC123").(*fakeHub)
+	h := RoomUsernamesHandler(hubs, audit.Nop{})
+
+	rec := postUsernames(h, "ABC123", `{"usernames": {"a": "  Alice ", "b": "Bob"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+	if len(hub.names) != 2 || hub.names["a"] != "Alice" || hub.names["b"] != "Bob" {
+		t.Fatalf("hub got %v, want both names normalized", hub.names)
+	}
+
+	if rec := postUsernames(h, "NOHUB1", `{"usernames": {"a": "Alice"}}`); rec.Code != http.StatusConflict {
+		t.Fatalf("room without a hub: status = %d, want 409", rec.Code)
+	}
+}
+
+func TestRoomUsernamesHandlerRejectsInvalidEntries(t *testing.T) {
+	hubs := newFakeHubs()
+	hub := hubs.HubForRoom("ABC123").(*fakeHub)
+	h := RoomUsernamesHandler(hubs, audit.Nop{})
+
+	rec := postUsernames(h, "ABC123", `{"usernames": {"a": "Alice", "b": "bad\u0007name", " ": "Nobody"}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", rec.Code)
+	}
+	var body struct {
+		Invalid map[string]string `json:"invalid"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if len(body.Invalid) != 2 || body.Invalid["b"] == "" || body.Invalid[""] == "" {
+		t.Fatalf("invalid = %v, want b and the empty id", body.Invalid)
+	}
+	if hub.names != nil {
+		t.Fatalf("a partly invalid batch wrote %v", hub.names)
+	}
+
+	if rec := postUsernames(h, "ABC123", `{"usernames": {}}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("empty batch: status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,50 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// StatsSource reports aggregated server state for the stats endpoint.
+type StatsSource interface {
+	HubCount() int
+	ClientTotal() int
+	// MaxQueueDepth is the deepest outbound queue of any connection.
+	MaxQueueDepth() int
+	RoomCount(ctx context.Context) (int, error)
+}
+
+// StatsHandler returns active hubs, connected clients, stored rooms and uptime.
+func StatsHandler(src StatsSource, started time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, http.MethodGet)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 3*time.Second)
+		defer cancel()
+
+		rooms, err := src.RoomCount(ctx)
+		if err != nil {
+			log.Printf("stats room count error: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "failed to count rooms")
+			return
+		}
+
+		uptime := time.Since(started)
+		w.Header().Set("Content-Type", "application/json")
+		payload := map[string]interface{}{
+			"hubs":          src.HubCount(),
+			"clients":       src.ClientTotal(),
+			"maxQueueDepth": src.MaxQueueDepth(),
+			"rooms":         rooms,
+			"uptime":        uptime.Truncate(time.Second).String(),
+			"uptimeSeconds": int64(uptime.Seconds()),
+		}
+		_ = json.NewEncoder(w).Encode(payload)
+	})
+}
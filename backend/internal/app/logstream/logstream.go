@@ -0,0 +1,77 @@
+// Package logstream fans the server's log output out to live subscribers, such as
+// an admin tailing logs over a WebSocket.
+package logstream
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Broadcaster is an io.Writer that copies every write (one log line) to its
+// subscribers. Writes never block: a subscriber whose buffer is full misses the
+// line, so a slow reader cannot hold up the log path.
+type Broadcaster struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// New returns a Broadcaster with no subscribers.
+func New() *Broadcaster {
+	return &Broadcaster{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscription receives log lines on C until Close.
+type Subscription struct {
+	C       <-chan []byte
+	c       chan []byte
+	dropped atomic.Uint64
+	b       *Broadcaster
+	once    sync.Once
+}
+
+// Subscribe registers a subscriber that buffers up to buffer lines (minimum 1).
+func (b *Broadcaster) Subscribe(buffer int) *Subscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	c := make(chan []byte, buffer)
+	s := &Subscription{C: c, c: c, b: b}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *Broadcaster) Write(p []byte) (int, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if len(b.subs) == 0 {
+		return len(p), nil
+	}
+	// The caller may reuse p (log.Logger does), so subscribers share one copy.
+	line := append([]byte(nil), p...)
+	for s := range b.subs {
+		select {
+		case s.c <- line:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+	return len(p), nil
+}
+
+// Dropped returns how many lines this subscriber missed because its buffer was
+// full, resetting the count.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Swap(0)
+}
+
+// Close unsubscribes; C is closed once no more lines can arrive.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.b.mu.Lock()
+		delete(s.b.subs, s)
+		s.b.mu.Unlock()
+		close(s.c)
+	})
+}
@@ -0,0 +1,61 @@
+// Package redislock provides a minimal Redis mutex (SET NX PX with a random token)
+// for operations that must not run on two instances at once.
+package redislock
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// ErrNotAcquired is returned when the lock is still held elsewhere after retrying.
+var ErrNotAcquired = errors.New("lock not acquired")
+
+// releaseScript deletes the key only if it still holds our token, so a lock that
+// expired and was taken by someone else is never released by mistake.
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+const retryInterval = 50 * time.Millisecond
+
+// Lock is a held Redis lock; call Release when done.
+type Lock struct {
+	rdb   *redis.Client
+	key   string
+	token string
+}
+
+// Acquire takes key for ttl, retrying until ctx is done. ttl bounds how long a crashed
+// holder can block others.
+func Acquire(ctx context.Context, rdb *redis.Client, key string, ttl time.Duration) (*Lock, error) {
+	token := uuid.NewString()
+	for {
+		ok, err := rdb.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return &Lock{rdb: rdb, key: key, token: token}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ErrNotAcquired
+		case <-time.After(retryInterval):
+		}
+	}
+}
+
+// Release frees the lock if it is still ours. It uses its own short timeout so it
+// works even when the caller's context has already expired.
+func (l *Lock) Release() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return releaseScript.Run(ctx, l.rdb, []string{l.key}, l.token).Err()
+}
@@ -4,36 +4,116 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"videochat/pkg/webrtc/protocol"
 )
 
 // Room represents a private room that can be joined via its code.
 type Room struct {
-	Code      string    `json:"code"`
-	CreatedAt time.Time `json:"createdAt"`
+	Code      string          `json:"code"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Features  map[string]bool `json:"features,omitempty"`
+	// Status is empty for active rooms and StatusClosing during the soft-delete grace window.
+	Status string `json:"status,omitempty"`
+	// ClosesAt is when a closing room will be removed.
+	ClosesAt *time.Time `json:"closesAt,omitempty"`
+	// Paused stops signal/chat fanout while presence keeps working.
+	Paused bool `json:"paused,omitempty"`
+	// Locked rejects new joins while the peers already in the room stay.
+	Locked bool `json:"locked,omitempty"`
+	// ExpiresAt is when a room created with a TTL is removed (nil = no TTL).
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	// ICETransportPolicy is "relay" for rooms that force TURN, empty otherwise.
+	ICETransportPolicy string `json:"iceTransportPolicy,omitempty"`
+	// Topology is "relay" for rooms whose peers connect only to an elected relay peer,
+	// empty for the default full mesh.
+	Topology string `json:"topology,omitempty"`
+	// RequireName holds peers back from the room until they set a display name.
+	RequireName bool `json:"requireName,omitempty"`
+	// OwnerID is the identity of the room's creator, empty when unknown. It is not
+	// exposed to clients.
+	OwnerID string `json:"-"`
+}
+
+// StatusClosing marks a room that was soft-deleted and will expire after its grace window.
+const StatusClosing = "closing"
+
+// CreateOptions holds per-room settings chosen at creation time.
+type CreateOptions struct {
+	// Features toggles optional room capabilities (e.g., "chat"); unset features default to enabled.
+	Features map[string]bool `json:"features,omitempty"`
+	// TTLSeconds, when positive, removes the room that long after creation
+	// (it can be pushed back with Extend).
+	TTLSeconds int `json:"ttlSeconds,omitempty"`
+	// ICETransportPolicy "relay" makes the room's peers use TURN only, overriding the
+	// server's ICE mode (e.g. for rooms with external guests). "" or "all" keeps it.
+	ICETransportPolicy string `json:"iceTransportPolicy,omitempty"`
+	// Topology "relay" designates one peer as relay and has the others connect only
+	// to it, for larger rooms; "" or "mesh" keeps every peer connected to every other.
+	Topology string `json:"topology,omitempty"`
+	// RequireName makes peers set a display name before the room announces them;
+	// false allows anonymous peers.
+	RequireName bool `json:"requireName,omitempty"`
+	// OwnerID records who creates the room, counting it toward their cap (see
+	// SetMaxRoomsPerOwner). Set by the server from a verified identity, never from
+	// the request body.
+	OwnerID string `json:"-"`
 }
 
+// ErrInvalidOptions is returned by Create for unsupported CreateOptions values.
+var ErrInvalidOptions = errors.New("invalid room options")
+
 // Store describes room creation and lookup operations.
 type Store interface {
-	Create(ctx context.Context) (*Room, error)
+	Create(ctx context.Context, opts CreateOptions) (*Room, error)
 	Get(ctx context.Context, code string) (*Room, error)
 	Delete(ctx context.Context, code string) error
+	MarkClosing(ctx context.Context, code string, grace time.Duration) error
+	Reopen(ctx context.Context, code string) error
+	SetPaused(ctx context.Context, code string, paused bool) error
+	SetLocked(ctx context.Context, code string, locked bool) error
+	Extend(ctx context.Context, code string, ttl time.Duration) error
+	Rename(ctx context.Context, oldCode, newCode string, moveKeys map[string]string) error
+	Count(ctx context.Context) (int, error)
+	List(ctx context.Context, cursor string, limit int) ([]string, string, error)
 }
 
 // RedisStore persists room metadata in Redis.
 type RedisStore struct {
 	rdb    *redis.Client
 	prefix string
+	// codeFormat shapes generated codes; the zero value keeps the base64url codes.
+	codeFormat CodeFormat
+	// scanCount is the SCAN COUNT hint List uses (0 = defaultScanCount).
+	scanCount int64
+	// maxPerOwner caps the rooms one OwnerID may hold at once (0 = unlimited).
+	maxPerOwner int
 }
 
+// ErrOwnerLimit is returned by Create when the owner already has the maximum
+// number of rooms (see SetMaxRoomsPerOwner).
+var ErrOwnerLimit = errors.New("too many rooms for this owner")
+
 // ErrNotFound is returned when a room code does not exist.
 var ErrNotFound = errors.New("room not found")
 
+// ErrExists is returned when renaming onto a code that is already taken.
+var ErrExists = errors.New("room already exists")
+
+// CrockfordAlphabet is Crockford's base32 alphabet in lowercase: no i, l, o or u,
+// so codes are easy to read out loud and hard to mistype.
+const CrockfordAlphabet = "0123456789abcdefghjkmnpqrstvwxyz"
+
+const defaultCodeLength = 8
+
 // NewRedisStore builds a room store scoped under the provided prefix (e.g., "webrtc").
 func NewRedisStore(rdb *redis.Client, prefix string) *RedisStore {
 	p := strings.TrimSuffix(strings.TrimSpace(prefix), ":")
@@ -47,10 +127,125 @@ func (s *RedisStore) roomKey(code string) string {
 	return fmt.Sprintf("%s:rooms:%s", s.prefix, code)
 }
 
+// ownerKey is the set of room codes owned by owner. Members whose room is gone
+// (e.g. expired with its TTL) are pruned when counted.
+func (s *RedisStore) ownerKey(owner string) string {
+	return fmt.Sprintf("%s:owner:%s:rooms", s.prefix, owner)
+}
+
+// CodeFormat describes generated room codes: Length characters drawn from Alphabet
+// (URL-safe ASCII: letters, digits, '-' and '_'). The zero value means the default
+// 8-character base64url codes.
+type CodeFormat struct {
+	Alphabet string
+	Length   int
+	// AcceptLegacy keeps default-format codes valid under a custom Alphabet, for
+	// rooms created before the format changed.
+	AcceptLegacy bool
+}
+
+// NewCodeFormat checks alphabet and length; an empty alphabet yields the default format
+// and a non-positive length defaults to 8.
+func NewCodeFormat(alphabet string, length int) (CodeFormat, error) {
+	if alphabet == "" {
+		return CodeFormat{}, nil
+	}
+	seen := make(map[rune]bool)
+	for _, r := range alphabet {
+		if !urlSafe(r) {
+			return CodeFormat{}, fmt.Errorf("room code alphabet: %q is not URL-safe", r)
+		}
+		if seen[r] {
+			return CodeFormat{}, fmt.Errorf("room code alphabet: duplicate %q", r)
+		}
+		seen[r] = true
+	}
+	if len(seen) < 2 {
+		return CodeFormat{}, errors.New("room code alphabet: need at least 2 characters")
+	}
+	if length <= 0 {
+		length = defaultCodeLength
+	}
+	if length < 4 || length > 64 {
+		return CodeFormat{}, fmt.Errorf("room code length %d out of range 4-64", length)
+	}
+	return CodeFormat{Alphabet: alphabet, Length: length}, nil
+}
+
+// Validate checks only the shape of code (length and characters), without any
+// lookup. Codes in the default base64url format are also accepted under a custom
+// alphabet when AcceptLegacy is set.
+func (f CodeFormat) Validate(code string) error {
+	if code == "" {
+		return errors.New("code is empty")
+	}
+	if (f.Alphabet == "" || f.AcceptLegacy) && validLegacyCode(code) {
+		return nil
+	}
+	if f.Alphabet == "" {
+		if len(code) != defaultCodeLength {
+			return fmt.Errorf("code must be %d characters", defaultCodeLength)
+		}
+		return errors.New("code contains invalid characters")
+	}
+	if len(code) != f.Length {
+		return fmt.Errorf("code must be %d characters", f.Length)
+	}
+	for _, r := range code {
+		if !strings.ContainsRune(f.Alphabet, r) {
+			return fmt.Errorf("code contains invalid character %q", r)
+		}
+	}
+	return nil
+}
+
+func validLegacyCode(code string) bool {
+	if len(code) != defaultCodeLength {
+		return false
+	}
+	for _, r := range code {
+		if !urlSafe(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func urlSafe(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_'
+}
+
+// SetCodeFormat makes Create generate codes in format f. Existing rooms keep working
+// whatever their code looks like.
+func (s *RedisStore) SetCodeFormat(f CodeFormat) {
+	s.codeFormat = f
+}
+
 // Create generates a new room code and stores it.
-func (s *RedisStore) Create(ctx context.Context) (*Room, error) {
+func (s *RedisStore) Create(ctx context.Context, opts CreateOptions) (*Room, error) {
+	switch opts.ICETransportPolicy {
+	case "", "all":
+		opts.ICETransportPolicy = ""
+	case "relay":
+	default:
+		return nil, fmt.Errorf("%w: iceTransportPolicy must be \"all\" or \"relay\"", ErrInvalidOptions)
+	}
+	switch opts.Topology {
+	case "", "mesh":
+		opts.Topology = ""
+	case "relay":
+	default:
+		return nil, fmt.Errorf("%w: topology must be \"mesh\" or \"relay\"", ErrInvalidOptions)
+	}
+	for name := range opts.Features {
+		switch name {
+		case protocol.FeatureChat, protocol.FeatureReactions, protocol.FeatureRecording, protocol.FeatureNotifications:
+		default:
+			return nil, fmt.Errorf("%w: unknown feature %q", ErrInvalidOptions, name)
+		}
+	}
 	for i := 0; i < 5; i++ {
-		code := generateCode()
+		code := s.generateCode()
 		key := s.roomKey(code)
 		exists, err := s.rdb.Exists(ctx, key).Result()
 		if err != nil {
@@ -60,17 +255,102 @@ func (s *RedisStore) Create(ctx context.Context) (*Room, error) {
 			continue
 		}
 		now := time.Now().UTC()
-		if err := s.rdb.HSet(ctx, key, map[string]interface{}{
+		fields := map[string]interface{}{
 			"code":       code,
 			"created_at": now.Format(time.RFC3339),
-		}).Err(); err != nil {
+		}
+		if len(opts.Features) > 0 {
+			raw, err := json.Marshal(opts.Features)
+			if err != nil {
+				return nil, err
+			}
+			fields["features"] = string(raw)
+		}
+		room := &Room{Code: code, CreatedAt: now, Features: opts.Features, ICETransportPolicy: opts.ICETransportPolicy, Topology: opts.Topology, RequireName: opts.RequireName}
+		if opts.ICETransportPolicy != "" {
+			fields["ice_transport_policy"] = opts.ICETransportPolicy
+		}
+		if opts.Topology != "" {
+			fields["topology"] = opts.Topology
+		}
+		if opts.RequireName {
+			fields["require_name"] = "1"
+		}
+		if opts.TTLSeconds > 0 {
+			expiresAt := now.Add(time.Duration(opts.TTLSeconds) * time.Second)
+			fields["expires_at"] = expiresAt.Format(time.RFC3339)
+			room.ExpiresAt = &expiresAt
+		}
+		if opts.OwnerID != "" {
+			fields["owner"] = opts.OwnerID
+			room.OwnerID = opts.OwnerID
+		}
+		_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, fields)
+			if room.ExpiresAt != nil {
+				pipe.ExpireAt(ctx, key, *room.ExpiresAt)
+			}
+			if room.OwnerID != "" {
+				pipe.SAdd(ctx, s.ownerKey(room.OwnerID), code)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.checkOwnerLimit(ctx, room); err != nil {
 			return nil, err
 		}
-		return &Room{Code: code, CreatedAt: now}, nil
+		return room, nil
 	}
 	return nil, errors.New("failed to generate unique room code")
 }
 
+// ownerCountScript counts the rooms in the owner set KEYS[1] that still exist (room
+// keys are ARGV[1] .. code), dropping the rest.
+var ownerCountScript = redis.NewScript(`
+local n = 0
+for _, code in ipairs(redis.call("SMEMBERS", KEYS[1])) do
+	if redis.call("EXISTS", ARGV[1] .. code) == 1 then
+		n = n + 1
+	else
+		redis.call("SREM", KEYS[1], code)
+	end
+end
+return n
+`)
+
+// SetMaxRoomsPerOwner caps how many rooms one CreateOptions.OwnerID may hold at
+// once; Create fails with ErrOwnerLimit past it. Deleted and closing rooms stop
+// counting. Non-positive means unlimited.
+func (s *RedisStore) SetMaxRoomsPerOwner(n int) {
+	s.maxPerOwner = n
+}
+
+// checkOwnerLimit undoes the just-created room when it took its owner past the cap.
+// Counting after the write keeps concurrent creates from both slipping under it.
+func (s *RedisStore) checkOwnerLimit(ctx context.Context, room *Room) error {
+	if room.OwnerID == "" || s.maxPerOwner <= 0 {
+		return nil
+	}
+	n, err := ownerCountScript.Run(ctx, s.rdb, []string{s.ownerKey(room.OwnerID)}, s.roomKey("")).Int()
+	if err == nil && n <= s.maxPerOwner {
+		return nil
+	}
+	_, delErr := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, s.roomKey(room.Code))
+		pipe.SRem(ctx, s.ownerKey(room.OwnerID), room.Code)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if delErr != nil {
+		return delErr
+	}
+	return ErrOwnerLimit
+}
+
 // Get fetches a room by code, returning ErrNotFound when missing.
 func (s *RedisStore) Get(ctx context.Context, code string) (*Room, error) {
 	code = strings.TrimSpace(code)
@@ -93,7 +373,98 @@ func (s *RedisStore) Get(ctx context.Context, code string) (*Room, error) {
 		}
 	}
 
-	return &Room{Code: code, CreatedAt: createdAt}, nil
+	var features map[string]bool
+	if raw, ok := vals["features"]; ok && raw != "" {
+		if err := json.Unmarshal([]byte(raw), &features); err != nil {
+			return nil, fmt.Errorf("decode room features: %w", err)
+		}
+	}
+
+	room := &Room{
+		Code:               code,
+		CreatedAt:          createdAt,
+		Features:           features,
+		Status:             vals["status"],
+		Paused:             vals["paused"] == "1",
+		Locked:             vals["locked"] == "1",
+		ICETransportPolicy: vals["ice_transport_policy"],
+		Topology:           vals["topology"],
+		RequireName:        vals["require_name"] == "1",
+		OwnerID:            vals["owner"],
+	}
+	if ts, ok := vals["closes_at"]; ok {
+		if parsed, err := time.Parse(time.RFC3339, ts); err == nil {
+			room.ClosesAt = &parsed
+		}
+	}
+	if ts, ok := vals["expires_at"]; ok {
+		if parsed, err := time.Parse(time.RFC3339, ts); err == nil {
+			room.ExpiresAt = &parsed
+		}
+	}
+	return room, nil
+}
+
+// renameScript moves the room hash (KEYS[1] -> KEYS[2]) and any extra key pairs
+// (KEYS[3] -> KEYS[4], ...) in one atomic step. A target whose status is ARGV[2]
+// (closing) is reclaimed: it and its extra keys are dropped first. The owner's set
+// (ARGV[3] .. owner .. ":rooms") swaps the old code (ARGV[4]) for the new one.
+// Returns -1 if the source is missing and 0 if the target exists.
+var renameScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+if redis.call("EXISTS", KEYS[2]) == 1 then
+	if redis.call("HGET", KEYS[2], "status") ~= ARGV[2] then
+		return 0
+	end
+	redis.call("DEL", KEYS[2])
+	for i = 4, #KEYS, 2 do
+		redis.call("DEL", KEYS[i])
+	end
+end
+redis.call("RENAME", KEYS[1], KEYS[2])
+redis.call("HSET", KEYS[2], "code", ARGV[1])
+local owner = redis.call("HGET", KEYS[2], "owner")
+if owner then
+	redis.call("SREM", ARGV[3] .. owner .. ":rooms", ARGV[4])
+	redis.call("SADD", ARGV[3] .. owner .. ":rooms", ARGV[1])
+end
+for i = 3, #KEYS, 2 do
+	if redis.call("EXISTS", KEYS[i]) == 1 then
+		redis.call("RENAME", KEYS[i], KEYS[i + 1])
+	end
+end
+return 1
+`)
+
+// Rename moves a room to newCode, together with moveKeys (old key -> new key, e.g.
+// per-room chat history), atomically. It fails with ErrExists if newCode is taken by
+// an active room; a room still in its closing grace window gives its code up, and
+// its moveKeys targets are dropped so nothing of it leaks into the renamed room.
+func (s *RedisStore) Rename(ctx context.Context, oldCode, newCode string, moveKeys map[string]string) error {
+	oldCode, newCode = strings.TrimSpace(oldCode), strings.TrimSpace(newCode)
+	if oldCode == "" {
+		return ErrNotFound
+	}
+	if newCode == "" || newCode == oldCode {
+		return ErrExists
+	}
+	keys := []string{s.roomKey(oldCode), s.roomKey(newCode)}
+	for from, to := range moveKeys {
+		keys = append(keys, from, to)
+	}
+	res, err := renameScript.Run(ctx, s.rdb, keys, newCode, StatusClosing, s.prefix+":owner:", oldCode).Int()
+	if err != nil {
+		return err
+	}
+	switch res {
+	case -1:
+		return ErrNotFound
+	case 0:
+		return ErrExists
+	}
+	return nil
 }
 
 // Delete removes a room by code, returning ErrNotFound when the room does not exist.
@@ -102,16 +473,275 @@ func (s *RedisStore) Delete(ctx context.Context, code string) error {
 	if code == "" {
 		return ErrNotFound
 	}
-	deleted, err := s.rdb.Del(ctx, s.roomKey(code)).Result()
+	key := s.roomKey(code)
+	owner, err := s.rdb.HGet(ctx, key, "owner").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	var deleted *redis.IntCmd
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		deleted = pipe.Del(ctx, key)
+		if owner != "" {
+			pipe.SRem(ctx, s.ownerKey(owner), code)
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	if deleted == 0 {
+	if deleted.Val() == 0 {
 		return ErrNotFound
 	}
 	return nil
 }
 
+// MarkClosing soft-deletes a room: it stays readable via Get (with Status set to
+// StatusClosing) until grace elapses, after which Redis expires it.
+func (s *RedisStore) MarkClosing(ctx context.Context, code string, grace time.Duration) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrNotFound
+	}
+	key := s.roomKey(code)
+	exists, err := s.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrNotFound
+	}
+	owner, err := s.rdb.HGet(ctx, key, "owner").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	closesAt := time.Now().UTC().Add(grace)
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, map[string]interface{}{
+			"status":    StatusClosing,
+			"closes_at": closesAt.Format(time.RFC3339),
+		})
+		pipe.Expire(ctx, key, grace)
+		// A closing room no longer counts toward its owner's cap; Reopen adds it back.
+		if owner != "" {
+			pipe.SRem(ctx, s.ownerKey(owner), code)
+		}
+		return nil
+	})
+	return err
+}
+
+// Reopen returns a closing room to the active state and cancels its grace expiry;
+// a room created with a TTL goes back to expiring at its ExpiresAt.
+func (s *RedisStore) Reopen(ctx context.Context, code string) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrNotFound
+	}
+	key := s.roomKey(code)
+	var persisted *redis.BoolCmd
+	var expiresAt, owner *redis.StringCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, key, "status", "closes_at")
+		persisted = pipe.Persist(ctx, key)
+		expiresAt = pipe.HGet(ctx, key, "expires_at")
+		owner = pipe.HGet(ctx, key, "owner")
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if !persisted.Val() {
+		// PERSIST reports false both for missing keys and keys without a TTL.
+		exists, err := s.rdb.Exists(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		if exists == 0 {
+			return ErrNotFound
+		}
+	}
+	if o := owner.Val(); o != "" {
+		if err := s.rdb.SAdd(ctx, s.ownerKey(o), code).Err(); err != nil {
+			return err
+		}
+	}
+	if ts := expiresAt.Val(); ts != "" {
+		if parsed, err := time.Parse(time.RFC3339, ts); err == nil {
+			return s.rdb.ExpireAt(ctx, key, parsed).Err()
+		}
+	}
+	return nil
+}
+
+// Extend pushes a room's expiry to ttl from now, giving rooms without one a TTL.
+func (s *RedisStore) Extend(ctx context.Context, code string, ttl time.Duration) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrNotFound
+	}
+	key := s.roomKey(code)
+	exists, err := s.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrNotFound
+	}
+	expiresAt := time.Now().UTC().Add(ttl)
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "expires_at", expiresAt.Format(time.RFC3339))
+		pipe.ExpireAt(ctx, key, expiresAt)
+		return nil
+	})
+	return err
+}
+
+// SetPaused records whether the room's signal/chat fanout is paused.
+func (s *RedisStore) SetPaused(ctx context.Context, code string, paused bool) error {
+	return s.setFlag(ctx, code, "paused", paused)
+}
+
+// SetLocked records whether the room refuses new joins.
+func (s *RedisStore) SetLocked(ctx context.Context, code string, locked bool) error {
+	return s.setFlag(ctx, code, "locked", locked)
+}
+
+// setFlag sets or clears a boolean field on an existing room.
+func (s *RedisStore) setFlag(ctx context.Context, code, field string, on bool) error {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ErrNotFound
+	}
+	key := s.roomKey(code)
+	exists, err := s.rdb.Exists(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrNotFound
+	}
+	if on {
+		return s.rdb.HSet(ctx, key, field, "1").Err()
+	}
+	return s.rdb.HDel(ctx, key, field).Err()
+}
+
+// List limits: MaxListLimit caps the codes returned per call whatever limit asks for,
+// and maxListScans caps the SCAN round-trips per call on a keyspace with few rooms.
+const (
+	MaxListLimit     = 500
+	defaultListLimit = 100
+	defaultScanCount = 100
+	maxListScans     = 64
+)
+
+// ErrInvalidCursor is returned by List for cursors it did not issue.
+var ErrInvalidCursor = errors.New("invalid list cursor")
+
+// SetListScanCount sets the SCAN COUNT hint List uses per round-trip (n <= 0 restores
+// the default of 100).
+func (s *RedisStore) SetListScanCount(n int) {
+	s.scanCount = int64(n)
+}
+
+// List returns up to limit room codes (0 = 100, at most MaxListLimit) starting at
+// cursor ("" = from the beginning), and the cursor to pass next ("" = done). It walks
+// the keyspace with SCAN in batches, never KEYS, so large keyspaces stay cheap; as
+// with SCAN, rooms created or deleted meanwhile may be missed or repeated.
+func (s *RedisStore) List(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	count := s.scanCount
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	// A cursor is "<scan cursor>.<keys of that batch already returned>".
+	var scan uint64
+	skip := 0
+	if cursor != "" {
+		rawScan, rawSkip, ok := strings.Cut(cursor, ".")
+		var err1, err2 error
+		scan, err1 = strconv.ParseUint(rawScan, 10, 64)
+		skip, err2 = strconv.Atoi(rawSkip)
+		if !ok || err1 != nil || err2 != nil || skip < 0 {
+			return nil, "", ErrInvalidCursor
+		}
+	}
+
+	prefix := s.roomKey("")
+	codes := make([]string, 0, limit)
+	for i := 0; i < maxListScans; i++ {
+		keys, next, err := s.rdb.Scan(ctx, scan, s.roomKey("*"), count).Result()
+		if err != nil {
+			return nil, "", err
+		}
+		for j := skip; j < len(keys); j++ {
+			if len(codes) == limit {
+				return codes, fmt.Sprintf("%d.%d", scan, j), nil
+			}
+			codes = append(codes, strings.TrimPrefix(keys[j], prefix))
+		}
+		skip = 0
+		if next == 0 {
+			return codes, "", nil
+		}
+		scan = next
+	}
+	return codes, fmt.Sprintf("%d.0", scan), nil
+}
+
+// Count returns the number of rooms stored under this prefix.
+func (s *RedisStore) Count(ctx context.Context) (int, error) {
+	total := 0
+	iter := s.rdb.Scan(ctx, 0, s.roomKey("*"), 100).Iterator()
+	for iter.Next(ctx) {
+		total++
+	}
+	if err := iter.Err(); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// generateCode produces a room code in the configured format.
+func (s *RedisStore) generateCode() string {
+	if s.codeFormat.Alphabet == "" {
+		return generateCode()
+	}
+	code, err := randomString(s.codeFormat.Alphabet, s.codeFormat.Length)
+	if err != nil {
+		return generateCode()
+	}
+	return code
+}
+
+// randomString draws length characters uniformly from alphabet using rejection sampling.
+func randomString(alphabet string, length int) (string, error) {
+	n := len(alphabet)
+	limit := 256 - 256%n
+	out := make([]byte, 0, length)
+	buf := make([]byte, length*2)
+	for len(out) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			out = append(out, alphabet[int(b)%n])
+			if len(out) == length {
+				break
+			}
+		}
+	}
+	return string(out), nil
+}
+
 // generateCode produces a short, URL-safe room code.
 func generateCode() string {
 	// 6 bytes -> 8 chars when raw URL base64 encoded without padding.
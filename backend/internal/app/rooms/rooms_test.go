@@ -0,0 +1,207 @@
+package rooms
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStore(t *testing.T) (*RedisStore, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return NewRedisStore(rdb, "test"), rdb
+}
+
+func TestListPagesThroughEveryRoom(t *testing.T) {
+	ctx := context.Background()
+	store, rdb := newTestStore(t)
+	store.SetListScanCount(10)
+
+	want := make(map[string]bool)
+	for i := 0; i < 25; i++ {
+		room, err := store.Create(ctx, CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[room.Code] = true
+	}
+	// Per-room state under another namespace must not show up as rooms.
+	if err := rdb.Set(ctx, "test:room:abc:chat", "x", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A limit that does not divide the SCAN batch forces cursors into mid-batch.
+	got := make(map[string]bool)
+	cursor, pages := "", 0
+	for {
+		codes, next, err := store.List(ctx, cursor, 7)
+		if err != nil {
+			t.Fatalf("page %d: %v", pages, err)
+		}
+		if len(codes) > 7 {
+			t.Fatalf("page %d has %d codes, limit is 7", pages, len(codes))
+		}
+		for _, code := range codes {
+			if got[code] {
+				t.Fatalf("code %s listed twice", code)
+			}
+			got[code] = true
+		}
+		pages++
+		if next == "" {
+			break
+		}
+		if pages > 25 {
+			t.Fatal("cursor never finished")
+		}
+		cursor = next
+	}
+	if len(got) != len(want) {
+		t.Fatalf("listed %d rooms, want %d", len(got), len(want))
+	}
+	for code := range want {
+		if !got[code] {
+			t.Errorf("room %s missing from the listing", code)
+		}
+	}
+}
+
+func TestListDefaultsAndCaps(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	for i := 0; i < 3; i++ {
+		if _, err := store.Create(ctx, CreateOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	codes, next, err := store.List(ctx, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != 3 || next != "" {
+		t.Fatalf("got %d codes and cursor %q, want 3 and no cursor", len(codes), next)
+	}
+	if _, _, err := store.List(ctx, "", MaxListLimit+1); err != nil {
+		t.Fatalf("over-limit request: %v", err)
+	}
+}
+
+func TestListRejectsForeignCursors(t *testing.T) {
+	store, _ := newTestStore(t)
+	for _, cursor := range []string{"abc", "12", "1.-1", "x.0", "1.y"} {
+		if _, _, err := store.List(context.Background(), cursor, 10); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("cursor %q: err = %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestCountMatchesList(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStore(t)
+	for i := 0; i < 12; i++ {
+		if _, err := store.Create(ctx, CreateOptions{}); err != nil {
+			t.Fatalf("create %d: %v", i, err)
+		}
+	}
+	n, err := store.Count(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 12 {
+		t.Fatalf("Count = %d, want 12", n)
+	}
+}
+
+func TestOwnerRoomCap(t *testing.T) {
+	ctx := context.Background()
+	store, rdb := newTestStore(t)
+	store.SetMaxRoomsPerOwner(2)
+
+	first, err := store.Create(ctx, CreateOptions{OwnerID: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(ctx, CreateOptions{OwnerID: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(ctx, CreateOptions{OwnerID: "alice"}); !errors.Is(err, ErrOwnerLimit) {
+		t.Fatalf("third room = %v, want ErrOwnerLimit", err)
+	}
+	if n, _ := store.Count(ctx); n != 2 {
+		t.Fatalf("Count = %d, want 2: a refused room must not be left behind", n)
+	}
+	// Other owners and ownerless rooms are not affected.
+	if _, err := store.Create(ctx, CreateOptions{OwnerID: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(ctx, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Deleting a room frees its slot.
+	if err := store.Delete(ctx, first.Code); err != nil {
+		t.Fatal(err)
+	}
+	third, err := store.Create(ctx, CreateOptions{OwnerID: "alice"})
+	if err != nil {
+		t.Fatalf("create after delete: %v", err)
+	}
+	if got, _ := store.Get(ctx, third.Code); got.OwnerID != "alice" {
+		t.Fatalf("OwnerID = %q, want alice", got.OwnerID)
+	}
+
+	// So does the close grace, until the room is reopened.
+	if err := store.MarkClosing(ctx, third.Code, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	fourth, err := store.Create(ctx, CreateOptions{OwnerID: "alice"})
+	if err != nil {
+		t.Fatalf("create after close: %v", err)
+	}
+	if err := store.Reopen(ctx, third.Code); err != nil {
+		t.Fatal(err)
+	}
+	// Reopening counts the room again, so fourth expiring still leaves alice at the cap...
+	if err := rdb.Del(ctx, store.roomKey(fourth.Code)).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(ctx, CreateOptions{OwnerID: "alice"}); !errors.Is(err, ErrOwnerLimit) {
+		t.Fatalf("create at the cap = %v, want ErrOwnerLimit", err)
+	}
+	// ...and a second expiry frees a slot.
+	if err := rdb.Del(ctx, store.roomKey(third.Code)).Err(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(ctx, CreateOptions{OwnerID: "alice"}); err != nil {
+		t.Fatalf("create after expiry: %v", err)
+	}
+}
+
+func TestOwnerRoomCapFollowsRename(t *testing.T) {
+	ctx := context.Background()
+	store, rdb := newTestStore(t)
+	store.SetMaxRoomsPerOwner(1)
+	room, err := store.Create(ctx, CreateOptions{OwnerID: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Rename(ctx, room.Code, "renamed", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create(ctx, CreateOptions{OwnerID: "alice"}); !errors.Is(err, ErrOwnerLimit) {
+		t.Fatalf("create after rename = %v, want ErrOwnerLimit", err)
+	}
+	members, err := rdb.SMembers(ctx, store.ownerKey("alice")).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0] != "renamed" {
+		t.Fatalf("owner set = %v, want [renamed]", members)
+	}
+}
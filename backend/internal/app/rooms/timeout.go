@@ -0,0 +1,86 @@
+package rooms
+
+import (
+	"context"
+	"time"
+)
+
+// WithTimeout wraps s so every call runs under a context bounded by d.
+// A non-positive d returns s unchanged.
+func WithTimeout(s Store, d time.Duration) Store {
+	if d <= 0 {
+		return s
+	}
+	return &timeoutStore{next: s, timeout: d}
+}
+
+type timeoutStore struct {
+	next    Store
+	timeout time.Duration
+}
+
+func (s *timeoutStore) Create(ctx context.Context, opts CreateOptions) (*Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Create(ctx, opts)
+}
+
+func (s *timeoutStore) Get(ctx context.Context, code string) (*Room, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Get(ctx, code)
+}
+
+func (s *timeoutStore) MarkClosing(ctx context.Context, code string, grace time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.MarkClosing(ctx, code, grace)
+}
+
+func (s *timeoutStore) Reopen(ctx context.Context, code string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Reopen(ctx, code)
+}
+
+func (s *timeoutStore) SetPaused(ctx context.Context, code string, paused bool) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.SetPaused(ctx, code, paused)
+}
+
+func (s *timeoutStore) SetLocked(ctx context.Context, code string, locked bool) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.SetLocked(ctx, code, locked)
+}
+
+func (s *timeoutStore) Extend(ctx context.Context, code string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Extend(ctx, code, ttl)
+}
+
+func (s *timeoutStore) Count(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Count(ctx)
+}
+
+func (s *timeoutStore) List(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.List(ctx, cursor, limit)
+}
+
+func (s *timeoutStore) Delete(ctx context.Context, code string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Delete(ctx, code)
+}
+
+func (s *timeoutStore) Rename(ctx context.Context, oldCode, newCode string, moveKeys map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Rename(ctx, oldCode, newCode, moveKeys)
+}
@@ -0,0 +1,127 @@
+package usernames
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// encPrefix marks encrypted values so plaintext names written before encryption
+// was enabled are still readable.
+const encPrefix = "enc1:"
+
+// ErrBadKey is returned by WithEncryption for keys that are not 16, 24 or 32 bytes.
+var ErrBadKey = errors.New("usernames: encryption key must be 16, 24 or 32 bytes")
+
+// WithEncryption wraps s so display names are AES-GCM encrypted at rest. Values
+// that cannot be decrypted (wrong key, corruption) are left out of Usernames rather
+// than failing the whole read; unprefixed plaintext values are returned as-is.
+// Each name is bound to its room and peer ID as associated data, so a value
+// copied to another peer or room fails to decrypt. Peer attributes are stored
+// unchanged.
+func WithEncryption(s Store, key []byte, room string) (Store, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStore{next: s, aead: aead, room: room}, nil
+}
+
+type encryptedStore struct {
+	next Store
+	aead cipher.AEAD
+	room string
+}
+
+// ad is the associated data binding a name to its room and peer.
+func (s *encryptedStore) ad(id string) []byte {
+	return []byte(s.room + "\x00" + id)
+}
+
+func (s *encryptedStore) seal(id, name string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(name), s.ad(id))
+	return encPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (s *encryptedStore) open(id, value string) (string, bool) {
+	if !strings.HasPrefix(value, encPrefix) {
+		return value, true
+	}
+	raw, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, encPrefix))
+	if err != nil || len(raw) < s.aead.NonceSize() {
+		return "", false
+	}
+	nonce, sealed := raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, sealed, s.ad(id))
+	if err != nil {
+		return "", false
+	}
+	return string(plain), true
+}
+
+func (s *encryptedStore) Reset(ctx context.Context) error {
+	return s.next.Reset(ctx)
+}
+
+func (s *encryptedStore) RemovePeer(ctx context.Context, id string) error {
+	return s.next.RemovePeer(ctx, id)
+}
+
+func (s *encryptedStore) SetUsername(ctx context.Context, id string, username string) error {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return s.next.SetUsername(ctx, id, "")
+	}
+	sealed, err := s.seal(id, username)
+	if err != nil {
+		return err
+	}
+	return s.next.SetUsername(ctx, id, sealed)
+}
+
+func (s *encryptedStore) SetUsernames(ctx context.Context, names map[string]string) error {
+	sealed := make(map[string]string, len(names))
+	for id, name := range names {
+		v, err := s.seal(id, name)
+		if err != nil {
+			return err
+		}
+		sealed[id] = v
+	}
+	return s.next.SetUsernames(ctx, sealed)
+}
+
+func (s *encryptedStore) Usernames(ctx context.Context) (map[string]string, error) {
+	vals, err := s.next.Usernames(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(vals))
+	for id, v := range vals {
+		if name, ok := s.open(id, v); ok {
+			out[id] = name
+		}
+	}
+	return out, nil
+}
+
+func (s *encryptedStore) SetPeerMeta(ctx context.Context, id string, meta []byte) error {
+	return s.next.SetPeerMeta(ctx, id, meta)
+}
+
+func (s *encryptedStore) PeerMeta(ctx context.Context) (map[string]json.RawMessage, error) {
+	return s.next.PeerMeta(ctx)
+}
@@ -0,0 +1,92 @@
+package usernames
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte("k"), 32)
+
+func TestEncryptedUsernamesRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestStore(t)
+	s, err := WithEncryption(raw, testKey, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUsername(ctx, "a", " Alice "); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUsernames(ctx, map[string]string{"b": "Bob", "c": "Carol"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := s.Usernames(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got["a"] != "Alice" || got["b"] != "Bob" || got["c"] != "Carol" {
+		t.Fatalf("usernames = %v", got)
+	}
+
+	stored, _ := raw.Usernames(ctx)
+	for id, v := range stored {
+		if !strings.HasPrefix(v, encPrefix) || strings.Contains(v, got[id]) {
+			t.Fatalf("stored value for %s is not encrypted: %q", id, v)
+		}
+	}
+}
+
+func TestEncryptedUsernamesWrongKey(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestStore(t)
+	s, _ := WithEncryption(raw, testKey, "abc")
+	if err := s.SetUsername(ctx, "a", "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	// Written before encryption was turned on.
+	if err := raw.SetUsername(ctx, "b", "Bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := WithEncryption(raw, bytes.Repeat([]byte("x"), 32), "abc")
+	got, err := other.Usernames(ctx)
+	if err != nil {
+		t.Fatalf("wrong key failed the whole read: %v", err)
+	}
+	if _, ok := got["a"]; ok {
+		t.Fatalf("name sealed under another key was returned: %v", got)
+	}
+	if got["b"] != "Bob" {
+		t.Fatalf("plaintext name = %q, want Bob", got["b"])
+	}
+}
+
+func TestEncryptedUsernamesBoundToPeer(t *testing.T) {
+	ctx := context.Background()
+	raw := newTestStore(t)
+	s, _ := WithEncryption(raw, testKey, "abc")
+	if err := s.SetUsername(ctx, "a", "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := raw.Usernames(ctx)
+	if err := raw.SetUsername(ctx, "mallory", stored["a"]); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Usernames(ctx); got["mallory"] != "" {
+		t.Fatal("a value copied to another peer decrypted")
+	}
+	otherRoom, _ := WithEncryption(raw, testKey, "xyz")
+	if got, _ := otherRoom.Usernames(ctx); got["a"] != "" {
+		t.Fatal("a value decrypted under another room")
+	}
+}
+
+func TestWithEncryptionRejectsBadKey(t *testing.T) {
+	if _, err := WithEncryption(newTestStore(t), []byte("short"), "abc"); !errors.Is(err, ErrBadKey) {
+		t.Fatalf("err = %v, want ErrBadKey", err)
+	}
+}
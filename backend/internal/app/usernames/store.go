@@ -2,6 +2,7 @@ package usernames
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -13,13 +14,19 @@ type Store interface {
 	Reset(ctx context.Context) error
 	RemovePeer(ctx context.Context, id string) error
 	SetUsername(ctx context.Context, id string, username string) error
+	SetUsernames(ctx context.Context, names map[string]string) error
 	Usernames(ctx context.Context) (map[string]string, error)
+	// SetPeerMeta stores a peer's client-set attributes (a JSON object); an empty
+	// meta clears them. PeerMeta returns every peer's attributes.
+	SetPeerMeta(ctx context.Context, id string, meta []byte) error
+	PeerMeta(ctx context.Context) (map[string]json.RawMessage, error)
 }
 
-// RedisStore implements Store using a Redis hash.
+// RedisStore implements Store using Redis hashes (names and peer attributes).
 type RedisStore struct {
 	rdb          *redis.Client
 	keyUsernames string
+	keyPeerMeta  string
 }
 
 // NewRedisStore builds a Store backed by Redis. Prefix is optional (e.g., "webrtc:room:abc123").
@@ -31,15 +38,26 @@ func NewRedisStore(rdb *redis.Client, prefix string) *RedisStore {
 	return &RedisStore{
 		rdb:          rdb,
 		keyUsernames: fmt.Sprintf("%s:usernames", p),
+		keyPeerMeta:  fmt.Sprintf("%s:peermeta", p),
 	}
 }
 
+// Keys lists the Redis keys the store writes, e.g. for moving them with a renamed room.
+func (s *RedisStore) Keys() []string {
+	return []string{s.keyUsernames, s.keyPeerMeta}
+}
+
 func (s *RedisStore) Reset(ctx context.Context) error {
-	return s.rdb.Del(ctx, s.keyUsernames).Err()
+	return s.rdb.Del(ctx, s.keyUsernames, s.keyPeerMeta).Err()
 }
 
 func (s *RedisStore) RemovePeer(ctx context.Context, id string) error {
-	return s.rdb.HDel(ctx, s.keyUsernames, id).Err()
+	_, err := s.rdb.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HDel(ctx, s.keyUsernames, id)
+		pipe.HDel(ctx, s.keyPeerMeta, id)
+		return nil
+	})
+	return err
 }
 
 func (s *RedisStore) SetUsername(ctx context.Context, id string, username string) error {
@@ -50,6 +68,15 @@ func (s *RedisStore) SetUsername(ctx context.Context, id string, username string
 	return s.rdb.HSet(ctx, s.keyUsernames, id, username).Err()
 }
 
+// SetUsernames stores many display names in a single HSET. Entries are written as
+// given; callers validate them first.
+func (s *RedisStore) SetUsernames(ctx context.Context, names map[string]string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	return s.rdb.HSet(ctx, s.keyUsernames, names).Err()
+}
+
 func (s *RedisStore) Usernames(ctx context.Context) (map[string]string, error) {
 	vals, err := s.rdb.HGetAll(ctx, s.keyUsernames).Result()
 	if err != nil {
@@ -57,3 +84,22 @@ func (s *RedisStore) Usernames(ctx context.Context) (map[string]string, error) {
 	}
 	return vals, nil
 }
+
+func (s *RedisStore) SetPeerMeta(ctx context.Context, id string, meta []byte) error {
+	if len(meta) == 0 {
+		return s.rdb.HDel(ctx, s.keyPeerMeta, id).Err()
+	}
+	return s.rdb.HSet(ctx, s.keyPeerMeta, id, meta).Err()
+}
+
+func (s *RedisStore) PeerMeta(ctx context.Context) (map[string]json.RawMessage, error) {
+	vals, err := s.rdb.HGetAll(ctx, s.keyPeerMeta).Result()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]json.RawMessage, len(vals))
+	for id, raw := range vals {
+		out[id] = json.RawMessage(raw)
+	}
+	return out, nil
+}
@@ -0,0 +1,48 @@
+package usernames
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestStore(t *testing.T) *RedisStore {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return NewRedisStore(rdb, "test:room:abc")
+}
+
+func TestSetUsernamesBulk(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(t)
+	if err := s.SetUsername(ctx, "a", "Old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetUsernames(ctx, map[string]string{"a": "Alice", "b": "Bob", "c": "Carol"}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Usernames(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "Alice", "b": "Bob", "c": "Carol"}
+	if len(got) != len(want) {
+		t.Fatalf("usernames = %v, want %v", got, want)
+	}
+	for id, name := range want {
+		if got[id] != name {
+			t.Fatalf("usernames[%s] = %q, want %q", id, got[id], name)
+		}
+	}
+
+	if err := s.SetUsernames(ctx, nil); err != nil {
+		t.Fatalf("empty bulk set: %v", err)
+	}
+	if got, _ := s.Usernames(ctx); len(got) != 3 {
+		t.Fatalf("empty bulk set changed the names: %v", got)
+	}
+}
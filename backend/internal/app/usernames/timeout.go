@@ -0,0 +1,63 @@
+package usernames
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// WithTimeout wraps s so every call runs under a context bounded by d.
+// A non-positive d returns s unchanged.
+func WithTimeout(s Store, d time.Duration) Store {
+	if d <= 0 {
+		return s
+	}
+	return &timeoutStore{next: s, timeout: d}
+}
+
+type timeoutStore struct {
+	next    Store
+	timeout time.Duration
+}
+
+func (s *timeoutStore) Reset(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Reset(ctx)
+}
+
+func (s *timeoutStore) RemovePeer(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.RemovePeer(ctx, id)
+}
+
+func (s *timeoutStore) SetUsername(ctx context.Context, id string, username string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.SetUsername(ctx, id, username)
+}
+
+func (s *timeoutStore) Usernames(ctx context.Context) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Usernames(ctx)
+}
+
+func (s *timeoutStore) SetUsernames(ctx context.Context, names map[string]string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.SetUsernames(ctx, names)
+}
+
+func (s *timeoutStore) SetPeerMeta(ctx context.Context, id string, meta []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.SetPeerMeta(ctx, id, meta)
+}
+
+func (s *timeoutStore) PeerMeta(ctx context.Context) (map[string]json.RawMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.PeerMeta(ctx)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// hubLeaseTTL bounds how long a crashed instance keeps counting as a room's owner.
+const hubLeaseTTL = 30 * time.Second
+
+// Each instance holding a hub for a room keeps a short-lived lease: a member of the
+// room's lease set ("{prefix}:room:{code}:hubs", a sorted set scored by expiry in
+// Unix milliseconds). A new hub only resets the room's shared
+// presence/broadcast/username state when no other instance holds a live lease, so
+// scaling out never wipes a room that is live elsewhere.
+
+func (m *hubManager) leaseKey(code string) string {
+	return fmt.Sprintf("%s:room:%s:hubs", m.keyPrefix, code)
+}
+
+// soleOwner reports whether no other instance holds a live lease for code. Lookup
+// errors count as "not sole" so state is never reset on uncertainty.
+func (m *hubManager) soleOwner(ctx context.Context, code string) bool {
+	holders, err := m.rdb.ZRangeByScore(ctx, m.leaseKey(code), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		log.Printf("hub lease lookup for room %s: %v", code, err)
+		return false
+	}
+	for _, instance := range holders {
+		if instance != m.instanceID {
+			return false
+		}
+	}
+	return true
+}
+
+// queueLease adds this instance's lease for code to pipe, pruning expired ones.
+func (m *hubManager) queueLease(ctx context.Context, pipe redis.Pipeliner, code string) {
+	now := time.Now()
+	key := m.leaseKey(code)
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(hubLeaseTTL).UnixMilli()), Member: m.instanceID})
+	pipe.Expire(ctx, key, hubLeaseTTL)
+}
+
+func (m *hubManager) acquireLease(ctx context.Context, code string) {
+	pipe := m.rdb.TxPipeline()
+	m.queueLease(ctx, pipe, code)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("hub lease for room %s: %v", code, err)
+	}
+}
+
+func (m *hubManager) releaseLease(ctx context.Context, code string) {
+	if err := m.rdb.ZRem(ctx, m.leaseKey(code), m.instanceID).Err(); err != nil {
+		log.Printf("hub lease release for room %s: %v", code, err)
+	}
+}
+
+// refreshLeases extends this instance's leases until stop is closed.
+func (m *hubManager) refreshLeases(stop <-chan struct{}) {
+	ticker := time.NewTicker(hubLeaseTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		m.mu.Lock()
+		codes := make([]string, 0, len(m.hubs))
+		for code := range m.hubs {
+			codes = append(codes, code)
+		}
+		m.mu.Unlock()
+		if len(codes) == 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		pipe := m.rdb.Pipeline()
+		for _, code := range codes {
+			m.queueLease(ctx, pipe, code)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Printf("hub lease refresh (%d rooms): %v", len(codes), err)
+		}
+		cancel()
+	}
+}
+
+// dropLeases removes every lease this instance holds, e.g. on shutdown.
+func (m *hubManager) dropLeases(codes []string) {
+	if len(codes) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	pipe := m.rdb.Pipeline()
+	for _, code := range codes {
+		pipe.ZRem(ctx, m.leaseKey(code), m.instanceID)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("hub lease release (%s): %v", strings.Join(codes, ","), err)
+	}
+}
@@ -3,34 +3,45 @@ package main
 import (
 	"bufio"
 	"context"
+	"encoding/base64"
 	"errors"
-	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 
-	"videochat/internal/app/broadcast"
 	"videochat/internal/app/httpapi"
+	"videochat/internal/app/logstream"
 	"videochat/internal/app/rooms"
 	"videochat/internal/app/usernames"
-	"videochat/pkg/presence"
 	"videochat/pkg/webrtc/ice"
-	"videochat/pkg/webrtc/protocol"
-	"videochat/pkg/webrtc/signaling"
 )
 
 const defaultStaticPath = "../frontend/dist"
 
+// errDraining fails readiness while the instance is drained for decommissioning.
+var errDraining = errors.New("instance draining")
+
 func main() {
 	loadEnv()
 	cfg := loadConfig()
+	if err := validateConfig(cfg); err != nil {
+		log.Fatalf("invalid config: %v", err)
+	}
 	logConfig(cfg)
+	logs := logstream.New()
+	if cfg.AdminToken != "" {
+		log.SetOutput(io.MultiWriter(log.Writer(), logs))
+	}
 
 	rdb := redis.NewClient(&redis.Options{
 		Addr: cfg.RedisAddr,
@@ -43,54 +54,380 @@ func main() {
 		log.Fatalf("redis ping failed: %v", err)
 	}
 
-	roomStore := rooms.NewRedisStore(rdb, "webrtc")
-	hubs := newHubManager(rdb, roomStore, signaling.HubOptions{
-		ICEServers: cfg.ICEServers,
-		ICEMode:    cfg.ICEMode,
-	})
+	maintenance := httpapi.NewMaintenance(cfg.MaintenanceMode, cfg.MaintenancePage, cfg.MaintenanceRetryAfter)
+	limiter := httpapi.NewIPLimiter(cfg.MaxConnsPerIP)
+	identity := httpapi.NewIdentity(cfg.IdentitySecret)
+	drain := &httpapi.Drain{}
+
+	apps := make([]*app, 0, len(cfg.Apps))
+	rootMounted := false
+	for _, ac := range cfg.Apps {
+		a := newApp(rdb, cfg, ac)
+		apps = append(apps, a)
+		http.Handle(a.prefix+"/", a.handler(cfg, limiter, identity, drain))
+		rootMounted = rootMounted || a.prefix == ""
+	}
 
-	settings := httpapi.Settings{
-		ICEMode:     cfg.ICEMode,
-		ICEServers:  cfg.ICEServers,
-		PublicWSURL: cfg.PublicWSURL,
+	http.Handle("/healthz", httpapi.HealthHandler())
+	http.Handle("/readyz", httpapi.ReadyHandler(func(ctx context.Context) error {
+		if drain.Active() {
+			return errDraining
+		}
+		return rdb.Ping(ctx).Err()
+	}, cfg.ReadyLatencyThreshold))
+	http.Handle("/debug/stats", httpapi.RequireAdmin(cfg.AdminToken, httpapi.StatsHandler(appStats(apps), time.Now())))
+	http.Handle("/admin/maintenance", httpapi.RequireAdmin(cfg.AdminToken, httpapi.MaintenanceAdminHandler(maintenance)))
+	migrators := make([]httpapi.Migrator, 0, len(apps))
+	for _, a := range apps {
+		migrators = append(migrators, a.hubs)
+	}
+	http.Handle("/admin/migrate", httpapi.RequireAdmin(cfg.AdminToken, httpapi.MigrateAdminHandler(drain, cfg.MigrateURL, migrators)))
+	http.Handle("/admin/logs", httpapi.RequireAdmin(cfg.AdminToken, httpapi.AdminLogsHandler(logs)))
+	if !rootMounted {
+		http.Handle("/", httpapi.SecurityHeaders(cfg.ContentSecurityPolicy, httpapi.Settings{}, httpapi.SPAHandler(cfg.StaticPath, cfg.BrandingDir)))
 	}
 
-	http.Handle("/ws", httpapi.WSHandler(hubs, roomStore))
-	http.Handle("/api/settings", httpapi.SettingsHandler(settings))
-	http.Handle("/api/rooms", httpapi.CreateRoomHandler(roomStore))
-	http.Handle("/api/rooms/", httpapi.RoomLookupHandler(roomStore))
-	http.Handle("/debug/ice", httpapi.DebugICEHandler(settings))
-	http.Handle("/", httpapi.SPAHandler(cfg.StaticPath))
+	var handler http.Handler = httpapi.MaintenanceHandler(maintenance, cfg.AdminToken, http.DefaultServeMux)
+	if cfg.TrustProxy {
+		handler = httpapi.TrustProxy(handler)
+	}
+	srv := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: handler,
+		// Also bounds the request half of a WebSocket handshake.
+		ReadHeaderTimeout: cfg.HandshakeTimeout,
+	}
 
-	log.Printf("listening on %s (static: %s)", cfg.Addr, cfg.StaticPath)
-	if err := http.ListenAndServe(cfg.Addr, nil); err != nil {
-		log.Fatalf("server error: %v", err)
+	stop, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+
+	go func() {
+		log.Printf("listening on %s (static: %s)", cfg.Addr, cfg.StaticPath)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("server error: %v", err)
+		}
+	}()
+
+	<-stop.Done()
+	log.Printf("shutting down")
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
+	}
+	for _, a := range apps {
+		a.hubs.Close()
+		if a.events != nil {
+			a.events.Close()
+		}
+		a.relays.Close()
+	}
+	if err := rdb.Close(); err != nil {
+		log.Printf("redis close: %v", err)
 	}
 }
 
 type config struct {
-	Addr        string
-	RedisAddr   string
-	StaticPath  string
-	ICEServers  []protocol.ICEServer
-	ICEMode     string
-	PublicWSURL string
+	Addr       string
+	RedisAddr  string
+	StaticPath string
+	// BrandingDir overrides favicon.ico and manifest.webmanifest from StaticPath (empty = off).
+	BrandingDir string
+	// Region names this server's deployment region/edge, reported to clients.
+	Region string
+	// Apps lists the signaling namespaces served; a single unnamed app is mounted at the root by default.
+	Apps []appConfig
+	// MaxConnsPerIP caps concurrent WebSocket connections per client IP (0 = unlimited).
+	MaxConnsPerIP int
+	// MaxEventObservers caps concurrent /api/rooms/{code}/events streams per room (0 = unlimited).
+	MaxEventObservers int
+	// MaxRoomsPerOwner caps the rooms one identity may hold at once (0 = unlimited).
+	MaxRoomsPerOwner int
+	// StoreTimeout bounds every Redis store call (0 = no bound beyond the caller's context).
+	StoreTimeout time.Duration
+	// RoomCodeAlphabet/RoomCodeLength shape generated room codes (empty = base64url, 8 chars).
+	RoomCodeAlphabet string
+	RoomCodeLength   int
+	// RoomCodeLegacy keeps default-format codes valid under a custom alphabet.
+	RoomCodeLegacy bool
+	// RoomCloseGrace keeps idle rooms readable/joinable (status "closing") before removal (0 = delete immediately).
+	RoomCloseGrace time.Duration
+	// LeaveGrace delays peer-left for stable-ID peers so quick reconnects don't churn the room.
+	LeaveGrace time.Duration
+	// EmptyGrace is how long a room must stay empty before its cleanup is scheduled.
+	EmptyGrace time.Duration
+	// MaxConnLifetime closes WebSocket connections after this long with a reconnect hint (0 = unlimited).
+	MaxConnLifetime time.Duration
+	// MaxFramesPerConn caps inbound frames over one connection's lifetime (0 = unlimited).
+	MaxFramesPerConn int
+	// PongTimeout closes connections whose ping goes unanswered this long (0 = disabled).
+	PongTimeout time.Duration
+	// WriteTimeout bounds each outbound WebSocket write (0 = 10s).
+	WriteTimeout time.Duration
+	// ControlWrite/SignalWrite override WriteTimeout for control frames (pings,
+	// presence) and relayed signaling respectively (0 = WriteTimeout).
+	ControlWrite time.Duration
+	SignalWrite  time.Duration
+	// SlowWrite/MaxSlowWrites close clients whose writes keep taking this long (0 = off).
+	SlowWrite     time.Duration
+	MaxSlowWrites int
+	// DebugEndpoints serves /debug/ice (off by default; it exposes TURN credentials).
+	DebugEndpoints bool
+	// RoomListScanCount is the SCAN COUNT hint used when listing rooms (0 = 100).
+	RoomListScanCount int
+	// PresenceSweepInterval is how often rooms' presence sets are checked for ghost peers (0 = never).
+	PresenceSweepInterval time.Duration
+	// TURNHealthInterval is how often each TURN URL is probed so dead relays are not
+	// advertised (0 = never); TURNHealthTimeout bounds each probe.
+	TURNHealthInterval time.Duration
+	TURNHealthTimeout  time.Duration
+	// MaxPeerIDLength caps caller-chosen peer IDs such as identity cookies (0 = 64).
+	MaxPeerIDLength int
+	// MaxMessageSize caps a reassembled inbound WebSocket message in bytes (0 = 64 KiB).
+	MaxMessageSize int
+	// MaxJSONDepth/MaxJSONToken bound inbound frame nesting and key/number length (0 = defaults).
+	MaxJSONDepth int
+	MaxJSONToken int
+	// RoomClosingWarnings are the remaining-time points at which peers of a room with
+	// a TTL are warned before it closes.
+	RoomClosingWarnings []time.Duration
+	// AuditLogSize caps each room's admin audit log (0 = auditing off); AuditLogTTL
+	// expires a room's log after its last entry.
+	AuditLogSize int
+	AuditLogTTL  time.Duration
+	// AnalyticsStreamLen turns on forwarding room events to a Redis Stream trimmed to
+	// about this many entries (0 = off); AnalyticsBuffer is the in-memory queue in front.
+	AnalyticsStreamLen int
+	AnalyticsBuffer    int
+	// HandshakeTimeout bounds reading request headers and completing WebSocket upgrades (0 = no limit).
+	HandshakeTimeout time.Duration
+	// CompressAbove compresses outbound frames of at least this many bytes for clients
+	// that negotiated permessage-deflate (0 = off).
+	CompressAbove int
+	// DuplicateSessions is "reject", "replace" or "allow" for a browser joining a room twice.
+	DuplicateSessions string
+	// ReadyTimeout holds back peer-joined until the joiner sends "ready", at most this long (0 = off).
+	ReadyTimeout time.Duration
+	// UsernameKey AES-GCM encrypts display names stored in Redis (nil = plaintext).
+	UsernameKey []byte
+	// GuestPrefix names peers that join without a username, e.g. "Guest" (empty = off).
+	GuestPrefix string
+	// MaxInboundRate caps inbound WebSocket frames per second across each app (0 = unlimited).
+	MaxInboundRate int
+	// MaxBroadcasters caps simultaneous broadcasters per room (0 = unlimited).
+	MaxBroadcasters int
+	// MaxRoomPeers caps the peers in each room (0 = unlimited).
+	MaxRoomPeers int
+	// ElectRelay turns on relay-peer election metadata for every room.
+	ElectRelay bool
+	// DeferRoster sends a minimal welcome first and the roster in a follow-up message.
+	DeferRoster bool
+	// DebugLogPayloads logs redacted, truncated signaling payloads.
+	DebugLogPayloads bool
+	// AutoBroadcastOff clears a peer's broadcast flag once it reports audio and video off.
+	AutoBroadcastOff bool
+	// UsernameRetention keeps a departed peer's display name for a same-ID reconnect.
+	UsernameRetention time.Duration
+	// ChatHistorySize/ChatHistoryTTL persist recent chat per room for replay (size 0 disables).
+	ChatHistorySize int
+	ChatHistoryTTL  time.Duration
+	// RenameCooldown is the minimum interval between set-username changes per peer (0 = unlimited).
+	RenameCooldown time.Duration
+	// StrictProtocol rejects inbound frames with unknown fields (development aid).
+	StrictProtocol bool
+	// IdentitySecret signs the anonymous peer_id cookie (empty disables stable identities).
+	IdentitySecret string
+	// AdminToken guards /admin endpoints as a bearer token (empty disables them).
+	AdminToken string
+	// MigrateURL is the default WebSocket URL peers are sent to by /admin/migrate.
+	MigrateURL string
+	// ContentSecurityPolicy overrides the CSP sent with SPA responses (empty = derived default).
+	ContentSecurityPolicy string
+	// TrustProxy honors X-Forwarded-Host / Forwarded when building public URLs.
+	TrustProxy bool
+	// BlockedNets refuses /ws upgrades from client IPs inside these networks with 403.
+	BlockedNets []netip.Prefix
+	// ReadyLatencyThreshold marks /readyz "degraded" when a Redis ping is slower (0 = never).
+	ReadyLatencyThreshold time.Duration
+	// MaintenanceMode starts the server serving the maintenance page instead of the SPA.
+	MaintenanceMode       bool
+	MaintenancePage       string
+	MaintenanceRetryAfter time.Duration
 }
 
 func loadConfig() config {
 	addr := getenv("ADDR", ":8080")
 	redisAddr := getenv("REDIS_ADDR", "localhost:6379")
 	staticDir := getenv("STATIC_DIR", defaultStaticPath)
-	publicWS := strings.TrimSpace(os.Getenv("WS_PUBLIC_URL"))
-	iceMode, iceServers := ice.LoadFromEnv()
 	return config{
-		Addr:        addr,
-		RedisAddr:   redisAddr,
-		StaticPath:  staticDir,
-		ICEServers:  iceServers,
-		ICEMode:     iceMode,
-		PublicWSURL: publicWS,
+		Addr:                  addr,
+		RedisAddr:             redisAddr,
+		StaticPath:            staticDir,
+		BrandingDir:           strings.TrimSpace(os.Getenv("BRANDING_DIR")),
+		Region:                strings.TrimSpace(os.Getenv("REGION")),
+		Apps:                  loadApps(),
+		MaxConnsPerIP:         getenvInt("MAX_CONNS_PER_IP", 0),
+		MaxEventObservers:     getenvInt("MAX_EVENT_OBSERVERS", 10),
+		MaxRoomsPerOwner:      getenvInt("MAX_ROOMS_PER_OWNER", 0),
+		StoreTimeout:          getenvDuration("STORE_TIMEOUT", 0),
+		RoomCloseGrace:        getenvDuration("ROOM_CLOSE_GRACE", 0),
+		RoomCodeAlphabet:      loadCodeAlphabet(),
+		RoomCodeLength:        getenvInt("ROOM_CODE_LENGTH", 0),
+		RoomCodeLegacy:        getenvBool("ROOM_CODE_ACCEPT_LEGACY", false),
+		ElectRelay:            getenvBool("RELAY_ELECTION", false),
+		MaxBroadcasters:       getenvInt("MAX_BROADCASTERS", 0),
+		MaxRoomPeers:          getenvInt("MAX_ROOM_PEERS", 0),
+		MaxInboundRate:        getenvInt("MAX_INBOUND_RATE", 0),
+		MaxFramesPerConn:      getenvInt("MAX_FRAMES_PER_CONN", 0),
+		MaxConnLifetime:       getenvDuration("MAX_CONN_LIFETIME", 0),
+		LeaveGrace:            getenvDuration("PEER_LEAVE_GRACE", 0),
+		EmptyGrace:            getenvDuration("ROOM_EMPTY_GRACE", 0),
+		PongTimeout:           getenvDuration("PONG_TIMEOUT", 0),
+		WriteTimeout:          getenvDuration("WRITE_TIMEOUT", 0),
+		ControlWrite:          getenvDuration("CONTROL_WRITE_TIMEOUT", 0),
+		SignalWrite:           getenvDuration("SIGNAL_WRITE_TIMEOUT", 0),
+		SlowWrite:             getenvDuration("SLOW_WRITE_THRESHOLD", 0),
+		MaxSlowWrites:         getenvInt("MAX_SLOW_WRITES", 0),
+		MaxPeerIDLength:       getenvInt("MAX_PEER_ID_LENGTH", 0),
+		PresenceSweepInterval: getenvDuration("PRESENCE_SWEEP_INTERVAL", 0),
+		TURNHealthInterval:    getenvDuration("TURN_HEALTH_INTERVAL", 0),
+		TURNHealthTimeout:     getenvDuration("TURN_HEALTH_TIMEOUT", 3*time.Second),
+		RoomListScanCount:     getenvInt("ROOM_LIST_SCAN_COUNT", 0),
+		DebugEndpoints:        getenvBool("DEBUG_ENDPOINTS", false),
+		MaxMessageSize:        getenvInt("MAX_MESSAGE_SIZE", 0),
+		MaxJSONDepth:          getenvInt("MAX_JSON_DEPTH", 0),
+		MaxJSONToken:          getenvInt("MAX_JSON_TOKEN", 0),
+		RoomClosingWarnings:   getenvDurations("ROOM_CLOSING_WARNINGS", []time.Duration{5 * time.Minute, time.Minute, 10 * time.Second}),
+		AuditLogSize:          getenvInt("AUDIT_LOG_SIZE", 200),
+		AuditLogTTL:           getenvDuration("AUDIT_LOG_TTL", 30*24*time.Hour),
+		AnalyticsStreamLen:    getenvInt("ANALYTICS_STREAM_LEN", 0),
+		AnalyticsBuffer:       getenvInt("ANALYTICS_BUFFER", 0),
+		HandshakeTimeout:      getenvDuration("HANDSHAKE_TIMEOUT", 10*time.Second),
+		CompressAbove:         getenvInt("COMPRESS_ABOVE", 0),
+		DuplicateSessions:     strings.ToLower(strings.TrimSpace(os.Getenv("DUPLICATE_SESSIONS"))),
+		ReadyTimeout:          getenvDuration("READY_TIMEOUT", 0),
+		UsernameKey:           loadUsernameKey(),
+		GuestPrefix:           strings.TrimSpace(os.Getenv("GUEST_USERNAME_PREFIX")),
+		AdminToken:            strings.TrimSpace(os.Getenv("ADMIN_TOKEN")),
+		MigrateURL:            strings.TrimSpace(os.Getenv("MIGRATE_WS_URL")),
+		IdentitySecret:        strings.TrimSpace(os.Getenv("IDENTITY_SECRET")),
+		DebugLogPayloads:      getenvBool("DEBUG_LOG_PAYLOADS", false),
+		DeferRoster:           getenvBool("DEFER_ROSTER", false),
+		StrictProtocol:        getenvBool("STRICT_PROTOCOL", false),
+		RenameCooldown:        getenvDuration("RENAME_COOLDOWN", 0),
+		ChatHistorySize:       getenvInt("CHAT_HISTORY_SIZE", 0),
+		ChatHistoryTTL:        getenvDuration("CHAT_HISTORY_TTL", 24*time.Hour),
+		UsernameRetention:     getenvDuration("USERNAME_RETENTION", 2*time.Minute),
+		AutoBroadcastOff:      getenvBool("AUTO_BROADCAST_OFF", false),
+		TrustProxy:            getenvBool("TRUST_PROXY", false),
+		BlockedNets:           loadBlockedNets(),
+		ContentSecurityPolicy: strings.TrimSpace(os.Getenv("CONTENT_SECURITY_POLICY")),
+		ReadyLatencyThreshold: getenvDuration("READY_LATENCY_THRESHOLD", 100*time.Millisecond),
+		MaintenanceMode:       getenvBool("MAINTENANCE_MODE", false),
+		MaintenancePage:       strings.TrimSpace(os.Getenv("MAINTENANCE_PAGE")),
+		MaintenanceRetryAfter: getenvDuration("MAINTENANCE_RETRY_AFTER", 5*time.Minute),
+	}
+}
+
+// loadApps reads APPS (comma-separated names). Each named app is served under "/{name}"
+// and may override ICE/WS settings with "{NAME}_"-prefixed env vars, e.g. APP1_TURN_URLS.
+func loadApps() []appConfig {
+	names := strings.TrimSpace(os.Getenv("APPS"))
+	if names == "" {
+		iceMode, iceServers := ice.LoadFromEnv()
+		return []appConfig{{
+			ICEMode:     iceMode,
+			ICEServers:  iceServers,
+			PublicWSURL: strings.TrimSpace(os.Getenv("WS_PUBLIC_URL")),
+		}}
+	}
+
+	var apps []appConfig
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if !validAppName(name) {
+			log.Fatalf("invalid app name %q in APPS (use letters, digits and dashes)", name)
+		}
+		seen[name] = true
+		envPrefix := strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		iceMode, iceServers := ice.LoadFromEnvPrefix(envPrefix)
+		publicWS := strings.TrimSpace(os.Getenv(envPrefix + "WS_PUBLIC_URL"))
+		apps = append(apps, appConfig{
+			Name:        name,
+			ICEMode:     iceMode,
+			ICEServers:  iceServers,
+			PublicWSURL: publicWS,
+		})
+	}
+	return apps
+}
+
+// loadCodeAlphabet reads ROOM_CODE_ALPHABET; "crockford" selects rooms.CrockfordAlphabet.
+func loadCodeAlphabet() string {
+	v := strings.TrimSpace(os.Getenv("ROOM_CODE_ALPHABET"))
+	if strings.EqualFold(v, "crockford") {
+		return rooms.CrockfordAlphabet
+	}
+	return v
+}
+
+// loadUsernameKey reads USERNAME_ENC_KEY, a base64-encoded 16-, 24- or 32-byte AES key.
+func loadUsernameKey() []byte {
+	v := strings.TrimSpace(os.Getenv("USERNAME_ENC_KEY"))
+	if v == "" {
+		return nil
 	}
+	key, err := base64.StdEncoding.DecodeString(v)
+	if err != nil {
+		log.Fatalf("invalid USERNAME_ENC_KEY: not base64: %v", err)
+	}
+	if _, err := usernames.WithEncryption(nil, key, ""); err != nil {
+		log.Fatalf("invalid USERNAME_ENC_KEY: %v", err)
+	}
+	return key
+}
+
+// loadBlockedNets reads BLOCKED_CIDRS, a comma-separated list of networks such as
+// "203.0.113.0/24,2001:db8::/32"; a bare address blocks just that IP.
+func loadBlockedNets() []netip.Prefix {
+	var nets []netip.Prefix
+	for _, part := range strings.Split(os.Getenv("BLOCKED_CIDRS"), ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if !strings.Contains(part, "/") {
+			addr, err := netip.ParseAddr(part)
+			if err != nil {
+				log.Fatalf("invalid BLOCKED_CIDRS entry %q: %v", part, err)
+			}
+			nets = append(nets, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(part)
+		if err != nil {
+			log.Fatalf("invalid BLOCKED_CIDRS entry %q: %v", part, err)
+		}
+		nets = append(nets, prefix.Masked())
+	}
+	return nets
+}
+
+func validAppName(name string) bool {
+	if name == "api" || name == "admin" || name == "debug" || name == "healthz" || name == "readyz" || name == "ws" || name == "rooms" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
 }
 
 func getenv(key, fallback string) string {
@@ -101,6 +438,68 @@ func getenv(key, fallback string) string {
 	return v
 }
 
+func getenvInt(key string, fallback int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %d", key, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+func getenvBool(key string, fallback bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %v", key, v, fallback)
+		return fallback
+	}
+	return b
+}
+
+func getenvDuration(key string, fallback time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %s", key, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+// getenvDurations reads a comma-separated list of Go durations; invalid or
+// non-positive entries are skipped with a warning.
+func getenvDurations(key string, fallback []time.Duration) []time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return fallback
+	}
+	var out []time.Duration
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			log.Printf("invalid %s entry %q, skipping", key, part)
+			continue
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
 func loadEnv() {
 	paths := []string{
 		".env",
@@ -112,19 +511,29 @@ func loadEnv() {
 			log.Printf("env load warning for %s: %v", p, err)
 		}
 	}
+	if path := strings.TrimSpace(os.Getenv("CONFIG_FILE")); path != "" {
+		if err := loadConfigFile(path); err != nil {
+			log.Fatalf("config file: %v", err)
+		}
+	}
 }
 
 func logConfig(cfg config) {
-	turnConfigured := false
-	for _, s := range cfg.ICEServers {
-		if s.Username != "" || s.Credential != "" {
-			turnConfigured = true
-			break
+	log.Printf("config: addr=%s static_dir=%s redis_addr=%s apps=%d max_conns_per_ip=%d store_timeout=%s relay_election=%v admin_enabled=%v maintenance=%v trust_proxy=%v region=%q",
+		cfg.Addr, cfg.StaticPath, cfg.RedisAddr, len(cfg.Apps), cfg.MaxConnsPerIP, cfg.StoreTimeout, cfg.ElectRelay,
+		cfg.AdminToken != "", cfg.MaintenanceMode, cfg.TrustProxy, cfg.Region)
+
+	for _, a := range cfg.Apps {
+		turnConfigured := false
+		for _, s := range a.ICEServers {
+			if s.Username != "" || s.Credential != "" {
+				turnConfigured = true
+				break
+			}
 		}
+		log.Printf("config: app=%q ice_mode=%s ice_servers=%d turn_configured=%v ws_public_url=%s",
+			a.Name, a.ICEMode, len(a.ICEServers), turnConfigured, a.PublicWSURL)
 	}
-
-	log.Printf("config: addr=%s static_dir=%s redis_addr=%s ice_mode=%s ice_servers=%d turn_configured=%v ws_public_url=%s",
-		cfg.Addr, cfg.StaticPath, cfg.RedisAddr, cfg.ICEMode, len(cfg.ICEServers), turnConfigured, cfg.PublicWSURL)
 }
 
 func loadEnvFile(path string) error {
@@ -155,129 +564,3 @@ func loadEnvFile(path string) error {
 	}
 	return scanner.Err()
 }
-
-// hubManager keeps one signaling Hub per room, each with isolated Redis keys.
-type hubEntry struct {
-	hub   *signaling.Hub
-	timer *time.Timer
-	store presence.Store
-	bcast broadcast.Store
-	names usernames.Store
-}
-
-type hubManager struct {
-	mu        sync.Mutex
-	hubs      map[string]*hubEntry
-	rdb       *redis.Client
-	opts      signaling.HubOptions
-	roomStore rooms.Store
-}
-
-func newHubManager(rdb *redis.Client, roomStore rooms.Store, opts signaling.HubOptions) *hubManager {
-	return &hubManager{
-		hubs:      make(map[string]*hubEntry),
-		rdb:       rdb,
-		opts:      opts,
-		roomStore: roomStore,
-	}
-}
-
-func (m *hubManager) HubForRoom(code string) httpapi.Hub {
-	return m.hubForRoom(code)
-}
-
-func (m *hubManager) hubForRoom(code string) *signaling.Hub {
-	code = strings.TrimSpace(code)
-	if code == "" {
-		return nil
-	}
-
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	if h := m.hubs[code]; h != nil {
-		if h.timer != nil {
-			h.timer.Stop()
-			h.timer = nil
-		}
-		return h.hub
-	}
-
-	presenceStore := presence.NewRedisStore(m.rdb, fmt.Sprintf("webrtc:room:%s", code))
-	bcastStore := broadcast.NewRedisStore(m.rdb, fmt.Sprintf("webrtc:room:%s", code))
-	namesStore := usernames.NewRedisStore(m.rdb, fmt.Sprintf("webrtc:room:%s", code))
-	if err := presenceStore.Reset(context.Background()); err != nil {
-		log.Printf("presence reset for room %s: %v", code, err)
-	}
-	if err := bcastStore.Reset(context.Background()); err != nil {
-		log.Printf("broadcast reset for room %s: %v", code, err)
-	}
-	if err := namesStore.Reset(context.Background()); err != nil {
-		log.Printf("usernames reset for room %s: %v", code, err)
-	}
-
-	opts := m.opts
-	opts.OnEmpty = func() {
-		m.scheduleCleanup(code, presenceStore, bcastStore, namesStore)
-	}
-	opts.Broadcasts = bcastStore
-	opts.Usernames = namesStore
-
-	hub := signaling.NewHub(presenceStore, opts)
-	m.hubs[code] = &hubEntry{hub: hub, store: presenceStore, bcast: bcastStore, names: namesStore}
-	return hub
-}
-
-func (m *hubManager) scheduleCleanup(code string, store presence.Store, bcast broadcast.Store, names usernames.Store) {
-	m.mu.Lock()
-	entry := m.hubs[code]
-	if entry == nil {
-		m.mu.Unlock()
-		return
-	}
-	if entry.timer != nil {
-		m.mu.Unlock()
-		return
-	}
-
-	entry.timer = time.AfterFunc(30*time.Second, func() {
-		m.cleanupRoom(code, store, bcast, names)
-	})
-	m.mu.Unlock()
-}
-
-func (m *hubManager) cleanupRoom(code string, store presence.Store, bcast broadcast.Store, names usernames.Store) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	peers, err := store.Peers(ctx)
-	if err != nil {
-		log.Printf("cleanup state error for room %s: %v", code, err)
-	}
-	if len(peers) > 0 {
-		m.mu.Lock()
-		if entry, ok := m.hubs[code]; ok {
-			entry.timer = nil
-		}
-		m.mu.Unlock()
-		return
-	}
-
-	if err := store.Reset(ctx); err != nil {
-		log.Printf("cleanup presence reset failed for room %s: %v", code, err)
-	}
-	if err := bcast.Reset(ctx); err != nil {
-		log.Printf("cleanup broadcast reset failed for room %s: %v", code, err)
-	}
-	if err := names.Reset(ctx); err != nil {
-		log.Printf("cleanup usernames reset failed for room %s: %v", code, err)
-	}
-	if err := m.roomStore.Delete(ctx, code); err != nil && !errors.Is(err, rooms.ErrNotFound) {
-		log.Printf("cleanup room delete failed for room %s: %v", code, err)
-	}
-
-	m.mu.Lock()
-	delete(m.hubs, code)
-	m.mu.Unlock()
-	log.Printf("room %s cleaned up after inactivity", code)
-}
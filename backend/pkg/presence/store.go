@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/redis/go-redis/v9"
 )
@@ -16,10 +17,20 @@ type Store interface {
 	Peers(ctx context.Context) ([]string, error)
 }
 
-// RedisStore implements Store using a Redis set.
+// OrderedStore is a Store that also remembers join order, so every instance
+// serving a room agrees on who has been there longest.
+type OrderedStore interface {
+	Store
+	// Oldest returns the longest-present peer, or "" when the room is empty.
+	Oldest(ctx context.Context) (string, error)
+}
+
+// RedisStore implements OrderedStore using a Redis set, with join times in a
+// sorted set alongside it.
 type RedisStore struct {
 	rdb      *redis.Client
 	keyPeers string
+	keyOrder string
 }
 
 // NewRedisStore builds a presence store backed by Redis. Prefix is optional (e.g., "webrtc:room:abc123").
@@ -31,19 +42,44 @@ func NewRedisStore(rdb *redis.Client, prefix string) *RedisStore {
 	return &RedisStore{
 		rdb:      rdb,
 		keyPeers: fmt.Sprintf("%s:peers", p),
+		keyOrder: fmt.Sprintf("%s:joined", p),
 	}
 }
 
+// Keys lists the Redis keys the store writes, e.g. for moving them with a renamed room.
+func (s *RedisStore) Keys() []string {
+	return []string{s.keyPeers, s.keyOrder}
+}
+
 func (s *RedisStore) Reset(ctx context.Context) error {
-	return s.rdb.Del(ctx, s.keyPeers).Err()
+	return s.rdb.Del(ctx, s.keyPeers, s.keyOrder).Err()
 }
 
 func (s *RedisStore) AddPeer(ctx context.Context, id string) error {
-	return s.rdb.SAdd(ctx, s.keyPeers, id).Err()
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, s.keyPeers, id)
+		// NX keeps the first join time across reconnects.
+		pipe.ZAddNX(ctx, s.keyOrder, redis.Z{Score: float64(time.Now().UnixMilli()), Member: id})
+		return nil
+	})
+	return err
 }
 
 func (s *RedisStore) RemovePeer(ctx context.Context, id string) error {
-	return s.rdb.SRem(ctx, s.keyPeers, id).Err()
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SRem(ctx, s.keyPeers, id)
+		pipe.ZRem(ctx, s.keyOrder, id)
+		return nil
+	})
+	return err
+}
+
+func (s *RedisStore) Oldest(ctx context.Context) (string, error) {
+	ids, err := s.rdb.ZRange(ctx, s.keyOrder, 0, 0).Result()
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	return ids[0], nil
 }
 
 func (s *RedisStore) Peers(ctx context.Context) ([]string, error) {
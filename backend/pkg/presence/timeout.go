@@ -0,0 +1,58 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// errUnordered is returned by Oldest when the wrapped store does not track join order.
+var errUnordered = errors.New("presence: store does not track join order")
+
+// WithTimeout wraps s so every call runs under a context bounded by d.
+// A non-positive d returns s unchanged.
+func WithTimeout(s Store, d time.Duration) Store {
+	if d <= 0 {
+		return s
+	}
+	return &timeoutStore{next: s, timeout: d}
+}
+
+type timeoutStore struct {
+	next    Store
+	timeout time.Duration
+}
+
+func (s *timeoutStore) Reset(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Reset(ctx)
+}
+
+func (s *timeoutStore) AddPeer(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.AddPeer(ctx, id)
+}
+
+func (s *timeoutStore) RemovePeer(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.RemovePeer(ctx, id)
+}
+
+func (s *timeoutStore) Peers(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return s.next.Peers(ctx)
+}
+
+func (s *timeoutStore) Oldest(ctx context.Context) (string, error) {
+	ordered, ok := s.next.(OrderedStore)
+	if !ok {
+		return "", errUnordered
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	return ordered.Oldest(ctx)
+}
@@ -0,0 +1,319 @@
+package ice
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"videochat/pkg/webrtc/protocol"
+)
+
+// HealthChecker periodically probes every TURN URL in a server list and reports
+// the list with unreachable relays left out, so clients are not handed a dead
+// TURN server. URLs count as healthy until their first probe fails.
+type HealthChecker struct {
+	servers []protocol.ICEServer
+	timeout time.Duration
+	// probe checks one TURN URL; probeTURN unless replaced.
+	probe func(ctx context.Context, url string) error
+
+	mu        sync.RWMutex
+	unhealthy map[string]bool
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewHealthChecker starts probing the TURN URLs of servers every interval, giving
+// each probe up to timeout (default 3s). It returns nil when interval is not
+// positive or servers list no TURN URLs; a nil checker advertises servers as-is.
+func NewHealthChecker(servers []protocol.ICEServer, interval, timeout time.Duration) *HealthChecker {
+	if interval <= 0 || len(turnURLs(servers)) == 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
+	hc := &HealthChecker{
+		servers:   servers,
+		timeout:   timeout,
+		probe:     probeTURN,
+		unhealthy: make(map[string]bool),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go hc.run(interval)
+	return hc
+}
+
+// Close stops the background probes.
+func (hc *HealthChecker) Close() {
+	if hc == nil {
+		return
+	}
+	hc.once.Do(func() { close(hc.stop) })
+	<-hc.done
+}
+
+// Healthy reports whether url answered its latest probe.
+func (hc *HealthChecker) Healthy(url string) bool {
+	if hc == nil {
+		return true
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	return !hc.unhealthy[url]
+}
+
+// Servers returns the configured servers without the TURN URLs that failed their
+// latest probe; an entry left with no URLs is dropped. When every TURN URL is
+// down the full list is returned, since a relay that might work beats none.
+func (hc *HealthChecker) Servers() []protocol.ICEServer {
+	if hc == nil {
+		return nil
+	}
+	hc.mu.RLock()
+	defer hc.mu.RUnlock()
+	if len(hc.unhealthy) == 0 {
+		return hc.servers
+	}
+
+	out := make([]protocol.ICEServer, 0, len(hc.servers))
+	relays := 0
+	for _, s := range hc.servers {
+		var urls []string
+		for _, u := range s.URLs {
+			if isTURN(u) {
+				if hc.unhealthy[u] {
+					continue
+				}
+				relays++
+			}
+			urls = append(urls, u)
+		}
+		if len(urls) == 0 {
+			continue
+		}
+		s.URLs = urls
+		out = append(out, s)
+	}
+	if relays == 0 {
+		return hc.servers
+	}
+	return out
+}
+
+func (hc *HealthChecker) run(interval time.Duration) {
+	defer close(hc.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		hc.checkAll()
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (hc *HealthChecker) checkAll() {
+	urls := turnURLs(hc.servers)
+	results := make([]error, len(urls))
+	var wg sync.WaitGroup
+	for i, u := range urls {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), hc.timeout)
+			defer cancel()
+			results[i] = hc.probe(ctx, u)
+		}(i, u)
+	}
+	wg.Wait()
+
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for i, u := range urls {
+		down := results[i] != nil
+		if down != hc.unhealthy[u] {
+			if down {
+				log.Printf("ice: TURN %s unhealthy: %v", u, results[i])
+			} else {
+				log.Printf("ice: TURN %s healthy again", u)
+			}
+		}
+		if down {
+			hc.unhealthy[u] = true
+		} else {
+			delete(hc.unhealthy, u)
+		}
+	}
+}
+
+// turnURLs lists the distinct TURN URLs across servers.
+func turnURLs(servers []protocol.ICEServer) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range servers {
+		for _, u := range s.URLs {
+			if isTURN(u) && !seen[u] {
+				seen[u] = true
+				out = append(out, u)
+			}
+		}
+	}
+	return out
+}
+
+func isTURN(url string) bool {
+	lower := strings.ToLower(url)
+	return strings.HasPrefix(lower, "turn:") || strings.HasPrefix(lower, "turns:")
+}
+
+// STUN message constants used by the Allocate probe (RFC 5389, RFC 5766).
+const (
+	stunMagicCookie        = 0x2112A442
+	stunAllocateRequest    = 0x0003
+	stunAllocateSuccess    = 0x0103
+	stunAllocateError      = 0x0113
+	stunRequestedTransport = 0x0019
+	stunHeaderLen          = 20
+	protocolUDP            = 17
+	udpRetransmitInterval  = 500 * time.Millisecond
+)
+
+// probeTURN sends an unauthenticated Allocate request to url and succeeds on any
+// Allocate response. The server normally answers 401 (credentials required),
+// which is enough to show it is up and speaking TURN without holding a relay.
+func probeTURN(ctx context.Context, url string) error {
+	network, addr, secure, err := parseTURNURL(url)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	var conn net.Conn
+	if secure {
+		conn, err = (&tls.Dialer{NetDialer: &d}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = d.DialContext(ctx, network, addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req, txID, err := allocateRequest()
+	if err != nil {
+		return err
+	}
+	deadline, _ := ctx.Deadline()
+	buf := make([]byte, 1500)
+	for {
+		if _, err := conn.Write(req); err != nil {
+			return err
+		}
+		// UDP may lose either datagram, so resend until the deadline; streams wait it out.
+		readBy := deadline
+		if network == "udp" {
+			if next := time.Now().Add(udpRetransmitInterval); next.Before(deadline) {
+				readBy = next
+			}
+		}
+		_ = conn.SetReadDeadline(readBy)
+		n, err := readSTUN(conn, buf, network == "udp")
+		if err == nil {
+			return checkAllocateResponse(buf[:n], txID)
+		}
+		var ne net.Error
+		if network != "udp" || !errors.As(err, &ne) || !ne.Timeout() || !time.Now().Before(deadline) {
+			return err
+		}
+	}
+}
+
+// parseTURNURL turns "turn:host[:port][?transport=udp|tcp]" or "turns:..." into a
+// dial network and address.
+func parseTURNURL(url string) (network, addr string, secure bool, err error) {
+	scheme, rest, ok := strings.Cut(url, ":")
+	if !ok {
+		return "", "", false, fmt.Errorf("ice: malformed TURN URL %q", url)
+	}
+	secure = strings.EqualFold(scheme, "turns")
+	network, port := "udp", "3478"
+	if secure {
+		network, port = "tcp", "5349"
+	}
+	hostport, query, _ := strings.Cut(rest, "?")
+	for _, kv := range strings.Split(query, "&") {
+		if v, ok := strings.CutPrefix(strings.ToLower(kv), "transport="); ok && v == "tcp" {
+			network = "tcp"
+		}
+	}
+	if hostport == "" {
+		return "", "", false, fmt.Errorf("ice: TURN URL %q has no host", url)
+	}
+	if _, _, splitErr := net.SplitHostPort(hostport); splitErr != nil {
+		hostport = net.JoinHostPort(strings.Trim(hostport, "[]"), port)
+	}
+	return network, hostport, secure, nil
+}
+
+// allocateRequest builds an Allocate request asking for a UDP relay.
+func allocateRequest() (msg, txID []byte, err error) {
+	msg = make([]byte, stunHeaderLen+8)
+	binary.BigEndian.PutUint16(msg[0:], stunAllocateRequest)
+	binary.BigEndian.PutUint16(msg[2:], 8)
+	binary.BigEndian.PutUint32(msg[4:], stunMagicCookie)
+	if _, err := rand.Read(msg[8:stunHeaderLen]); err != nil {
+		return nil, nil, err
+	}
+	binary.BigEndian.PutUint16(msg[20:], stunRequestedTransport)
+	binary.BigEndian.PutUint16(msg[22:], 4)
+	msg[24] = protocolUDP
+	return msg, msg[8:stunHeaderLen], nil
+}
+
+// readSTUN reads one STUN message: a single datagram, or header plus body on a stream.
+func readSTUN(conn net.Conn, buf []byte, datagram bool) (int, error) {
+	if datagram {
+		return conn.Read(buf)
+	}
+	if _, err := io.ReadFull(conn, buf[:stunHeaderLen]); err != nil {
+		return 0, err
+	}
+	size := stunHeaderLen + int(binary.BigEndian.Uint16(buf[2:4]))
+	if size > len(buf) {
+		return 0, fmt.Errorf("ice: STUN response too large (%d bytes)", size)
+	}
+	if _, err := io.ReadFull(conn, buf[stunHeaderLen:size]); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func checkAllocateResponse(msg, txID []byte) error {
+	if len(msg) < stunHeaderLen || binary.BigEndian.Uint32(msg[4:8]) != stunMagicCookie {
+		return errors.New("ice: reply is not a STUN message")
+	}
+	if !bytes.Equal(msg[8:stunHeaderLen], txID) {
+		return errors.New("ice: STUN reply for another transaction")
+	}
+	switch binary.BigEndian.Uint16(msg[0:2]) {
+	case stunAllocateSuccess, stunAllocateError:
+		return nil
+	default:
+		return fmt.Errorf("ice: unexpected STUN message type %#04x", binary.BigEndian.Uint16(msg[0:2]))
+	}
+}
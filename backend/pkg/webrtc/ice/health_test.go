@@ -0,0 +1,176 @@
+package ice
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"videochat/pkg/webrtc/protocol"
+)
+
+// fakeProbes reports the URLs in down as unreachable.
+type fakeProbes struct {
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+func (f *fakeProbes) set(url string, down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down[url] = down
+}
+
+func (f *fakeProbes) probe(_ context.Context, url string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.down[url] {
+		return errors.New("unreachable")
+	}
+	return nil
+}
+
+func newTestChecker(servers []protocol.ICEServer) (*HealthChecker, *fakeProbes) {
+	probes := &fakeProbes{down: make(map[string]bool)}
+	hc := &HealthChecker{
+		servers:   servers,
+		timeout:   time.Second,
+		probe:     probes.probe,
+		unhealthy: make(map[string]bool),
+	}
+	return hc, probes
+}
+
+func TestHealthCheckerTogglesUnhealthyRelays(t *testing.T) {
+	servers := []protocol.ICEServer{
+		{URLs: []string{"stun:stun.example.com:3478"}},
+		{URLs: []string{"turn:a.example.com:3478", "turns:a.example.com:5349"}, Username: "u", Credential: "p"},
+		{URLs: []string{"turn:b.example.com:3478"}},
+	}
+	hc, probes := newTestChecker(servers)
+
+	hc.checkAll()
+	if got := hc.Servers(); !reflect.DeepEqual(got, servers) {
+		t.Fatalf("all healthy: got %+v, want the full list", got)
+	}
+
+	probes.set("turn:a.example.com:3478", true)
+	probes.set("turn:b.example.com:3478", true)
+	hc.checkAll()
+	if hc.Healthy("turn:a.example.com:3478") || !hc.Healthy("turns:a.example.com:5349") {
+		t.Fatalf("health after probes: a=%v a-tls=%v", hc.Healthy("turn:a.example.com:3478"), hc.Healthy("turns:a.example.com:5349"))
+	}
+	want := []protocol.ICEServer{
+		{URLs: []string{"stun:stun.example.com:3478"}},
+		{URLs: []string{"turns:a.example.com:5349"}, Username: "u", Credential: "p"},
+	}
+	if got := hc.Servers(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("some down: got %+v, want %+v", got, want)
+	}
+
+	probes.set("turn:a.example.com:3478", false)
+	probes.set("turn:b.example.com:3478", false)
+	hc.checkAll()
+	if got := hc.Servers(); !reflect.DeepEqual(got, servers) {
+		t.Fatalf("recovered: got %+v, want the full list", got)
+	}
+}
+
+func TestHealthCheckerKeepsEverythingWhenAllRelaysDown(t *testing.T) {
+	servers := []protocol.ICEServer{
+		{URLs: []string{"stun:stun.example.com:3478"}},
+		{URLs: []string{"turn:a.example.com:3478"}},
+	}
+	hc, probes := newTestChecker(servers)
+	probes.set("turn:a.example.com:3478", true)
+	hc.checkAll()
+	if hc.Healthy("turn:a.example.com:3478") {
+		t.Fatal("relay should be unhealthy")
+	}
+	if got := hc.Servers(); !reflect.DeepEqual(got, servers) {
+		t.Fatalf("got %+v, want the full list", got)
+	}
+}
+
+func TestNewHealthCheckerNeedsTURN(t *testing.T) {
+	if hc := NewHealthChecker([]protocol.ICEServer{{URLs: []string{"stun:stun.example.com"}}}, time.Second, 0); hc != nil {
+		hc.Close()
+		t.Fatal("expected nil checker without TURN URLs")
+	}
+	var hc *HealthChecker
+	if !hc.Healthy("turn:x") || hc.Servers() != nil {
+		t.Fatal("nil checker should report everything healthy")
+	}
+	hc.Close()
+}
+
+func TestProbeTURNAcceptsAllocateError(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil || n < stunHeaderLen {
+			return
+		}
+		// Answer like a TURN server demanding credentials: 401 Allocate error response.
+		resp := make([]byte, stunHeaderLen)
+		binary.BigEndian.PutUint16(resp[0:], stunAllocateError)
+		binary.BigEndian.PutUint32(resp[4:], stunMagicCookie)
+		copy(resp[8:], buf[8:stunHeaderLen])
+		_, _ = conn.WriteTo(resp, addr)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := probeTURN(ctx, "turn:"+conn.LocalAddr().String()); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+}
+
+func TestProbeTURNTimesOutWithoutReply(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if err := probeTURN(ctx, "turn:"+conn.LocalAddr().String()); err == nil {
+		t.Fatal("expected a silent server to fail the probe")
+	}
+}
+
+func TestParseTURNURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		network string
+		addr    string
+		secure  bool
+	}{
+		{"turn:example.com", "udp", "example.com:3478", false},
+		{"turn:example.com:3479?transport=tcp", "tcp", "example.com:3479", false},
+		{"turns:example.com", "tcp", "example.com:5349", true},
+		{"turn:[::1]", "udp", "[::1]:3478", false},
+	}
+	for _, tt := range tests {
+		network, addr, secure, err := parseTURNURL(tt.url)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.url, err)
+		}
+		if network != tt.network || addr != tt.addr || secure != tt.secure {
+			t.Errorf("%s: got %s %s %v, want %s %s %v", tt.url, network, addr, secure, tt.network, tt.addr, tt.secure)
+		}
+	}
+	if _, _, _, err := parseTURNURL("turn:"); err == nil {
+		t.Error("expected an error for a URL without host")
+	}
+}
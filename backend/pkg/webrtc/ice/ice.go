@@ -16,17 +16,32 @@ import (
 // - TURN_USERNAME / TURN_PASSWORD: TURN credentials (if required)
 // - ICE_MODE: stun-turn (default), turn-only, stun-only
 func LoadFromEnv() (mode string, servers []protocol.ICEServer) {
-	mode = strings.TrimSpace(os.Getenv("ICE_MODE"))
+	return LoadFromEnvPrefix("")
+}
+
+// LoadFromEnvPrefix is LoadFromEnv reading prefix+NAME first (e.g., "APP1_TURN_URLS")
+// and falling back to the unprefixed variable.
+func LoadFromEnvPrefix(prefix string) (mode string, servers []protocol.ICEServer) {
+	getenv := func(key string) string {
+		if prefix != "" {
+			if v := strings.TrimSpace(os.Getenv(prefix + key)); v != "" {
+				return v
+			}
+		}
+		return strings.TrimSpace(os.Getenv(key))
+	}
+
+	mode = getenv("ICE_MODE")
 	if mode == "" {
 		mode = "stun-turn"
 	}
 
 	defaultSTUN := []string{"stun:stun.l.google.com:19302"}
 
-	stunEnv := strings.TrimSpace(os.Getenv("STUN_URLS"))
-	turnEnv := strings.TrimSpace(os.Getenv("TURN_URLS"))
-	turnUsername := strings.TrimSpace(os.Getenv("TURN_USERNAME"))
-	turnPassword := strings.TrimSpace(os.Getenv("TURN_PASSWORD"))
+	stunEnv := getenv("STUN_URLS")
+	turnEnv := getenv("TURN_URLS")
+	turnUsername := getenv("TURN_USERNAME")
+	turnPassword := getenv("TURN_PASSWORD")
 
 	turnOnly := strings.EqualFold(mode, "turn-only")
 	stunOnly := strings.EqualFold(mode, "stun-only")
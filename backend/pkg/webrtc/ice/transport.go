@@ -0,0 +1,49 @@
+package ice
+
+import (
+	"sort"
+	"strings"
+
+	"videochat/pkg/webrtc/protocol"
+)
+
+// PreferTCPRelays reorders servers for clients on networks that block UDP: servers
+// offering a TCP/TLS TURN URL ("turns:" or "?transport=tcp") come first, and within
+// each server those URLs precede the rest. Nothing is dropped, so UDP stays available
+// as a fallback. servers is not modified.
+func PreferTCPRelays(servers []protocol.ICEServer) []protocol.ICEServer {
+	out := make([]protocol.ICEServer, len(servers))
+	for i, s := range servers {
+		urls := append([]string(nil), s.URLs...)
+		sort.SliceStable(urls, func(a, b int) bool {
+			return tcpRelayURL(urls[a]) && !tcpRelayURL(urls[b])
+		})
+		s.URLs = urls
+		out[i] = s
+	}
+	sort.SliceStable(out, func(a, b int) bool {
+		return hasTCPRelay(out[a]) && !hasTCPRelay(out[b])
+	})
+	return out
+}
+
+func hasTCPRelay(s protocol.ICEServer) bool {
+	for _, u := range s.URLs {
+		if tcpRelayURL(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// tcpRelayURL reports whether raw is a TURN URL reachable over TCP or TLS.
+func tcpRelayURL(raw string) bool {
+	raw = strings.ToLower(strings.TrimSpace(raw))
+	switch {
+	case strings.HasPrefix(raw, "turns:"):
+		return true
+	case strings.HasPrefix(raw, "turn:"):
+		return strings.Contains(raw, "transport=tcp")
+	}
+	return false
+}
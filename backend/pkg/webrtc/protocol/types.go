@@ -2,6 +2,42 @@ package protocol
 
 import "encoding/json"
 
+// Protocol versions negotiated by clients via the `v` query parameter.
+const (
+	// VersionFull sends the full peer roster with every presence change.
+	VersionFull = 1
+	// VersionPresenceDiff sends only added/removed peer IDs in peer-joined/peer-left.
+	VersionPresenceDiff = 2
+	// VersionBroadcastDiff additionally sends broadcast-state as just id, enabled and
+	// seq, without the roster.
+	VersionBroadcastDiff = 3
+	// Version is the newest protocol version the server speaks.
+	Version = VersionBroadcastDiff
+)
+
+// MaxUsernameLength is the longest display name (in characters) the server accepts.
+const MaxUsernameLength = 64
+
+// Room feature flags advertised in the welcome message. Unset features default to enabled.
+const (
+	FeatureChat = "chat"
+	// FeatureReactions gates "reaction" frames.
+	FeatureReactions = "reactions"
+	// FeatureRecording gates "recording" frames, which announce a peer recording.
+	FeatureRecording = "recording"
+	// FeatureNotifications tells clients whether to play join/leave sounds; the server
+	// only conveys it.
+	FeatureNotifications = "notifications"
+)
+
+// Room topologies advertised in the welcome message.
+const (
+	// TopologyMesh has every peer connect to every other peer (the default).
+	TopologyMesh = "mesh"
+	// TopologyRelay has peers connect only to the elected relay peer.
+	TopologyRelay = "relay"
+)
+
 // ICEServer describes STUN/TURN servers advertised to clients.
 type ICEServer struct {
 	URLs       []string `json:"urls"`
@@ -10,12 +46,27 @@ type ICEServer struct {
 }
 
 // InboundMessage is the payload clients send to the signaling service.
+// Sender identity is always assigned by the server from the connection; a
+// client-supplied From is never trusted and is discarded before handling.
 type InboundMessage struct {
 	Type     string          `json:"type"`
 	To       string          `json:"to,omitempty"`
 	Data     json.RawMessage `json:"data,omitempty"`
 	Enabled  *bool           `json:"enabled,omitempty"`
 	Username string          `json:"username,omitempty"`
+	Text     string          `json:"text,omitempty"`
+	// ID is an optional client-chosen message ID; chat frames carrying one are acknowledged.
+	ID string `json:"id,omitempty"`
+	// From is decoded only so spoofing attempts can be detected and stripped.
+	From string `json:"from,omitempty"`
+	// Meta is broadcaster stream metadata (e.g., resolution, codec) in broadcast-meta
+	// frames, and the peer's attributes (flat string map) in set-meta frames.
+	Meta json.RawMessage `json:"meta,omitempty"`
+	// Audio/Video report the sender's local track state in media-state frames.
+	Audio *bool `json:"audio,omitempty"`
+	Video *bool `json:"video,omitempty"`
+	// Locked is the requested state in lock frames.
+	Locked *bool `json:"locked,omitempty"`
 }
 
 // StateMessage is broadcast to clients to convey room state.
@@ -28,6 +79,138 @@ type StateMessage struct {
 	ICEServers   []ICEServer       `json:"iceServers,omitempty"`
 	ICEMode      string            `json:"iceMode,omitempty"`
 	Usernames    map[string]string `json:"usernames,omitempty"`
+	Added        []string          `json:"added,omitempty"`
+	Removed      []string          `json:"removed,omitempty"`
+	Version      int               `json:"version,omitempty"`
+	Features     map[string]bool   `json:"features,omitempty"`
+	Relay        string            `json:"relay,omitempty"`
+	// Region names the server's deployment region/edge (welcome only, empty when unset).
+	Region string `json:"region,omitempty"`
+	// BroadcastMeta maps broadcaster IDs to the stream metadata they advertised.
+	BroadcastMeta map[string]json.RawMessage `json:"broadcastMeta,omitempty"`
+	// PeerMeta maps peer IDs to the attributes they set with "set-meta".
+	PeerMeta map[string]json.RawMessage `json:"peerMeta,omitempty"`
+	// Peer describes the joining peer in one place (peer-joined only).
+	Peer *PeerEntry `json:"peer,omitempty"`
+	// ICETransportPolicy is the RTCIceTransportPolicy peers should use (welcome only;
+	// "relay" forces TURN).
+	ICETransportPolicy string `json:"iceTransportPolicy,omitempty"`
+	// Topology is "relay" when peers should connect only to Relay (welcome only;
+	// empty means mesh).
+	Topology string `json:"topology,omitempty"`
+	// Locked reports that the room refuses new joins (welcome only).
+	Locked bool `json:"locked,omitempty"`
+	// RequireName reports that peers are announced only once they have a display
+	// name (welcome only).
+	RequireName bool `json:"requireName,omitempty"`
+	// Reopened reports that this join brought the room back from its closing grace
+	// period (welcome only).
+	Reopened bool `json:"reopened,omitempty"`
+	// PeerCount is the authoritative number of peers in the room and StateVersion
+	// the server's roster version, bumped on every join or leave it sees. Both ride
+	// on welcome, sync, roster, snapshot and peer-joined/peer-left (full or diff); a
+	// client whose last StateVersion is more than one behind should send "sync".
+	PeerCount    int   `json:"peerCount,omitempty"`
+	StateVersion int64 `json:"stateVersion,omitempty"`
+	// MaxUsernameLength is the longest display name, in characters, set-username
+	// accepts (welcome only).
+	MaxUsernameLength int `json:"maxUsernameLength,omitempty"`
+	// Seq numbers broadcast-state messages per room; a gap means a toggle was missed.
+	Seq int64 `json:"seq,omitempty"`
+}
+
+// ChatMessage is relayed to every peer in the room when chat is enabled.
+type ChatMessage struct {
+	Type string `json:"type"`
+	From string `json:"from"`
+	Text string `json:"text"`
+	TS   int64  `json:"ts"`
+}
+
+// ReactionMessage relays a short reaction (e.g. an emoji) to the room; it is not stored.
+type ReactionMessage struct {
+	Type  string `json:"type"`
+	From  string `json:"from"`
+	Emoji string `json:"emoji"`
+	TS    int64  `json:"ts"`
+}
+
+// ChatHistoryMessage replays a room's persisted chat messages (oldest first) to a joiner.
+type ChatHistoryMessage struct {
+	Type     string            `json:"type"`
+	Messages []json.RawMessage `json:"messages"`
+}
+
+// Peer roles reported in PeerEntry.Role.
+const (
+	RoleOwner       = "owner"
+	RoleParticipant = "participant"
+	RoleSynthetic   = "synthetic"
+)
+
+// PeerEntry is what a peer-joined message says about the joining peer: its name,
+// role, broadcast flag, connect-time media state (nil when not given) and
+// "set-meta" attributes.
+type PeerEntry struct {
+	ID           string          `json:"id"`
+	Username     string          `json:"username,omitempty"`
+	Role         string          `json:"role"`
+	Broadcasting bool            `json:"broadcasting"`
+	Audio        *bool           `json:"audio,omitempty"`
+	Video        *bool           `json:"video,omitempty"`
+	Meta         json.RawMessage `json:"meta,omitempty"`
+}
+
+// MediaStateMessage relays a peer's microphone/camera state to the rest of the room.
+type MediaStateMessage struct {
+	Type  string `json:"type"`
+	ID    string `json:"id"`
+	Audio bool   `json:"audio"`
+	Video bool   `json:"video"`
+}
+
+// AckMessage confirms a chat message was enqueued. Dropped lists recipients whose
+// send buffer was full; Partial is set when that list is non-empty.
+type AckMessage struct {
+	Type      string   `json:"type"`
+	ID        string   `json:"id"`
+	Delivered int      `json:"delivered"`
+	Dropped   []string `json:"dropped,omitempty"`
+	Partial   bool     `json:"partial,omitempty"`
+}
+
+// ErrorMessage tells a single client that one of its frames was rejected.
+type ErrorMessage struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// RoomRenamedMessage tells peers their room moved to Code; they should reconnect there.
+type RoomRenamedMessage struct {
+	Type string `json:"type"`
+	Code string `json:"code"`
+}
+
+// MigrateMessage tells peers this server is going away and they should reconnect
+// to URL (a WebSocket base URL; the client keeps its own room and query).
+type MigrateMessage struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// RoomClosingSoonMessage warns peers that their room expires in Seconds. A
+// "room-closing-cancelled" message (Seconds 0) follows if the room is extended.
+type RoomClosingSoonMessage struct {
+	Type    string `json:"type"`
+	Seconds int    `json:"seconds"`
+}
+
+// ICERestartMessage asks the target peer to renegotiate with an ICE restart
+// (create a new offer with iceRestart) towards From.
+type ICERestartMessage struct {
+	Type string `json:"type"`
+	From string `json:"from"`
+	To   string `json:"to"`
 }
 
 // SignalMessage carries peer-to-peer WebRTC signaling data.
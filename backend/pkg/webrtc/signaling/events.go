@@ -0,0 +1,35 @@
+package signaling
+
+import "time"
+
+// Event types emitted to HubOptions.Events.
+const (
+	EventJoin           = "join"
+	EventLeave          = "leave"
+	EventBroadcastStart = "broadcast-start"
+	EventBroadcastStop  = "broadcast-stop"
+	EventChat           = "chat"
+)
+
+// Event is one room activity record for analytics.
+type Event struct {
+	Room string    `json:"room"`
+	Peer string    `json:"peer"`
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+}
+
+// EventSink receives room events. Emit is called on signaling paths, so it must
+// not block: implementations queue the event or drop it.
+type EventSink interface {
+	Emit(e Event)
+}
+
+// NopEventSink discards every event; it is the default when HubOptions.Events is nil.
+type NopEventSink struct{}
+
+func (NopEventSink) Emit(Event) {}
+
+func (h *Hub) emit(eventType, peer string) {
+	h.events.Emit(Event{Room: h.room, Peer: peer, Type: eventType, Time: time.Now().UTC()})
+}
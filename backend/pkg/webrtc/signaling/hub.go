@@ -1,19 +1,30 @@
 package signaling
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"videochat/pkg/presence"
+	"videochat/pkg/webrtc/ice"
 	"videochat/pkg/webrtc/protocol"
 )
 
@@ -21,10 +32,54 @@ const (
 	defaultReadLimit   = 64 * 1024
 	pingInterval       = 40 * time.Second
 	writeTimeout       = 10 * time.Second
+	maxChatLength      = 2000
+	maxReactionLength  = 32
+	errorReplyInterval = time.Second
+	maxLoggedPayload   = 256
+	maxBroadcastMeta   = 1024
+	maxPeerMeta        = 1024
+	maxPeerMetaKeys    = 16
+	maxPeerMetaKey     = 32
+	maxSessionLength   = 128
+	maxPeerIDLength    = 64
 	upgradeReadBuffer  = 1024
 	upgradeWriteBuffer = 1024
 )
 
+// ErrDuplicateSession is returned by Accept when DuplicateSessions is "reject" and
+// the connection's ID or session is already in the room.
+var ErrDuplicateSession = errors.New("signaling: duplicate session")
+
+// errSlowConsumer is returned by client.write once MaxSlowWrites is reached.
+var errSlowConsumer = errors.New("slow consumer")
+
+// errMessageTooBig is returned by readMessage for messages over the hub's MaxMessageSize.
+var errMessageTooBig = errors.New("message too big")
+
+// ErrInvalidPeerID is returned by Accept when ConnOptions.ID is too long or has
+// characters outside ValidPeerID's set.
+var ErrInvalidPeerID = errors.New("signaling: invalid peer ID")
+
+// ErrRoomLocked is returned by Accept while the room is locked (see SetLocked).
+var ErrRoomLocked = errors.New("signaling: room locked")
+
+// ErrRoomFull is returned by Accept when the room already holds MaxPeers peers.
+var ErrRoomFull = errors.New("signaling: room full")
+
+// ErrHubClosed is returned by Accept once Shutdown has run.
+var ErrHubClosed = errors.New("signaling: hub closed")
+
+// ErrRoomUnavailable is returned by Accept when HubOptions.RoomGuard rejects the connection.
+var ErrRoomUnavailable = errors.New("signaling: room unavailable")
+
+// ErrRoomBusy is returned by Accept while a Hold keeps joins out of the room.
+var ErrRoomBusy = errors.New("signaling: room busy")
+
+// ErrJoinFailed is returned by Accept (wrapping the store error) when the peer
+// could not be added to room presence; the client gets a "join_failed" error and
+// close 1013 so it can retry.
+var ErrJoinFailed = errors.New("signaling: join failed")
+
 // BroadcastStore is an optional application-level store for tracking who is "live".
 type BroadcastStore interface {
 	Reset(ctx context.Context) error
@@ -33,6 +88,19 @@ type BroadcastStore interface {
 	Broadcasting(ctx context.Context) ([]string, error)
 }
 
+// cappedBroadcastStore is implemented by broadcast stores that can enforce a
+// broadcaster cap atomically; other stores fall back to a count-then-set check.
+type cappedBroadcastStore interface {
+	SetBroadcastCapped(ctx context.Context, id string, max int) (bool, error)
+}
+
+// broadcastMetaStore is implemented by broadcast stores that keep per-broadcaster
+// stream metadata (resolution, codec, ...) for late joiners.
+type broadcastMetaStore interface {
+	SetBroadcastMeta(ctx context.Context, id string, meta []byte) error
+	BroadcastMeta(ctx context.Context) (map[string]json.RawMessage, error)
+}
+
 // UsernameStore is an optional application-level store for tracking display names.
 type UsernameStore interface {
 	Reset(ctx context.Context) error
@@ -41,15 +109,177 @@ type UsernameStore interface {
 	Usernames(ctx context.Context) (map[string]string, error)
 }
 
+// ChatHistoryStore is an optional store of recent chat messages (encoded chat frames).
+type ChatHistoryStore interface {
+	Append(ctx context.Context, msg []byte) error
+	Recent(ctx context.Context) ([][]byte, error)
+}
+
+// peerMetaStore is implemented by username stores that also keep per-peer
+// client-set attributes (avatar URL, role label, ...) for "set-meta".
+type peerMetaStore interface {
+	SetPeerMeta(ctx context.Context, id string, meta []byte) error
+	PeerMeta(ctx context.Context) (map[string]json.RawMessage, error)
+}
+
+// bulkUsernameStore is implemented by username stores that can write many names
+// in one round-trip; other stores fall back to one SetUsername call per entry.
+type bulkUsernameStore interface {
+	SetUsernames(ctx context.Context, names map[string]string) error
+}
+
 // HubOptions configures a Hub instance.
 type HubOptions struct {
 	ICEServers []protocol.ICEServer
 	ICEMode    string
+	// ICESource, when set, supplies the ICE servers for each welcome instead of
+	// ICEServers, e.g. to leave out TURN servers failing a health check.
+	ICESource  func() []protocol.ICEServer
 	Logger     *log.Logger
 	Upgrader   *websocket.Upgrader
 	OnEmpty    func()
 	Broadcasts BroadcastStore
 	Usernames  UsernameStore
+	// Features carries the room's feature flags; unset features default to enabled.
+	Features map[string]bool
+	// OnLeave reports each disconnect with the peer's close code and reason.
+	// Connections that drop without a close frame are reported as 1006 (abnormal closure).
+	OnLeave func(id string, code int, reason string)
+	// ElectRelay designates the earliest-joined peer as the room's relay and announces
+	// changes via "relay-elected". This is signaling metadata only; no media is routed.
+	ElectRelay bool
+	// LogPayloads logs a redacted, truncated copy of each inbound Data payload (off by default for privacy).
+	LogPayloads bool
+	// DeferRoster sends a minimal welcome (identity, ICE, flags) immediately and follows it
+	// with a "roster" message carrying peers/broadcasting/usernames once the snapshot is built.
+	DeferRoster bool
+	// MaxBroadcasters caps simultaneous broadcasters (0 = unlimited). Requests beyond
+	// the cap are rejected with a "broadcast-denied" reply.
+	MaxBroadcasters int
+	// MaxPeers caps the peers in the room (0 = unlimited). ServeWS answers further
+	// joins with 503 before upgrading; Accept closes them and returns ErrRoomFull.
+	MaxPeers int
+	// Stats receives join/leave/forward metrics (defaults to NopStats).
+	Stats Stats
+	// Events receives join/leave/broadcast/chat events for analytics, stamped with
+	// Room (defaults to NopEventSink). Emit must not block.
+	Events EventSink
+	Room   string
+	// RoomGuard, when set, is consulted before every registration (e.g., to check the room
+	// still exists); returning false rejects the connection with ErrRoomUnavailable.
+	RoomGuard func(ctx context.Context) bool
+	// Paused starts the hub with signal/chat fanout paused (see SetPaused).
+	Paused bool
+	// Locked starts the hub refusing new joins (see SetLocked).
+	Locked bool
+	// OnLockChange, when set, is told when the room's owner locks or unlocks it with
+	// a "lock" frame, e.g. to persist the flag so other instances refuse joins too.
+	OnLockChange func(locked bool)
+	// StrictDecoding rejects inbound frames carrying fields unknown to
+	// protocol.InboundMessage with an "unknown_field" error, to surface protocol drift.
+	StrictDecoding bool
+	// RenameCooldown is the minimum interval between set-username changes per peer
+	// (0 = no limit). Earlier changes get a "rename-throttled" reply.
+	RenameCooldown time.Duration
+	// Region is reported in the welcome message so clients can show which edge they use.
+	Region string
+	// AutoBroadcastOff clears a peer's broadcast flag when its media-state reports both
+	// audio and video off, so stale "live" indicators don't linger.
+	AutoBroadcastOff bool
+	// InboundLimiter caps the inbound frame rate across every hub sharing it, shedding
+	// low-priority frames first (nil = unlimited).
+	InboundLimiter *InboundLimiter
+	// UsernameRetention keeps a departed peer's display name this long so a reconnect
+	// with the same peer ID (e.g., stable cookie identity) gets it back without a
+	// set-username round-trip (0 = forget immediately).
+	UsernameRetention time.Duration
+	// ChatHistory, when set, persists relayed chat messages and replays them to joiners.
+	ChatHistory ChatHistoryStore
+	// MaxConnLifetime closes connections this long after they connect (plus up to 10%
+	// jitter) with close code 1012 (service restart), prompting clients to reconnect
+	// so load rebalances across instances (0 = no limit).
+	MaxConnLifetime time.Duration
+	// LeaveGrace delays peer-left (and the peer's presence/broadcast/username removal)
+	// for peers with a caller-supplied, stable ID. Reconnecting with the same ID inside
+	// the window resumes silently: no peer-left/peer-joined churn (0 = leave at once).
+	LeaveGrace time.Duration
+	// EmptyGrace delays OnEmpty until the room has stayed empty this long. A join
+	// inside the window cancels it, so peers all reconnecting at once do not make the
+	// owner schedule cleanup over and over (0 = call OnEmpty at once).
+	EmptyGrace time.Duration
+	// MaxFramesPerConn caps the inbound frames one connection may send over its
+	// lifetime, catching slow-drip floods per-second limits miss. Exceeding it closes
+	// the connection with 1008 "frame_limit" (0 = unlimited).
+	MaxFramesPerConn int
+	// PongTimeout closes a connection whose ping goes unanswered this long, catching
+	// half-open sockets well before the 60s read deadline. When set, pings are sent
+	// every 2*PongTimeout (capped at the default 40s interval) (0 = disabled).
+	PongTimeout time.Duration
+	// MaxJSONDepth and MaxJSONToken bound the nesting depth and the length of object
+	// keys/number literals of inbound frames, checked before unmarshalling; offending
+	// frames get an error reply with reason "frame_too_complex" (0 = defaults 32 and 256).
+	MaxJSONDepth int
+	MaxJSONToken int
+	// GuestPrefix, when set, gives peers that join without a username a generated
+	// one such as "Guest-4821", unique within the room (requires Usernames).
+	GuestPrefix string
+	// ICETransportPolicy is the room's RTCIceTransportPolicy override: "relay" forces
+	// TURN for this room whatever ICEMode says; empty or "all" keeps ICEMode
+	// ("turn-only" then implies "relay").
+	ICETransportPolicy string
+	// HandshakeTimeout bounds writing the upgrade response to a client that stalls;
+	// the upgrade then fails and the goroutine is released. It applies unless
+	// Upgrader sets its own (0 = no limit).
+	HandshakeTimeout time.Duration
+	// CompressAbove, when positive, negotiates permessage-deflate and compresses
+	// outbound frames of at least this many bytes (e.g. large welcome snapshots);
+	// smaller frames are sent uncompressed to save CPU. Clients that did not
+	// negotiate the extension get plain frames (0 = compression off).
+	CompressAbove int
+	// DuplicateSessions decides what happens when a connection carries the ID or
+	// ConnOptions.Session of a peer already in the room (e.g. the same browser in
+	// two tabs): "reject" refuses the new connection (close 1008
+	// "duplicate_session"), "replace" closes the older one (close 1000
+	// "session_replaced"); anything else allows both. A connection whose ID is
+	// already taken always replaces the older one unless this is "reject", so an
+	// ID never holds two seats. Rejoins within LeaveGrace are never duplicates.
+	DuplicateSessions string
+	// ReadyTimeout, when positive, holds back a joiner's peer-joined until it sends
+	// "ready" (its RTCPeerConnection setup is done), so others don't send offers it
+	// would drop. Peers that never send it are announced after this long (0 = announce
+	// on join).
+	ReadyTimeout time.Duration
+	// RequireName holds back a joiner's peer-joined, and refuses its signal,
+	// ice-restart, broadcast and chat messages ("name_required"), until it has a
+	// display name, either from the connection or a later set-username. Anonymous
+	// joiners are allowed otherwise.
+	RequireName bool
+	// Topology is the room's signaling topology: protocol.TopologyRelay implies
+	// ElectRelay and tells peers (via the welcome) to connect only to the relay;
+	// anything else is a full mesh.
+	Topology string
+	// MaxMessageSize caps an inbound message in bytes, counted across all of its
+	// fragments once reassembled (large SDP offers often arrive fragmented).
+	// Larger messages get an error reply with reason "message_too_big" and the
+	// connection is closed with 1009 (0 = 64 KiB).
+	MaxMessageSize int
+	// WriteTimeout bounds each outbound write; a client that cannot take a frame in
+	// time is disconnected (0 = 10s).
+	WriteTimeout time.Duration
+	// ControlWrite and SignalWrite override WriteTimeout per kind of frame:
+	// ControlWrite covers pings, close frames and the control lane (welcome,
+	// presence, errors), SignalWrite relayed signaling such as large SDP offers
+	// (0 = WriteTimeout).
+	ControlWrite time.Duration
+	SignalWrite  time.Duration
+	// SlowWrite and MaxSlowWrites catch clients that stall the write loop
+	// without ever hitting WriteTimeout: after MaxSlowWrites consecutive writes each
+	// taking at least SlowWrite, the client is closed with 1008
+	// "slow_consumer" so it stops delaying its own pings and updates (0 = off).
+	SlowWrite     time.Duration
+	MaxSlowWrites int
+	// MaxPeerIDLength caps caller-supplied ConnOptions.ID values (0 = 64); see ValidPeerID.
+	MaxPeerIDLength int
 }
 
 // ConnOptions controls how a connection is registered.
@@ -58,28 +288,163 @@ type ConnOptions struct {
 	ID string
 	// Context lets the caller cancel the connection (defaults to Background).
 	Context context.Context
+	// OnClose runs once after the connection ends, including when the upgrade or registration fails.
+	OnClose func()
+	// ProtocolVersion selects the message format (defaults to protocol.VersionFull).
+	ProtocolVersion int
+	// Username is an optional display name applied before the first snapshot, so the
+	// welcome and peer-joined already carry it. Invalid names are ignored.
+	Username string
+	// Session is an optional client-supplied browser fingerprint used to detect the
+	// same browser joining twice (see HubOptions.DuplicateSessions).
+	Session string
+	// Audio and Video are the peer's optional connect-time media state, reported in
+	// the peer-joined entry (ServeWS reads them from the "audio"/"video" query).
+	Audio *bool
+	Video *bool
+	// PreferTCP puts TCP/TLS TURN relays first in the welcome's iceServers, for
+	// clients on networks that block UDP (ServeWS reads it from "transport=tcp").
+	PreferTCP bool
+	// Reopened marks the welcome with reopened: true, for callers that revived a
+	// closing room to admit this connection.
+	Reopened bool
 }
 
 // Hub manages WebSocket peers and signaling fanout.
 type Hub struct {
-	mu         sync.RWMutex
-	clients    map[string]*client
-	presence   presence.Store
-	broadcasts BroadcastStore
-	usernames  UsernameStore
-	iceServers []protocol.ICEServer
-	iceMode    string
-	upgrader   websocket.Upgrader
-	logger     *log.Logger
-	onEmpty    func()
+	mu           sync.RWMutex
+	clients      map[string]*client
+	presence     presence.Store
+	broadcasts   BroadcastStore
+	usernames    UsernameStore
+	iceServers   []protocol.ICEServer
+	iceSource    func() []protocol.ICEServer
+	iceMode      string
+	icePolicy    string
+	topology     string
+	maxMessage   int64
+	writeTimeout time.Duration
+	signalWrite  time.Duration
+	slowWrite    time.Duration
+	maxSlow      int
+	maxIDLen     int
+	upgrader     websocket.Upgrader
+	logger       *log.Logger
+	onEmpty      func()
+	onLeave      func(id string, code int, reason string)
+	features     map[string]bool
+	electRelay   bool
+	logPayload   bool
+	deferRoster  bool
+	maxBcast     int
+	maxPeers     int
+	stats        Stats
+	events       EventSink
+	room         string
+	strict       bool
+	renameEvery  time.Duration
+	region       string
+	autoBcastOff bool
+	inbound      *InboundLimiter
+	nameRetain   time.Duration
+	chatHistory  ChatHistoryStore
+	maxLifetime  time.Duration
+	// recentNames holds display names of recently departed peers; guarded by mu.
+	recentNames map[string]recentName
+	leaveGrace  time.Duration
+	// pendingLeaves holds the delayed-leave timers of peers inside LeaveGrace; guarded by mu.
+	pendingLeaves map[string]*time.Timer
+	// leaving counts the completeLeave calls in flight per peer ID, so SweepGhosts
+	// doesn't announce a departing peer a second time; guarded by mu.
+	leaving    map[string]int
+	emptyGrace time.Duration
+	// emptyTimer is the pending EmptyGrace check before OnEmpty; guarded by mu.
+	emptyTimer    *time.Timer
+	maxFrames     int
+	pongTimeout   time.Duration
+	jsonLimits    jsonLimits
+	guestPrefix   string
+	compressAbove int
+	dupSessions   string
+	readyTimeout  time.Duration
+	requireName   bool
+	roomGuard     func(ctx context.Context) bool
+	paused        atomic.Bool
+	locked        atomic.Bool
+	// rosterVersion counts joins and leaves seen by this hub; see StateMessage.StateVersion.
+	rosterVersion atomic.Int64
+	// bcastSequence numbers broadcast toggles; see StateMessage.Seq.
+	bcastSequence atomic.Int64
+	onLockChange  func(locked bool)
+	closed        atomic.Bool
+	relay         string
+	joinSeq       uint64
+	// held refuses joins while a Hold is active; guarded by mu.
+	held bool
+	// watchers are the channels handed out by Watch; guarded by watchMu.
+	watchMu  sync.Mutex
+	watchers map[chan struct{}]struct{}
 }
 
 type client struct {
-	id     string
-	conn   *websocket.Conn
-	send   chan []byte
-	ctx    context.Context
-	cancel context.CancelFunc
+	id   string
+	conn *websocket.Conn
+	// send is the high-priority lane (state, roster, control); signal carries
+	// relayed SDP/ICE. writePump always drains send first.
+	send    chan []byte
+	signal  chan []byte
+	ctx     context.Context
+	cancel  context.CancelFunc
+	onClose func()
+	version int
+	seq     uint64
+	// username is the connect-time display name, applied during register.
+	username string
+	// lastErrorAt rate-limits error replies; only touched by readPump.
+	lastErrorAt time.Time
+	// lastRenameAt enforces RenameCooldown; only touched by readPump.
+	lastRenameAt time.Time
+	// kick asks writePump to flush queued frames and close with the given frame.
+	kick chan closeFrame
+	// frames counts inbound frames for MaxFramesPerConn; only touched by readPump.
+	frames int
+	// tooBig is set once a message over MaxMessageSize arrived; only touched by readPump.
+	tooBig bool
+	// stableID is set when the caller chose the ID, so a reconnect can be recognized.
+	stableID bool
+	// session is the client-supplied browser fingerprint (empty when not sent).
+	session string
+	// audio/video are the connect-time media state from ConnOptions; read-only.
+	audio *bool
+	video *bool
+	// preferTCP and reopened are ConnOptions.PreferTCP and Reopened; read-only.
+	preferTCP bool
+	reopened  bool
+	// joinHeld is set while peer-joined is held back; readyTimer (the ReadyTimeout
+	// fallback) and needName (RequireName) are what it still waits for. Guarded by mu.
+	joinHeld   bool
+	readyTimer *time.Timer
+	needName   bool
+	// connectedAt/lifetime drive MaxConnLifetime; lifetime 0 means unlimited.
+	connectedAt time.Time
+	lifetime    time.Duration
+	// pongTimeout is the hub's PongTimeout; pingPending is set while a ping awaits its pong.
+	pongTimeout time.Duration
+	pingPending atomic.Bool
+	// compressAbove is the hub's CompressAbove; only touched by writePump.
+	compressAbove int
+	// writeTimeout/signalWrite/slowWrite/maxSlow are the hub's write settings;
+	// slowWrites counts consecutive slow writes. Only touched by writePump.
+	writeTimeout time.Duration
+	signalWrite  time.Duration
+	slowWrite    time.Duration
+	maxSlow      int
+	slowWrites   int
+	// writeDone is closed when writePump exits.
+	writeDone chan struct{}
+	// closeCode/closeReason record how the peer disconnected; only touched by readPump.
+	closeCode   int
+	closeReason string
 }
 
 // NewHub builds a signaling Hub with the provided presence store and options.
@@ -94,310 +459,1936 @@ func NewHub(presenceStore presence.Store, opts HubOptions) *Hub {
 	if opts.Upgrader != nil {
 		upgrader = *opts.Upgrader
 	}
+	if upgrader.HandshakeTimeout == 0 {
+		upgrader.HandshakeTimeout = opts.HandshakeTimeout
+	}
+	if opts.CompressAbove > 0 {
+		upgrader.EnableCompression = true
+	}
 	logger := opts.Logger
 	if logger == nil {
 		logger = log.Default()
 	}
+	stats := opts.Stats
+	if stats == nil {
+		stats = NopStats{}
+	}
+	events := opts.Events
+	if events == nil {
+		events = NopEventSink{}
+	}
+	maxMessage := int64(defaultReadLimit)
+	if opts.MaxMessageSize > 0 {
+		maxMessage = int64(opts.MaxMessageSize)
+	}
+	// ControlWrite bounds control frames, SignalWrite relayed signaling; both
+	// default to WriteTimeout.
+	control, signalWrite := writeTimeout, writeTimeout
+	if opts.WriteTimeout > 0 {
+		control, signalWrite = opts.WriteTimeout, opts.WriteTimeout
+	}
+	if opts.ControlWrite > 0 {
+		control = opts.ControlWrite
+	}
+	if opts.SignalWrite > 0 {
+		signalWrite = opts.SignalWrite
+	}
+	var slowWrite time.Duration
+	var maxSlow int
+	if opts.SlowWrite > 0 && opts.MaxSlowWrites > 0 {
+		slowWrite, maxSlow = opts.SlowWrite, opts.MaxSlowWrites
+	}
+	var topology string
+	electRelay := opts.ElectRelay
+	if opts.Topology == protocol.TopologyRelay {
+		topology, electRelay = protocol.TopologyRelay, true
+	}
+	iceMode, icePolicy := opts.ICEMode, ""
+	switch {
+	case opts.ICETransportPolicy == "relay":
+		iceMode, icePolicy = "turn-only", "relay"
+	case strings.EqualFold(opts.ICEMode, "turn-only"):
+		icePolicy = "relay"
+	}
 
-	return &Hub{
-		clients:    make(map[string]*client),
-		presence:   presenceStore,
-		broadcasts: opts.Broadcasts,
-		usernames:  opts.Usernames,
-		iceServers: opts.ICEServers,
-		iceMode:    opts.ICEMode,
-		upgrader:   upgrader,
-		logger:     logger,
-		onEmpty:    opts.OnEmpty,
+	h := &Hub{
+		clients:       make(map[string]*client),
+		presence:      presenceStore,
+		broadcasts:    opts.Broadcasts,
+		usernames:     opts.Usernames,
+		iceServers:    opts.ICEServers,
+		iceSource:     opts.ICESource,
+		iceMode:       iceMode,
+		icePolicy:     icePolicy,
+		upgrader:      upgrader,
+		logger:        logger,
+		onEmpty:       opts.OnEmpty,
+		onLeave:       opts.OnLeave,
+		features:      opts.Features,
+		electRelay:    electRelay,
+		topology:      topology,
+		logPayload:    opts.LogPayloads,
+		deferRoster:   opts.DeferRoster,
+		maxBcast:      opts.MaxBroadcasters,
+		maxPeers:      opts.MaxPeers,
+		strict:        opts.StrictDecoding,
+		renameEvery:   opts.RenameCooldown,
+		region:        opts.Region,
+		autoBcastOff:  opts.AutoBroadcastOff,
+		inbound:       opts.InboundLimiter,
+		nameRetain:    opts.UsernameRetention,
+		chatHistory:   opts.ChatHistory,
+		maxLifetime:   opts.MaxConnLifetime,
+		recentNames:   make(map[string]recentName),
+		leaveGrace:    opts.LeaveGrace,
+		emptyGrace:    opts.EmptyGrace,
+		pendingLeaves: make(map[string]*time.Timer),
+		leaving:       make(map[string]int),
+		maxFrames:     opts.MaxFramesPerConn,
+		pongTimeout:   opts.PongTimeout,
+		jsonLimits:    newJSONLimits(opts.MaxJSONDepth, opts.MaxJSONToken),
+		guestPrefix:   opts.GuestPrefix,
+		compressAbove: opts.CompressAbove,
+		dupSessions:   opts.DuplicateSessions,
+		readyTimeout:  opts.ReadyTimeout,
+		requireName:   opts.RequireName,
+		stats:         stats,
+		events:        events,
+		room:          opts.Room,
+		roomGuard:     opts.RoomGuard,
+		onLockChange:  opts.OnLockChange,
+		maxMessage:    maxMessage,
+		writeTimeout:  control,
+		signalWrite:   signalWrite,
+		slowWrite:     slowWrite,
+		maxSlow:       maxSlow,
+		maxIDLen:      opts.MaxPeerIDLength,
+		watchers:      make(map[chan struct{}]struct{}),
 	}
+	// Atomics can't be set in the literal.
+	h.paused.Store(opts.Paused)
+	h.locked.Store(opts.Locked)
+	return h
 }
 
 func (h *Hub) HTTPHandler() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		conn, err := h.upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			h.logger.Printf("upgrade error: %v", err)
-			return
-		}
-		// Use a background context so the connection isn't canceled when the HTTP handler returns.
-		if err := h.Accept(conn, ConnOptions{}); err != nil {
-			h.logger.Printf("accept error: %v", err)
-			conn.Close()
-		}
+		h.ServeWS(w, r, ConnOptions{})
 	})
 }
 
-// Accept registers an already-upgraded WebSocket connection (useful when auth/guards are handled elsewhere).
-func (h *Hub) Accept(conn *websocket.Conn, opts ConnOptions) error {
-	ctx := opts.Context
-	if ctx == nil {
-		ctx = context.Background()
+// ServeWS upgrades the request and registers the connection with the provided options.
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, opts ConnOptions) {
+	if opts.ProtocolVersion == 0 {
+		opts.ProtocolVersion, _ = strconv.Atoi(r.URL.Query().Get("v"))
 	}
-	ctx, cancel := context.WithCancel(ctx)
-	id := opts.ID
-	if id == "" {
-		id = uuid.NewString()
+	if opts.Username == "" {
+		opts.Username = r.URL.Query().Get("username")
 	}
-	c := &client{
-		id:     id,
-		conn:   conn,
-		send:   make(chan []byte, 32),
-		ctx:    ctx,
-		cancel: cancel,
+	if opts.Session == "" {
+		opts.Session = r.URL.Query().Get("session")
 	}
-
-	if err := h.register(ctx, c); err != nil {
-		cancel()
-		return err
+	if opts.Audio == nil {
+		opts.Audio = queryBool(r, "audio")
 	}
-
-	go c.writePump()
-	go c.readPump(h)
-	return nil
-}
-
-func (h *Hub) snapshot(ctx context.Context) (peers []string, broadcasting []string, usernames map[string]string) {
-	peers, err := h.presence.Peers(ctx)
-	if err != nil {
-		h.logger.Printf("presence peers error: %v", err)
+	if opts.Video == nil {
+		opts.Video = queryBool(r, "video")
 	}
-
-	if h.broadcasts != nil {
-		broadcasting, err = h.broadcasts.Broadcasting(ctx)
-		if err != nil {
-			h.logger.Printf("broadcast state error: %v", err)
-		}
+	if strings.EqualFold(r.URL.Query().Get("transport"), "tcp") {
+		opts.PreferTCP = true
 	}
-	if h.usernames != nil {
-		usernames, err = h.usernames.Usernames(ctx)
-		if err != nil {
-			h.logger.Printf("username state error: %v", err)
+	// Until Accept takes the connection, OnClose is ours to run.
+	handedOff := false
+	defer func() {
+		if !handedOff && opts.OnClose != nil {
+			opts.OnClose()
 		}
+	}()
+	if err := h.admit(r.Context(), opts.ID); err != nil {
+		status, text := admitStatus(err)
+		http.Error(w, text, status)
+		return
+	}
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		h.logger.Printf("upgrade error: %v", err)
+		return
+	}
+	handedOff = true
+	// Use a background context so the connection isn't canceled when the HTTP handler returns.
+	if err := h.Accept(conn, opts); err != nil {
+		h.logger.Printf("accept error: %v", err)
+		conn.Close()
 	}
-	return peers, broadcasting, usernames
 }
 
-func (h *Hub) register(ctx context.Context, c *client) error {
-	h.mu.Lock()
-	h.clients[c.id] = c
-	h.mu.Unlock()
+// admit runs the checks a join must pass before its connection is set up, returning
+// ErrHubClosed, ErrInvalidPeerID, ErrRoomLocked, ErrRoomFull, ErrRoomBusy or
+// ErrRoomUnavailable.
+func (h *Hub) admit(ctx context.Context, id string) error {
+	switch {
+	case h.closed.Load():
+		return ErrHubClosed
+	case id != "" && !ValidPeerID(id, h.maxIDLen):
+		// IDs end up in logs, frames and Redis keys; keep them short and printable.
+		return ErrInvalidPeerID
+	case h.locked.Load() && !h.HasPeer(id):
+		return ErrRoomLocked
+	case h.full(id):
+		return ErrRoomFull
+	case h.isHeld():
+		return ErrRoomBusy
+	case h.roomGuard != nil && !h.roomGuard(ctx):
+		return ErrRoomUnavailable
+	}
+	return nil
+}
 
-	if err := h.presence.AddPeer(ctx, c.id); err != nil {
-		return err
+// Hold keeps new joins out until release is called, so the caller can rewrite the
+// room's stores (e.g. an import) without a peer seeing them half done. It fails
+// while peers are connected or another Hold is active.
+func (h *Hub) Hold() (release func(), ok bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.held || len(h.clients) > 0 {
+		return nil, false
 	}
+	h.held = true
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			h.mu.Lock()
+			h.held = false
+			h.mu.Unlock()
+		})
+	}, true
+}
 
-	peers, broadcasting, usernames := h.snapshot(ctx)
-	h.logger.Printf("ws: registered %s (peers=%d broadcasting=%d)", c.id, len(peers), len(broadcasting))
+func (h *Hub) isHeld() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.held
+}
 
-	welcome := protocol.StateMessage{
-		Type:         "welcome",
-		ID:           c.id,
-		Peers:        peers,
-		Broadcasting: broadcasting,
-		ICEServers:   h.iceServers,
-		ICEMode:      h.iceMode,
-		Usernames:    usernames,
+// admitStatus maps an admit error to the HTTP status and text ServeWS answers with.
+func admitStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrHubClosed):
+		return http.StatusServiceUnavailable, "server shutting down"
+	case errors.Is(err, ErrInvalidPeerID):
+		return http.StatusBadRequest, "invalid peer id"
+	case errors.Is(err, ErrRoomLocked):
+		return http.StatusForbidden, "room locked"
+	case errors.Is(err, ErrRoomFull):
+		return http.StatusServiceUnavailable, "room full"
+	case errors.Is(err, ErrRoomBusy):
+		return http.StatusServiceUnavailable, "room busy"
+	default:
+		return http.StatusNotFound, "room not available"
 	}
-	c.sendJSON(welcome)
+}
 
-	join := protocol.StateMessage{
-		Type:         "peer-joined",
-		ID:           c.id,
-		Peers:        peers,
-		Broadcasting: broadcasting,
-		Usernames:    usernames,
+// queryBool parses an optional boolean query parameter; absent or malformed is nil.
+func queryBool(r *http.Request, key string) *bool {
+	v, err := strconv.ParseBool(r.URL.Query().Get(key))
+	if err != nil {
+		return nil
 	}
-	h.broadcast(join, c.id)
-	return nil
+	return &v
 }
 
-func (h *Hub) unregister(c *client) {
-	ctx := context.Background()
-
-	h.mu.Lock()
-	delete(h.clients, c.id)
-	h.mu.Unlock()
-
-	if err := h.presence.RemovePeer(ctx, c.id); err != nil {
-		h.logger.Printf("presence remove: %v", err)
+// SetPaused pauses or resumes signal/chat fanout for the room. While paused those
+// frames are dropped; presence, usernames and broadcast state keep working.
+// Peers are told via a "fanout-paused" message.
+func (h *Hub) SetPaused(paused bool) {
+	if h.paused.Swap(paused) == paused {
+		return
 	}
+	h.logger.Printf("ws: fanout paused=%v", paused)
+	h.broadcast(protocol.StateMessage{Type: "fanout-paused", Enabled: &paused}, "")
+}
 
-	if h.broadcasts != nil {
-		if err := h.broadcasts.RemovePeer(ctx, c.id); err != nil {
-			h.logger.Printf("broadcast state remove: %v", err)
-		}
-	}
-	if h.usernames != nil {
-		if err := h.usernames.RemovePeer(ctx, c.id); err != nil {
-			h.logger.Printf("username state remove: %v", err)
-		}
+// SetLocked locks or unlocks the room. A locked room refuses new joins (ServeWS
+// answers 403, Accept returns ErrRoomLocked) while connected peers stay, and may
+// reconnect (see HasPeer). Peers are told via a "room-locked" message. The lock
+// lifts by itself once the room empties.
+func (h *Hub) SetLocked(locked bool) {
+	if h.locked.Swap(locked) == locked {
+		return
 	}
+	h.logger.Printf("ws: room locked=%v", locked)
+	h.broadcast(protocol.StateMessage{Type: "room-locked", Enabled: &locked}, "")
+}
 
-	peers, broadcasting, usernames := h.snapshot(ctx)
+// Locked reports whether the room refuses new joins.
+func (h *Hub) Locked() bool {
+	return h.locked.Load()
+}
 
-	leave := protocol.StateMessage{
-		Type:         "peer-left",
-		ID:           c.id,
-		Peers:        peers,
-		Broadcasting: broadcasting,
-		Usernames:    usernames,
+// full reports whether a join by id would exceed MaxPeers. A peer reconnecting
+// under an ID that is still connected replaces itself, so it always fits.
+func (h *Hub) full(id string) bool {
+	if h.maxPeers <= 0 {
+		return false
 	}
-	h.broadcast(leave, c.id)
-	h.logger.Printf("ws: unregistered %s (peers=%d broadcasting=%d)", c.id, len(peers), len(broadcasting))
-
-	if len(peers) == 0 && h.onEmpty != nil {
-		h.onEmpty()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if _, ok := h.clients[id]; ok && id != "" {
+		return false
 	}
+	return len(h.clients) >= h.maxPeers
 }
 
-func (h *Hub) broadcast(msg interface{}, skipID string) {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		h.logger.Printf("marshal broadcast: %v", err)
-		return
+// HasPeer reports whether id is one of the room's peers on this hub: connected, or
+// inside LeaveGrace after a disconnect. Such a peer may rejoin a locked room.
+func (h *Hub) HasPeer(id string) bool {
+	if id == "" {
+		return false
 	}
-
 	h.mu.RLock()
 	defer h.mu.RUnlock()
+	return h.clients[id] != nil || h.pendingLeaves[id] != nil
+}
 
-	for id, cl := range h.clients {
-		if id == skipID {
-			continue
+// isOwner reports whether c is the room's owner: its longest-present peer. When the
+// presence store tracks join order that is decided room-wide, so peers on other
+// instances count too; otherwise it is the longest-connected real peer here.
+func (h *Hub) isOwner(c *client) bool {
+	if ordered, ok := h.presence.(presence.OrderedStore); ok {
+		oldest, err := ordered.Oldest(context.Background())
+		if err == nil && oldest != "" {
+			return oldest == c.id
 		}
-		select {
-		case cl.send <- data:
-		default:
-			h.logger.Printf("client send buffer full for %s, dropping message", id)
+		if err != nil {
+			h.logger.Printf("presence join order: %v", err)
+		}
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, other := range h.clients {
+		if other.conn != nil && other.seq < c.seq {
+			return false
 		}
 	}
+	return true
 }
 
-func (h *Hub) handleInbound(c *client, msg protocol.InboundMessage) {
-	h.logger.Printf("ws: inbound type=%s from=%s to=%s enabled=%v", msg.Type, c.id, msg.To, msg.Enabled)
-	switch msg.Type {
-	case "signal":
-		if msg.To == "" || len(msg.Data) == 0 {
-			return
+// Paused reports whether signal/chat fanout is paused.
+func (h *Hub) Paused() bool {
+	return h.paused.Load()
+}
+
+// SweepGhosts removes presence entries with no matching connection on this hub (and
+// no pending LeaveGrace or leave in progress), e.g. left behind by a crash
+// mid-leave, and announces each with peer-left so clients correct their rosters. Callers must only sweep rooms no
+// other instance serves, since their peers are in the same presence set. It returns
+// the removed IDs.
+func (h *Hub) SweepGhosts(ctx context.Context) ([]string, error) {
+	// Read presence first: register adds to clients before presence, so a peer
+	// joining concurrently is never mistaken for a ghost.
+	peers, err := h.presence.Peers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	h.mu.RLock()
+	var ghosts []string
+	for _, id := range peers {
+		if h.clients[id] == nil && h.pendingLeaves[id] == nil && h.leaving[id] == 0 {
+			ghosts = append(ghosts, id)
 		}
-		h.forwardSignal(c.id, msg.To, msg.Data)
-	case "broadcast":
-		if msg.Enabled == nil || h.broadcasts == nil {
-			return
+	}
+	h.mu.RUnlock()
+	for _, id := range ghosts {
+		if err := h.presence.RemovePeer(ctx, id); err != nil {
+			return nil, err
 		}
-		h.updateBroadcast(c.id, *msg.Enabled)
-	case "set-username":
-		if h.usernames == nil {
-			return
+		if h.broadcasts != nil {
+			if err := h.broadcasts.RemovePeer(ctx, id); err != nil {
+				h.logger.Printf("broadcast state remove: %v", err)
+			}
 		}
-		username := strings.TrimSpace(msg.Username)
-		ctx := context.Background()
-		if err := h.usernames.SetUsername(ctx, c.id, username); err != nil {
-			h.logger.Printf("username state set username: %v", err)
+		if h.usernames != nil {
+			if err := h.usernames.RemovePeer(ctx, id); err != nil {
+				h.logger.Printf("username state remove: %v", err)
+			}
 		}
-		h.publishPresence(ctx, c.id, "usernames")
-	default:
-		h.logger.Printf("unknown message type from %s: %s", c.id, msg.Type)
 	}
+	if len(ghosts) == 0 {
+		return nil, nil
+	}
+	// One sweep is one roster change: every peer-left below carries the same version.
+	h.rosterVersion.Add(1)
+	st := h.snapshot(ctx)
+	for _, id := range ghosts {
+		leave := st.message("peer-left", id)
+		diff := protocol.StateMessage{Type: "peer-left", ID: id, Removed: []string{id}, PeerCount: len(st.peers), StateVersion: st.version}
+		h.broadcastVersioned(leave, diff, "")
+		h.emit(EventLeave, id)
+	}
+	h.logger.Printf("ws: swept %d ghost peers (peers=%d)", len(ghosts), len(st.peers))
+	return ghosts, nil
 }
 
-func (h *Hub) forwardSignal(from, to string, payload json.RawMessage) {
+// ClientCount returns the number of connections currently held in memory by this hub.
+func (h *Hub) ClientCount() int {
 	h.mu.RLock()
-	target := h.clients[to]
-	h.mu.RUnlock()
-	if target == nil {
-		h.logger.Printf("ws: forward signal target missing %s -> %s", from, to)
-		return
-	}
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
 
-	msg := protocol.SignalMessage{
-		Type: "signal",
-		From: from,
-		To:   to,
-		Data: payload,
+// ClientIDs returns the IDs of the clients connected to this hub, sorted.
+func (h *Hub) ClientIDs() []string {
+	h.mu.RLock()
+	ids := make([]string, 0, len(h.clients))
+	for id := range h.clients {
+		ids = append(ids, id)
 	}
-	target.sendJSON(msg)
+	h.mu.RUnlock()
+	sort.Strings(ids)
+	return ids
 }
 
-func (h *Hub) updateBroadcast(id string, enabled bool) {
-	ctx := context.Background()
-	if err := h.broadcasts.SetBroadcast(ctx, id, enabled); err != nil {
-		h.logger.Printf("broadcast state update: %v", err)
+// QueueDepth is a connection's outbound backlog: frames waiting on the state lane
+// (Send) and the relayed-signaling lane (Signal), with each lane's capacity. A queue
+// near capacity means the client is falling behind and frames will soon be dropped.
+type QueueDepth struct {
+	Send      int `json:"send"`
+	SendCap   int `json:"sendCap"`
+	Signal    int `json:"signal"`
+	SignalCap int `json:"signalCap"`
+}
+
+// QueueDepths samples every connection's outbound queues, keyed by peer ID. The
+// MetricQueueDepthMax gauge spans every hub sharing a Stats sink, so whoever owns
+// the hubs reports it (e.g. from the deepest queue across them).
+func (h *Hub) QueueDepths() map[string]QueueDepth {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make(map[string]QueueDepth, len(h.clients))
+	for id, c := range h.clients {
+		out[id] = QueueDepth{Send: len(c.send), SendCap: cap(c.send), Signal: len(c.signal), SignalCap: cap(c.signal)}
 	}
-	h.logger.Printf("ws: broadcast state id=%s enabled=%v", id, enabled)
+	return out
+}
 
-	peers, broadcasting, usernames := h.snapshot(ctx)
-	state := protocol.StateMessage{
-		Type:         "broadcast-state",
-		ID:           id,
-		Enabled:      &enabled,
-		Peers:        peers,
-		Broadcasting: broadcasting,
-		Usernames:    usernames,
+// sessionPeers returns the connected clients that duplicate a joiner: the one
+// already holding id (e.g. another tab sharing a cookie identity) and any other
+// sharing session.
+func (h *Hub) sessionPeers(session, id string) []*client {
+	if h.dupSessions != "reject" && h.dupSessions != "replace" {
+		return nil
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var out []*client
+	for _, c := range h.clients {
+		if c.id == id || (session != "" && c.session == session) {
+			out = append(out, c)
+		}
 	}
-	h.broadcast(state, "")
+	return out
 }
 
-func (h *Hub) publishPresence(ctx context.Context, id string, eventType string) {
-	peers, broadcasting, usernames := h.snapshot(ctx)
-	state := protocol.StateMessage{
-		Type:         eventType,
-		ID:           id,
-		Peers:        peers,
-		Broadcasting: broadcasting,
-		Usernames:    usernames,
+// replaceSession tells prev a newer connection took its place and closes it once
+// the notice is flushed. Callers hold h.mu with prev still in h.clients, which keeps
+// its send lane open.
+func (h *Hub) replaceSession(prev *client) {
+	prev.sendJSON(protocol.ErrorMessage{Type: "error", Reason: "session_replaced"})
+	if prev.conn == nil {
+		// Synthetic peers have no connection to close.
+		prev.cancel()
+		return
 	}
-	h.broadcast(state, "")
+	prev.close(websocket.CloseNormalClosure, "session_replaced")
 }
 
-func (c *client) readPump(h *Hub) {
+// Accept registers an already-upgraded WebSocket connection (useful when auth/guards are handled elsewhere).
+func (h *Hub) Accept(conn *websocket.Conn, opts ConnOptions) error {
+	// Until readPump takes the connection, OnClose is ours to run.
+	handedOff := false
 	defer func() {
-		h.unregister(c)
-		c.conn.Close()
-		close(c.send)
-		c.cancel()
+		if !handedOff && opts.OnClose != nil {
+			opts.OnClose()
+		}
 	}()
-
-	c.conn.SetReadLimit(defaultReadLimit)
-	_ = c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-	c.conn.SetPongHandler(func(string) error {
-		_ = c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
-		return nil
-	})
-
-	for {
-		select {
-		case <-c.ctx.Done():
-			return
-		default:
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := h.admit(ctx, opts.ID); err != nil {
+		var frame []byte
+		switch {
+		case errors.Is(err, ErrRoomLocked):
+			frame = websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "room_locked")
+		case errors.Is(err, ErrRoomFull):
+			frame = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "room_full")
+		case errors.Is(err, ErrRoomBusy):
+			frame = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "room_busy")
 		}
-		_, data, err := c.conn.ReadMessage()
-		if err != nil {
-			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
-				return
-			}
-			if !errors.Is(err, websocket.ErrCloseSent) {
-				h.logger.Printf("read error from %s: %v", c.id, err)
+		if frame != nil {
+			_ = conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(h.writeTimeout))
+		}
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	id := opts.ID
+	if id == "" {
+		id = uuid.NewString()
+	}
+	version := opts.ProtocolVersion
+	if version < protocol.VersionFull || version > protocol.Version {
+		version = protocol.VersionFull
+	}
+	username, ok := NormalizeUsername(opts.Username)
+	if !ok {
+		username = ""
+	}
+	session := strings.TrimSpace(opts.Session)
+	if len(session) > maxSessionLength {
+		session = ""
+	}
+	if dups := h.sessionPeers(session, id); len(dups) > 0 {
+		switch h.dupSessions {
+		case "reject":
+			h.logger.Printf("ws: rejecting %s, session already in the room as %s", id, dups[0].id)
+			_ = conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "duplicate_session"),
+				time.Now().Add(h.writeTimeout))
+			cancel()
+			return ErrDuplicateSession
+		case "replace":
+			h.mu.RLock()
+			for _, prev := range dups {
+				if prev.id == id || h.clients[prev.id] != prev {
+					continue // register swaps a same-ID connection out itself
+				}
+				h.logger.Printf("ws: %s replaces %s from the same session", id, prev.id)
+				h.replaceSession(prev)
 			}
-			return
+			h.mu.RUnlock()
 		}
+	}
+	c := &client{
+		id:            id,
+		conn:          conn,
+		send:          make(chan []byte, 32),
+		signal:        make(chan []byte, 64),
+		ctx:           ctx,
+		cancel:        cancel,
+		onClose:       opts.OnClose,
+		version:       version,
+		username:      username,
+		connectedAt:   time.Now(),
+		lifetime:      h.connLifetime(),
+		pongTimeout:   h.pongTimeout,
+		compressAbove: h.compressAbove,
+		writeTimeout:  h.writeTimeout,
+		signalWrite:   h.signalWrite,
+		slowWrite:     h.slowWrite,
+		maxSlow:       h.maxSlow,
+		stableID:      opts.ID != "",
+		session:       session,
+		audio:         opts.Audio,
+		video:         opts.Video,
+		preferTCP:     opts.PreferTCP,
+		reopened:      opts.Reopened,
+		kick:          make(chan closeFrame, 1),
+		writeDone:     make(chan struct{}),
+	}
 
-		var msg protocol.InboundMessage
-		if err := json.Unmarshal(data, &msg); err != nil {
-			h.logger.Printf("bad payload from %s: %v", c.id, err)
-			continue
+	// Start writing before registering so the welcome is flushed as soon as it is queued.
+	go c.writePump(h)
+	if err := h.register(ctx, c); err != nil {
+		if errors.Is(err, ErrJoinFailed) || errors.Is(err, ErrDuplicateSession) || errors.Is(err, ErrRoomBusy) {
+			// Give writePump a chance to flush the error reply and close frame.
+			select {
+			case <-c.writeDone:
+			case <-time.After(h.writeTimeout):
+			}
+		}
+		cancel()
+		return err
+	}
+
+	handedOff = true
+	go c.readPump(h)
+	return nil
+}
+
+// roomState is a point-in-time view of the room assembled from the stores.
+type roomState struct {
+	version       int64
+	peers         []string
+	broadcasting  []string
+	usernames     map[string]string
+	broadcastMeta map[string]json.RawMessage
+	peerMeta      map[string]json.RawMessage
+}
+
+// message builds a StateMessage of the given type carrying the full room state.
+func (st roomState) message(eventType, id string) protocol.StateMessage {
+	return protocol.StateMessage{
+		Type:          eventType,
+		ID:            id,
+		Peers:         st.peers,
+		Broadcasting:  st.broadcasting,
+		Usernames:     st.usernames,
+		BroadcastMeta: st.broadcastMeta,
+		PeerMeta:      st.peerMeta,
+		PeerCount:     len(st.peers),
+		StateVersion:  st.version,
+	}
+}
+
+// Watch returns a channel that receives a value after the hub tells its peers about
+// a change (joins, leaves, names, broadcast toggles, chat...), so observers can
+// refresh their Snapshot instead of polling. Notifications coalesce: a watcher that
+// falls behind gets one value for many changes. Call stop once done watching.
+func (h *Hub) Watch() (changed <-chan struct{}, stop func()) {
+	ch := make(chan struct{}, 1)
+	h.watchMu.Lock()
+	h.watchers[ch] = struct{}{}
+	h.watchMu.Unlock()
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			h.watchMu.Lock()
+			delete(h.watchers, ch)
+			h.watchMu.Unlock()
+		})
+	}
+}
+
+func (h *Hub) notifyWatchers() {
+	h.watchMu.Lock()
+	defer h.watchMu.Unlock()
+	for ch := range h.watchers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Snapshot returns the room's current state as a "snapshot" message without joining
+// the room, for observers such as dashboards.
+func (h *Hub) Snapshot(ctx context.Context) protocol.StateMessage {
+	return h.snapshot(ctx).message("snapshot", "")
+}
+
+func (h *Hub) snapshot(ctx context.Context) roomState {
+	return h.snapshotFor(ctx, "")
+}
+
+// snapshotFor is snapshot as seen by peer self: held-back joiners (see
+// client.joinHeld) other than self are left out until they are announced.
+func (h *Hub) snapshotFor(ctx context.Context, self string) roomState {
+	// Read the version first so the roster is never older than the version it carries.
+	st := roomState{version: h.rosterVersion.Load()}
+	var err error
+	st.peers, err = h.presence.Peers(ctx)
+	presenceOK := err == nil
+	if err != nil {
+		h.logger.Printf("presence peers error: %v", err)
+	}
+	if held := h.heldPeers(self); len(held) > 0 {
+		peers := st.peers[:0]
+		for _, id := range st.peers {
+			if !held[id] {
+				peers = append(peers, id)
+			}
+		}
+		st.peers = peers
+	}
+
+	if h.broadcasts != nil {
+		st.broadcasting, err = h.broadcasts.Broadcasting(ctx)
+		if err != nil {
+			h.logger.Printf("broadcast state error: %v", err)
+		}
+		if metaStore, ok := h.broadcasts.(broadcastMetaStore); ok {
+			st.broadcastMeta, err = metaStore.BroadcastMeta(ctx)
+			if err != nil {
+				h.logger.Printf("broadcast meta state error: %v", err)
+			}
+		}
+	}
+	if h.usernames != nil {
+		st.usernames, err = h.usernames.Usernames(ctx)
+		if err != nil {
+			h.logger.Printf("username state error: %v", err)
+		}
+		if metaStore, ok := h.usernames.(peerMetaStore); ok {
+			st.peerMeta, err = metaStore.PeerMeta(ctx)
+			if err != nil {
+				h.logger.Printf("peer meta state error: %v", err)
+			}
+		}
+		if presenceOK {
+			st.usernames = presentUsernames(st.usernames, st.peers)
+			st.peerMeta = presentPeerMeta(st.peerMeta, st.peers)
+		}
+	}
+	return st
+}
+
+// heldPeers returns the local joiners whose peer-joined is still held back, except self.
+func (h *Hub) heldPeers(self string) map[string]bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var held map[string]bool
+	for id, c := range h.clients {
+		if c.joinHeld && id != self {
+			if held == nil {
+				held = make(map[string]bool)
+			}
+			held[id] = true
+		}
+	}
+	return held
+}
+
+// presentUsernames drops names of peers missing from presence (e.g. a leave that
+// raced the read) so clients never render a ghost name.
+func presentUsernames(names map[string]string, peers []string) map[string]string {
+	if len(names) == 0 {
+		return names
+	}
+	present := presentSet(peers)
+	for id := range names {
+		if !present[id] {
+			delete(names, id)
+		}
+	}
+	return names
+}
+
+// presentPeerMeta is presentUsernames for peer attributes.
+func presentPeerMeta(meta map[string]json.RawMessage, peers []string) map[string]json.RawMessage {
+	if len(meta) == 0 {
+		return meta
+	}
+	present := presentSet(peers)
+	for id := range meta {
+		if !present[id] {
+			delete(meta, id)
+		}
+	}
+	return meta
+}
+
+func presentSet(peers []string) map[string]bool {
+	present := make(map[string]bool, len(peers))
+	for _, id := range peers {
+		present[id] = true
+	}
+	return present
+}
+
+func (h *Hub) register(ctx context.Context, c *client) error {
+	h.mu.Lock()
+	if h.held {
+		// A Hold began after admit passed this join.
+		h.mu.Unlock()
+		c.close(websocket.CloseTryAgainLater, "room_busy")
+		return ErrRoomBusy
+	}
+	// Re-check under the lock: a same-ID join can race Accept's duplicate check.
+	if h.clients[c.id] != nil && h.dupSessions == "reject" {
+		h.mu.Unlock()
+		h.logger.Printf("ws: rejecting %s, already in the room", c.id)
+		c.close(websocket.ClosePolicyViolation, "duplicate_session")
+		return ErrDuplicateSession
+	}
+	h.joinSeq++
+	c.seq = h.joinSeq
+	if h.emptyTimer != nil {
+		h.emptyTimer.Stop()
+		h.emptyTimer = nil
+	}
+	if recent, ok := h.recentNames[c.id]; ok {
+		delete(h.recentNames, c.id)
+		if c.username == "" && time.Now().Before(recent.expires) {
+			c.username = recent.name
+		}
+	}
+	resumed := false
+	if pending := h.pendingLeaves[c.id]; pending != nil {
+		pending.Stop()
+		delete(h.pendingLeaves, c.id)
+		resumed = true
+	}
+	// Hold from the start so no roster or signal reaches c before it is announced;
+	// the holds that actually apply are settled once its name is known.
+	c.joinHeld = !resumed && (h.readyTimeout > 0 || h.requireName)
+	prev := h.clients[c.id]
+	if prev != nil {
+		// An ID has one connection: the newer one wins.
+		h.replaceSession(prev)
+	}
+	h.clients[c.id] = c
+	count := len(h.clients)
+	h.mu.Unlock()
+	if prev != nil {
+		h.logger.Printf("ws: %s replaced, closing previous connection", c.id)
+	}
+	h.stats.IncCounter(MetricJoins)
+	h.stats.SetGauge(MetricClients, float64(count))
+
+	if err := h.presence.AddPeer(ctx, c.id); err != nil {
+		h.logger.Printf("ws: presence add %s: %v", c.id, err)
+		h.rollbackJoin(c, resumed || prev != nil)
+		c.sendError("join_failed")
+		c.close(websocket.CloseTryAgainLater, "join_failed")
+		return fmt.Errorf("%w: %w", ErrJoinFailed, err)
+	}
+	if !resumed {
+		h.rosterVersion.Add(1)
+	}
+	if c.username == "" && !resumed && h.guestPrefix != "" && h.usernames != nil {
+		c.username = h.guestName(ctx)
+	}
+	if c.username != "" && h.usernames != nil {
+		if err := h.usernames.SetUsername(ctx, c.id, c.username); err != nil {
+			h.logger.Printf("username state set connect-time username: %v", err)
+		}
+	}
+	relay, relayChanged := h.reelectRelay()
+
+	iceServers := h.iceServers
+	if h.iceSource != nil {
+		iceServers = h.iceSource()
+	}
+	if c.preferTCP {
+		iceServers = ice.PreferTCPRelays(iceServers)
+	}
+	welcome := protocol.StateMessage{
+		Type:       "welcome",
+		ID:         c.id,
+		ICEServers: iceServers,
+		ICEMode:    h.iceMode,
+		Version:    c.version,
+		Features:   h.features,
+		Relay:      relay,
+		Region:     h.region,
+	}
+	welcome.ICETransportPolicy = h.icePolicy
+	welcome.Topology = h.topology
+	welcome.Locked = h.locked.Load()
+	welcome.RequireName = h.requireName
+	welcome.Reopened = c.reopened
+	welcome.MaxUsernameLength = protocol.MaxUsernameLength
+	if h.deferRoster {
+		c.sendJSON(welcome)
+	}
+
+	st := h.snapshotFor(ctx, c.id)
+	h.logger.Printf("ws: registered %s (peers=%d broadcasting=%d)", c.id, len(st.peers), len(st.broadcasting))
+
+	if h.deferRoster {
+		c.sendJSON(st.message("roster", c.id))
+	} else {
+		welcome.Peers = st.peers
+		welcome.Broadcasting = st.broadcasting
+		welcome.Usernames = st.usernames
+		welcome.BroadcastMeta = st.broadcastMeta
+		welcome.PeerMeta = st.peerMeta
+		welcome.PeerCount = len(st.peers)
+		welcome.StateVersion = st.version
+		c.sendJSON(welcome)
+	}
+	h.replayChat(ctx, c)
+	// Others learn about the joiner only after its own welcome is queued.
+	if relayChanged {
+		h.announceRelay(relay)
+	}
+	if resumed {
+		// Back within LeaveGrace: the room never saw this peer leave.
+		h.logger.Printf("ws: %s resumed within leave grace", c.id)
+		return nil
+	}
+
+	needName := h.requireName && c.username == ""
+	if h.readyTimeout > 0 || needName {
+		h.mu.Lock()
+		if h.clients[c.id] == c {
+			c.needName = needName
+			if h.readyTimeout > 0 {
+				c.readyTimer = time.AfterFunc(h.readyTimeout, func() { h.markReady(c, true) })
+			}
+		}
+		h.mu.Unlock()
+		if needName {
+			c.sendError("name_required")
+		}
+		return nil
+	}
+	h.mu.Lock()
+	c.joinHeld = false // nothing to wait for after all (e.g. given a guest name)
+	h.mu.Unlock()
+	h.announceJoin(st, c)
+	return nil
+}
+
+// rollbackJoin drops c after a failed register so it doesn't linger as a ghost
+// client. wasPresent reports that the room already knew this peer (a resume or a
+// replaced connection), in which case the room is told it left.
+func (h *Hub) rollbackJoin(c *client, wasPresent bool) {
+	h.mu.Lock()
+	if h.clients[c.id] == c {
+		delete(h.clients, c.id)
+	}
+	count := len(h.clients)
+	if wasPresent {
+		h.leaving[c.id]++
+	}
+	h.mu.Unlock()
+	h.stats.SetGauge(MetricClients, float64(count))
+	if wasPresent {
+		h.completeLeave(c)
+		return
+	}
+	if count == 0 {
+		h.roomEmptied()
+	}
+}
+
+// markReady clears the "ready" hold on c's peer-joined; timedOut reports that the
+// ReadyTimeout fallback fired instead.
+func (h *Hub) markReady(c *client, timedOut bool) {
+	h.releaseJoin(c, func() bool {
+		if c.readyTimer == nil {
+			return false
+		}
+		c.readyTimer.Stop()
+		c.readyTimer = nil
+		if timedOut {
+			h.logger.Printf("ws: %s sent no ready within %s, announcing anyway", c.id, h.readyTimeout)
+		}
+		return true
+	})
+}
+
+// markNamed clears the RequireName hold on c's peer-joined once it has a name.
+func (h *Hub) markNamed(c *client) {
+	h.releaseJoin(c, func() bool {
+		if !c.needName {
+			return false
+		}
+		c.needName = false
+		return true
+	})
+}
+
+// releaseJoin runs clear (under mu) to drop one hold on c's peer-joined and announces
+// c once no hold is left.
+func (h *Hub) releaseJoin(c *client, clear func() bool) {
+	h.mu.Lock()
+	if !c.joinHeld || h.clients[c.id] != c || !clear() || c.readyTimer != nil || c.needName {
+		h.mu.Unlock()
+		return
+	}
+	c.joinHeld = false
+	h.mu.Unlock()
+	h.announceJoin(h.snapshot(context.Background()), c)
+}
+
+// awaitingName reports whether c is held back until it sets a name (RequireName).
+func (h *Hub) awaitingName(c *client) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return c.needName
+}
+
+// announceJoin tells the other peers about c.
+func (h *Hub) announceJoin(st roomState, c *client) {
+	join := st.message("peer-joined", c.id)
+	diff := protocol.StateMessage{
+		Type:         "peer-joined",
+		ID:           c.id,
+		Added:        []string{c.id},
+		PeerCount:    len(st.peers),
+		StateVersion: st.version,
+	}
+	if name, ok := st.usernames[c.id]; ok {
+		diff.Usernames = map[string]string{c.id: name}
+	}
+	join.Peer = h.peerEntry(st, c)
+	diff.Peer = join.Peer
+	h.broadcastVersioned(join, diff, c.id)
+	h.emit(EventJoin, c.id)
+}
+
+// peerEntry describes c for its peer-joined message.
+func (h *Hub) peerEntry(st roomState, c *client) *protocol.PeerEntry {
+	entry := &protocol.PeerEntry{
+		ID:       c.id,
+		Username: st.usernames[c.id],
+		Role:     protocol.RoleParticipant,
+		Audio:    c.audio,
+		Video:    c.video,
+		Meta:     st.peerMeta[c.id],
+	}
+	switch {
+	case c.conn == nil:
+		entry.Role = protocol.RoleSynthetic
+	case h.isOwner(c):
+		entry.Role = protocol.RoleOwner
+	}
+	for _, id := range st.broadcasting {
+		if id == c.id {
+			entry.Broadcasting = true
+			break
+		}
+	}
+	return entry
+}
+
+func (h *Hub) unregister(c *client) {
+	h.mu.Lock()
+	if h.clients[c.id] != c {
+		// Superseded by a newer connection with the same ID; its state stays intact.
+		h.mu.Unlock()
+		return
+	}
+	delete(h.clients, c.id)
+	count := len(h.clients)
+	if c.readyTimer != nil {
+		c.readyTimer.Stop()
+		c.readyTimer = nil
+	}
+	if h.leaveGrace > 0 && c.stableID {
+		var timer *time.Timer
+		timer = time.AfterFunc(h.leaveGrace, func() { h.finishLeave(c, &timer) })
+		h.pendingLeaves[c.id] = timer
+		h.mu.Unlock()
+		h.stats.SetGauge(MetricClients, float64(count))
+		h.logger.Printf("ws: %s disconnected, leave deferred %s", c.id, h.leaveGrace)
+		return
+	}
+	h.leaving[c.id]++
+	h.mu.Unlock()
+	h.stats.SetGauge(MetricClients, float64(count))
+	h.completeLeave(c)
+}
+
+// finishLeave runs when a deferred leave's grace expires without a reconnect.
+// timer is read under mu, which also guards its assignment in unregister.
+func (h *Hub) finishLeave(c *client, timer **time.Timer) {
+	h.mu.Lock()
+	if h.pendingLeaves[c.id] != *timer || h.clients[c.id] != nil {
+		// The peer came back (or a newer deferral replaced this one).
+		h.mu.Unlock()
+		return
+	}
+	delete(h.pendingLeaves, c.id)
+	h.leaving[c.id]++
+	h.mu.Unlock()
+	h.completeLeave(c)
+}
+
+// completeLeave removes a departed peer's room state and announces peer-left. The
+// caller counts c.id in leaving (under mu) first; completeLeave uncounts it.
+func (h *Hub) completeLeave(c *client) {
+	defer func() {
+		h.mu.Lock()
+		if h.leaving[c.id]--; h.leaving[c.id] <= 0 {
+			delete(h.leaving, c.id)
+		}
+		h.mu.Unlock()
+	}()
+	ctx := context.Background()
+	h.stats.IncCounter(MetricLeaves)
+
+	if err := h.presence.RemovePeer(ctx, c.id); err != nil {
+		h.logger.Printf("presence remove: %v", err)
+	}
+	h.rosterVersion.Add(1)
+
+	if h.broadcasts != nil {
+		if err := h.broadcasts.RemovePeer(ctx, c.id); err != nil {
+			h.logger.Printf("broadcast state remove: %v", err)
+		}
+	}
+	if h.usernames != nil {
+		h.retainUsername(ctx, c.id)
+		if err := h.usernames.RemovePeer(ctx, c.id); err != nil {
+			h.logger.Printf("username state remove: %v", err)
+		}
+	}
+
+	st := h.snapshot(ctx)
+
+	leave := st.message("peer-left", c.id)
+	diff := protocol.StateMessage{
+		Type:         "peer-left",
+		ID:           c.id,
+		Removed:      []string{c.id},
+		PeerCount:    len(st.peers),
+		StateVersion: st.version,
+	}
+	h.broadcastVersioned(leave, diff, c.id)
+	h.emit(EventLeave, c.id)
+	if relay, changed := h.reelectRelay(); changed && relay != "" {
+		h.announceRelay(relay)
+	}
+	h.logger.Printf("ws: unregistered %s (peers=%d broadcasting=%d)", c.id, len(st.peers), len(st.broadcasting))
+
+	if len(st.peers) == 0 {
+		h.roomEmptied()
+	}
+}
+
+// roomEmptied lifts the room lock and calls OnEmpty, after EmptyGrace when one is
+// set and only if no peer has joined by then.
+func (h *Hub) roomEmptied() {
+	// Nobody is left to unlock the room, so the lock would keep everyone out.
+	if h.locked.Swap(false) {
+		h.logger.Printf("ws: room empty, lifting lock")
+		if h.onLockChange != nil {
+			h.onLockChange(false)
+		}
+	}
+	if h.onEmpty == nil {
+		return
+	}
+	if h.emptyGrace <= 0 {
+		h.onEmpty()
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.emptyTimer != nil {
+		h.emptyTimer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(h.emptyGrace, func() {
+		h.mu.Lock()
+		if h.emptyTimer != timer || len(h.clients) > 0 {
+			// A peer joined (or a newer check replaced this one).
+			h.mu.Unlock()
+			return
+		}
+		h.emptyTimer = nil
+		h.mu.Unlock()
+		h.onEmpty()
+	})
+	h.emptyTimer = timer
+}
+
+type recentName struct {
+	name    string
+	expires time.Time
+}
+
+// retainUsername remembers id's display name for UsernameRetention so a quick
+// reconnect with the same ID restores it. Expired entries are pruned on the way.
+func (h *Hub) retainUsername(ctx context.Context, id string) {
+	if h.nameRetain <= 0 {
+		return
+	}
+	names, err := h.usernames.Usernames(ctx)
+	if err != nil {
+		h.logger.Printf("username state error: %v", err)
+		return
+	}
+	now := time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for peer, recent := range h.recentNames {
+		if now.After(recent.expires) {
+			delete(h.recentNames, peer)
+		}
+	}
+	if name := names[id]; name != "" {
+		h.recentNames[id] = recentName{name: name, expires: now.Add(h.nameRetain)}
+	}
+}
+
+func (h *Hub) broadcast(msg interface{}, skipID string) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		h.logger.Printf("marshal broadcast: %v", err)
+		return
+	}
+	h.fanout(skipID, func(*client) []byte { return data })
+}
+
+// Broadcast marshals msg and sends it to every connected client, e.g. a server
+// announcement. It is for trusted server-side callers only: msg goes out as-is,
+// without the validation applied to client frames, and is not subject to pause.
+// It returns the marshal error, if any, in which case nothing is sent.
+func (h *Hub) Broadcast(msg interface{}) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("signaling: marshal broadcast: %w", err)
+	}
+	h.fanout("", func(*client) []byte { return data })
+	return nil
+}
+
+// broadcastVersioned sends full to legacy clients and diff to clients that negotiated presence diffs.
+func (h *Hub) broadcastVersioned(full, diff interface{}, skipID string) {
+	h.broadcastDiff(full, diff, skipID, protocol.VersionPresenceDiff)
+}
+
+// broadcastDiff sends diff to clients at protocol version since or newer and full to the rest.
+func (h *Hub) broadcastDiff(full, diff interface{}, skipID string, since int) {
+	fullData, err := json.Marshal(full)
+	if err != nil {
+		h.logger.Printf("marshal broadcast: %v", err)
+		return
+	}
+	diffData, err := json.Marshal(diff)
+	if err != nil {
+		h.logger.Printf("marshal broadcast: %v", err)
+		return
+	}
+	h.fanout(skipID, func(cl *client) []byte {
+		if cl.version >= since {
+			return diffData
+		}
+		return fullData
+	})
+}
+
+// hasClientsBelow reports whether any connected client speaks a protocol older than version.
+func (h *Hub) hasClientsBelow(version int) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, cl := range h.clients {
+		if cl.version < version {
+			return true
+		}
+	}
+	return false
+}
+
+// fanout enqueues the payload chosen by pick for every client except skipID,
+// returning how many clients it reached and which ones were dropped.
+func (h *Hub) fanout(skipID string, pick func(*client) []byte) (delivered int, dropped []string) {
+	h.notifyWatchers()
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for id, cl := range h.clients {
+		if id == skipID {
+			continue
+		}
+		select {
+		case cl.send <- pick(cl):
+			delivered++
+		default:
+			h.logger.Printf("client send buffer full for %s, dropping message", id)
+			h.stats.IncCounter(MetricSendDropped)
+			dropped = append(dropped, id)
+		}
+	}
+	return delivered, dropped
+}
+
+func (h *Hub) handleInbound(c *client, msg protocol.InboundMessage) {
+	if msg.From != "" {
+		if msg.From != c.id {
+			h.logger.Printf("ws: ignoring client-supplied from=%q on frame from %s", msg.From, c.id)
+		}
+		msg.From = ""
+	}
+	if !h.inbound.allow(inboundPriority(msg.Type)) {
+		h.stats.IncCounter(MetricInboundShed)
+		c.sendError("overloaded")
+		return
+	}
+	h.logger.Printf("ws: inbound type=%s from=%s to=%s enabled=%v", msg.Type, c.id, msg.To, msg.Enabled)
+	if h.logPayload && len(msg.Data) > 0 {
+		h.logger.Printf("debug: inbound payload from=%s (%d bytes): %s", c.id, len(msg.Data), redactPayload(msg.Data))
+	}
+	switch msg.Type {
+	case "signal", "ice-restart", "broadcast", "chat", "reaction":
+		if h.awaitingName(c) {
+			c.sendError("name_required")
+			return
+		}
+	}
+	switch msg.Type {
+	case "signal":
+		if msg.To == "" || len(msg.Data) == 0 {
+			return
+		}
+		if msg.To == c.id {
+			// Echoing a peer's own offer/candidate back only confuses its negotiation.
+			h.stats.IncCounter(MetricSignalsDropped)
+			c.sendError("self_signal")
+			return
+		}
+		if h.paused.Load() {
+			c.sendError("room_paused")
+			return
+		}
+		h.forwardSignal(c.id, msg.To, msg.Data)
+	case "ice-restart":
+		if msg.To == "" {
+			return
+		}
+		if msg.To == c.id {
+			c.sendError("self_signal")
+			return
+		}
+		if h.paused.Load() {
+			c.sendError("room_paused")
+			return
+		}
+		h.forwardICERestart(c, msg.To)
+	case "broadcast":
+		if msg.Enabled == nil || h.broadcasts == nil {
+			return
+		}
+		if reason := h.updateBroadcast(c.id, *msg.Enabled); reason != "" {
+			c.sendJSON(protocol.ErrorMessage{Type: "broadcast-denied", Reason: reason})
+			return
+		}
+		if *msg.Enabled && len(msg.Meta) > 0 {
+			h.updateBroadcastMeta(c, msg.Meta)
+		}
+	case "media-state":
+		h.updateMediaState(c, msg.Audio, msg.Video)
+	case "broadcast-meta":
+		h.updateBroadcastMeta(c, msg.Meta)
+	case "set-meta":
+		h.updatePeerMeta(c, msg.Meta)
+	case "set-username":
+		if h.usernames == nil {
+			return
+		}
+		username, ok := NormalizeUsername(msg.Username)
+		if !ok {
+			h.logger.Printf("ws: invalid username from %s", c.id)
+			return
+		}
+		if h.renameEvery > 0 {
+			now := time.Now()
+			if now.Sub(c.lastRenameAt) < h.renameEvery {
+				c.sendJSON(protocol.ErrorMessage{Type: "rename-throttled", Reason: "cooldown"})
+				return
+			}
+			c.lastRenameAt = now
+		}
+		ctx := context.Background()
+		if err := h.usernames.SetUsername(ctx, c.id, username); err != nil {
+			h.logger.Printf("username state set username: %v", err)
+		}
+		h.publishPresence(ctx, c.id, "usernames")
+		if username != "" {
+			h.markNamed(c)
+		}
+	case "sync":
+		h.sendSync(c)
+	case "ready":
+		h.markReady(c, false)
+	case "lock":
+		if msg.Locked == nil {
+			return
+		}
+		if !h.isOwner(c) {
+			c.sendError("not_owner")
+			return
+		}
+		if h.locked.Load() == *msg.Locked {
+			return
+		}
+		h.SetLocked(*msg.Locked)
+		if h.onLockChange != nil {
+			h.onLockChange(*msg.Locked)
+		}
+	case "chat":
+		if !h.featureEnabled(protocol.FeatureChat) {
+			h.logger.Printf("ws: chat disabled, dropping message from %s", c.id)
+			return
+		}
+		if h.paused.Load() {
+			c.sendError("room_paused")
+			return
+		}
+		h.relayChat(c, msg.ID, msg.Text)
+	case "reaction":
+		if !h.featureEnabled(protocol.FeatureReactions) {
+			h.logger.Printf("ws: reactions disabled, dropping reaction from %s", c.id)
+			return
+		}
+		if h.paused.Load() {
+			c.sendError("room_paused")
+			return
+		}
+		h.relayReaction(c, msg.Text)
+	case "recording":
+		if !h.featureEnabled(protocol.FeatureRecording) {
+			// Unlike other dropped frames, say so: the sender must not record while
+			// the room never learns about it.
+			h.logger.Printf("ws: recording disabled, dropping recording state from %s", c.id)
+			c.sendError("recording_disabled")
+			return
+		}
+		if msg.Enabled == nil {
+			return
+		}
+		h.broadcast(protocol.StateMessage{Type: "recording", ID: c.id, Enabled: msg.Enabled}, c.id)
+	default:
+		h.logger.Printf("unknown message type from %s: %s", c.id, msg.Type)
+	}
+}
+
+func (h *Hub) forwardSignal(from, to string, payload json.RawMessage) {
+	h.mu.RLock()
+	target := h.clients[to]
+	if target != nil && target.joinHeld {
+		// Not announced yet: to the room it is not there.
+		target = nil
+	}
+	h.mu.RUnlock()
+	if target == nil {
+		h.logger.Printf("ws: forward signal target missing %s -> %s", from, to)
+		h.stats.IncCounter(MetricSignalsDropped)
+		return
+	}
+	h.stats.IncCounter(MetricSignalsForwarded)
+	h.stats.ObserveHistogram(MetricSignalBytes, float64(len(payload)))
+
+	msg := protocol.SignalMessage{
+		Type: "signal",
+		From: from,
+		To:   to,
+		Data: payload,
+	}
+	target.sendSignalJSON(msg)
+}
+
+// forwardICERestart relays a restart request to its target; the sender is told when
+// the target is gone so it can tear the connection down instead of waiting.
+func (h *Hub) forwardICERestart(c *client, to string) {
+	// Enqueue under the lock so the target can't close its send lane in between.
+	h.mu.RLock()
+	target := h.clients[to]
+	found := target != nil && !target.joinHeld
+	if found {
+		target.sendJSON(protocol.ICERestartMessage{Type: "ice-restart", From: c.id, To: to})
+	}
+	h.mu.RUnlock()
+	if !found {
+		h.logger.Printf("ws: ice-restart target missing %s -> %s", c.id, to)
+		h.stats.IncCounter(MetricSignalsDropped)
+		c.sendError("peer_not_found")
+		return
+	}
+	h.stats.IncCounter(MetricICERestarts)
+}
+
+// updateBroadcast records the broadcast toggle and fans out the new state. When
+// enabling fails it changes nothing and returns the broadcast-denied reason:
+// "max_broadcasters" past MaxBroadcasters, "broadcast_failed" if the store errs.
+func (h *Hub) updateBroadcast(id string, enabled bool) (denied string) {
+	ctx := context.Background()
+	if enabled && h.maxBcast > 0 {
+		admitted, err := h.setBroadcastCapped(ctx, id)
+		if err != nil {
+			h.logger.Printf("broadcast state update: %v", err)
+			return "broadcast_failed"
+		}
+		if !admitted {
+			h.logger.Printf("ws: broadcast denied id=%s (max=%d)", id, h.maxBcast)
+			return "max_broadcasters"
+		}
+	} else if err := h.broadcasts.SetBroadcast(ctx, id, enabled); err != nil {
+		h.logger.Printf("broadcast state update: %v", err)
+		if enabled {
+			return "broadcast_failed"
+		}
+	}
+	h.logger.Printf("ws: broadcast state id=%s enabled=%v", id, enabled)
+
+	seq := h.bcastSequence.Add(1)
+	diff := protocol.StateMessage{Type: "broadcast-state", ID: id, Enabled: &enabled, Seq: seq}
+	if h.hasClientsBelow(protocol.VersionBroadcastDiff) {
+		state := h.snapshot(ctx).message("broadcast-state", id)
+		state.Enabled = &enabled
+		state.Seq = seq
+		h.broadcastDiff(state, diff, "", protocol.VersionBroadcastDiff)
+	} else {
+		// Every client keeps its own roster: skip the store reads for the full state.
+		h.broadcast(diff, "")
+	}
+	if enabled {
+		h.emit(EventBroadcastStart, id)
+	} else {
+		h.emit(EventBroadcastStop, id)
+	}
+	return ""
+}
+
+// updateMediaState relays the sender's audio/video state to the room and, when
+// AutoBroadcastOff is set and both are off, clears its broadcast flag.
+func (h *Hub) updateMediaState(c *client, audio, video *bool) {
+	if audio == nil && video == nil {
+		return
+	}
+	state := protocol.MediaStateMessage{Type: "media-state", ID: c.id}
+	if audio != nil {
+		state.Audio = *audio
+	}
+	if video != nil {
+		state.Video = *video
+	}
+	h.broadcast(state, c.id)
+
+	if !h.autoBcastOff || h.broadcasts == nil || audio == nil || video == nil || *audio || *video {
+		return
+	}
+	broadcasting, err := h.broadcasts.Broadcasting(context.Background())
+	if err != nil {
+		h.logger.Printf("broadcast state read: %v", err)
+		return
+	}
+	for _, id := range broadcasting {
+		if id == c.id {
+			h.logger.Printf("ws: all media off for %s, clearing broadcast", c.id)
+			h.updateBroadcast(c.id, false)
+			return
+		}
+	}
+}
+
+// updateBroadcastMeta stores the sender's stream metadata (if the store supports it)
+// and republishes the broadcast state so peers can pre-size tiles.
+func (h *Hub) updateBroadcastMeta(c *client, meta json.RawMessage) {
+	metaStore, ok := h.broadcasts.(broadcastMetaStore)
+	if !ok || len(meta) == 0 {
+		return
+	}
+	if len(meta) > maxBroadcastMeta || !json.Valid(meta) {
+		c.sendError("invalid_broadcast_meta")
+		return
+	}
+	ctx := context.Background()
+	if err := metaStore.SetBroadcastMeta(ctx, c.id, meta); err != nil {
+		h.logger.Printf("broadcast meta update: %v", err)
+		return
+	}
+	h.publishPresence(ctx, c.id, "broadcast-meta")
+}
+
+// updatePeerMeta stores the sender's attributes (a flat object of short string
+// values, {} to clear) and republishes the room state as "peer-meta".
+func (h *Hub) updatePeerMeta(c *client, raw json.RawMessage) {
+	metaStore, ok := h.usernames.(peerMetaStore)
+	if !ok || len(raw) == 0 {
+		return
+	}
+	var meta map[string]string
+	if len(raw) > maxPeerMeta || json.Unmarshal(raw, &meta) != nil || len(meta) > maxPeerMetaKeys {
+		c.sendError("invalid_meta")
+		return
+	}
+	for key := range meta {
+		if key == "" || len(key) > maxPeerMetaKey {
+			c.sendError("invalid_meta")
+			return
+		}
+	}
+	var stored []byte
+	if len(meta) > 0 {
+		// Re-encode so only the validated map is stored, never the raw frame.
+		stored, _ = json.Marshal(meta)
+	}
+	ctx := context.Background()
+	if err := metaStore.SetPeerMeta(ctx, c.id, stored); err != nil {
+		h.logger.Printf("peer meta update: %v", err)
+		return
+	}
+	h.publishPresence(ctx, c.id, "peer-meta")
+}
+
+func (h *Hub) setBroadcastCapped(ctx context.Context, id string) (bool, error) {
+	if capped, ok := h.broadcasts.(cappedBroadcastStore); ok {
+		return capped.SetBroadcastCapped(ctx, id, h.maxBcast)
+	}
+	current, err := h.broadcasts.Broadcasting(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, b := range current {
+		if b == id {
+			return true, nil
+		}
+	}
+	if len(current) >= h.maxBcast {
+		return false, nil
+	}
+	return true, h.broadcasts.SetBroadcast(ctx, id, true)
+}
+
+func (h *Hub) publishPresence(ctx context.Context, id string, eventType string) {
+	h.broadcast(h.snapshot(ctx).message(eventType, id), "")
+}
+
+// SetUsernames stores several display names at once (e.g. when importing a session)
+// and publishes a single "usernames" update. Names must already be validated with
+// NormalizeUsername.
+func (h *Hub) SetUsernames(ctx context.Context, names map[string]string) error {
+	if h.usernames == nil {
+		return errors.New("usernames not supported")
+	}
+	if bulk, ok := h.usernames.(bulkUsernameStore); ok {
+		if err := bulk.SetUsernames(ctx, names); err != nil {
+			return err
+		}
+	} else {
+		for id, name := range names {
+			if err := h.usernames.SetUsername(ctx, id, name); err != nil {
+				return err
+			}
+		}
+	}
+	h.publishPresence(ctx, "", "usernames")
+	return nil
+}
+
+// guestName picks "<GuestPrefix>-NNNN" not already used in the room, widening to
+// six digits if four keep colliding.
+func (h *Hub) guestName(ctx context.Context) string {
+	taken := make(map[string]bool)
+	names, err := h.usernames.Usernames(ctx)
+	if err != nil {
+		h.logger.Printf("username state error: %v", err)
+	}
+	for _, name := range names {
+		taken[name] = true
+	}
+	var name string
+	for attempt := 0; attempt < 16; attempt++ {
+		if attempt < 8 {
+			name = fmt.Sprintf("%s-%04d", h.guestPrefix, rand.Intn(10000))
+		} else {
+			name = fmt.Sprintf("%s-%06d", h.guestPrefix, rand.Intn(1000000))
+		}
+		if !taken[name] {
+			break
+		}
+	}
+	return name
+}
+
+// decodeStrict re-decodes an inbound frame, failing on fields InboundMessage lacks.
+func decodeStrict(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var msg protocol.InboundMessage
+	return dec.Decode(&msg)
+}
+
+// NormalizeUsername trims name and reports whether it is an acceptable display name:
+// at most protocol.MaxUsernameLength characters and free of control characters.
+// An empty name is valid and clears the username.
+func NormalizeUsername(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > protocol.MaxUsernameLength || !utf8.ValidString(name) {
+		return "", false
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return "", false
+		}
+	}
+	return name, true
+}
+
+// ValidPeerID reports whether id is usable as a peer ID: 1 to maxLen bytes (0 = 64)
+// of ASCII letters, digits and "-", "_", ".", ":".
+func ValidPeerID(id string, maxLen int) bool {
+	if maxLen <= 0 {
+		maxLen = maxPeerIDLength
+	}
+	if id == "" || len(id) > maxLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch b := id[i]; {
+		case b >= 'a' && b <= 'z', b >= 'A' && b <= 'Z', b >= '0' && b <= '9':
+		case b == '-', b == '_', b == '.', b == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// featureEnabled reports whether a room feature is on; unset features default to enabled.
+func (h *Hub) featureEnabled(name string) bool {
+	if enabled, ok := h.features[name]; ok {
+		return enabled
+	}
+	return true
+}
+
+// relayChat fans a chat message out to the room (sender included) and, when the
+// sender supplied a message ID, acknowledges it with per-recipient delivery results.
+func (h *Hub) relayChat(c *client, msgID, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" || len(text) > maxChatLength {
+		return
+	}
+	data, err := json.Marshal(protocol.ChatMessage{
+		Type: "chat",
+		From: c.id,
+		Text: text,
+		TS:   time.Now().UnixMilli(),
+	})
+	if err != nil {
+		h.logger.Printf("marshal chat: %v", err)
+		return
+	}
+	delivered, dropped := h.fanout("", func(*client) []byte { return data })
+	h.emit(EventChat, c.id)
+	if h.chatHistory != nil {
+		if err := h.chatHistory.Append(context.Background(), data); err != nil {
+			h.logger.Printf("chat history append: %v", err)
+		}
+	}
+	if msgID == "" {
+		return
+	}
+	c.sendJSON(protocol.AckMessage{
+		Type:      "ack",
+		ID:        msgID,
+		Delivered: delivered,
+		Dropped:   dropped,
+		Partial:   len(dropped) > 0,
+	})
+}
+
+// relayReaction sends a reaction to everyone, the sender included, like chat.
+func (h *Hub) relayReaction(c *client, emoji string) {
+	emoji = strings.TrimSpace(emoji)
+	if emoji == "" || len(emoji) > maxReactionLength {
+		return
+	}
+	data, err := json.Marshal(protocol.ReactionMessage{
+		Type:  "reaction",
+		From:  c.id,
+		Emoji: emoji,
+		TS:    time.Now().UnixMilli(),
+	})
+	if err != nil {
+		h.logger.Printf("marshal reaction: %v", err)
+		return
+	}
+	h.fanout("", func(*client) []byte { return data })
+}
+
+// replayChat sends the room's persisted chat history to a joiner.
+func (h *Hub) replayChat(ctx context.Context, c *client) {
+	if h.chatHistory == nil || !h.featureEnabled(protocol.FeatureChat) {
+		return
+	}
+	stored, err := h.chatHistory.Recent(ctx)
+	if err != nil {
+		h.logger.Printf("chat history read: %v", err)
+		return
+	}
+	if len(stored) == 0 {
+		return
+	}
+	msgs := make([]json.RawMessage, len(stored))
+	for i, m := range stored {
+		msgs[i] = m
+	}
+	c.sendJSON(protocol.ChatHistoryMessage{Type: "chat-history", Messages: msgs})
+}
+
+// reelectRelay keeps the earliest-joined connected client as relay, reporting whether it changed.
+func (h *Hub) reelectRelay() (relay string, changed bool) {
+	if !h.electRelay {
+		return "", false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[h.relay]; ok {
+		return h.relay, false
+	}
+	var earliest *client
+	for _, cl := range h.clients {
+		if earliest == nil || cl.seq < earliest.seq {
+			earliest = cl
+		}
+	}
+	next := ""
+	if earliest != nil {
+		next = earliest.id
+	}
+	changed = next != h.relay
+	h.relay = next
+	return next, changed
+}
+
+func (h *Hub) announceRelay(id string) {
+	h.logger.Printf("ws: relay elected %s", id)
+	h.broadcast(protocol.StateMessage{Type: "relay-elected", ID: id, Relay: id}, "")
+}
+
+var icePasswordPattern = regexp.MustCompile(`(a=ice-(?:pwd|ufrag):)[^\\\r\n"]+`)
+
+// redactPayload masks ICE credentials in SDP and truncates the payload to maxLoggedPayload bytes.
+func redactPayload(data []byte) string {
+	out := icePasswordPattern.ReplaceAll(data, []byte("${1}[redacted]"))
+	if len(out) > maxLoggedPayload {
+		return string(out[:maxLoggedPayload]) + "...(truncated)"
+	}
+	return string(out)
+}
+
+// sendSync replies to a single client with a full state snapshot.
+func (h *Hub) sendSync(c *client) {
+	c.sendJSON(h.snapshotFor(context.Background(), c.id).message("sync", c.id))
+}
+
+func (c *client) readPump(h *Hub) {
+	c.closeCode = websocket.CloseAbnormalClosure
+	defer func() {
+		h.unregister(c)
+		c.conn.Close()
+		close(c.send)
+		c.cancel()
+		if h.onLeave != nil {
+			h.onLeave(c.id, c.closeCode, c.closeReason)
+		}
+		if c.onClose != nil {
+			c.onClose()
+		}
+	}()
+
+	// readMessage enforces maxMessage itself so it can reply with a clear reason; the
+	// connection limit is only a backstop against messages too large to bother draining.
+	c.conn.SetReadLimit(2 * h.maxMessage)
+	_ = c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.conn.SetPongHandler(func(string) error {
+		c.pingPending.Store(false)
+		_ = c.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		default:
+		}
+		data, err := c.readMessage(h.maxMessage)
+		if errors.Is(err, errMessageTooBig) {
+			if !c.tooBig {
+				c.tooBig = true
+				h.logger.Printf("ws: %s sent a message over %d bytes (fragments included), closing", c.id, h.maxMessage)
+				c.sendJSON(protocol.ErrorMessage{Type: "error", Reason: "message_too_big"})
+				c.close(websocket.CloseMessageTooBig, "message_too_big")
+			}
+			continue
+		}
+		if err != nil {
+			if errors.Is(err, websocket.ErrReadLimit) {
+				// The library already sent its own 1009 close frame.
+				c.closeCode, c.closeReason = websocket.CloseMessageTooBig, "message_too_big"
+				h.logger.Printf("ws: %s sent a message over %d bytes, connection dropped", c.id, 2*h.maxMessage)
+				return
+			}
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) {
+				c.closeCode = closeErr.Code
+				c.closeReason = closeErr.Text
+			}
+			if websocket.IsCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				return
+			}
+			if !errors.Is(err, websocket.ErrCloseSent) {
+				h.logger.Printf("read error from %s: %v", c.id, err)
+			}
+			return
+		}
+		if c.tooBig {
+			// Closing; writePump sends the close frame and the read then fails.
+			continue
+		}
+		c.frames++
+		if h.maxFrames > 0 && c.frames > h.maxFrames {
+			// Ignore the rest; writePump sends the close frame and the read then fails.
+			if c.frames == h.maxFrames+1 {
+				h.logger.Printf("ws: %s exceeded %d frames, closing", c.id, h.maxFrames)
+				c.close(websocket.ClosePolicyViolation, "frame_limit")
+			}
+			continue
+		}
+
+		if err := h.jsonLimits.check(data); err != nil {
+			h.logger.Printf("rejected frame from %s: %v", c.id, err)
+			c.sendError("frame_too_complex")
+			continue
+		}
+		var msg protocol.InboundMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			h.logger.Printf("bad payload from %s: %v", c.id, err)
+			c.sendError("invalid_json")
+			continue
+		}
+		if h.strict {
+			if err := decodeStrict(data); err != nil {
+				h.logger.Printf("rejected frame from %s: %v", c.id, err)
+				c.sendError("unknown_field")
+				continue
+			}
 		}
 		h.handleInbound(c, msg)
 	}
 }
 
-func (c *client) writePump() {
-	ticker := time.NewTicker(pingInterval)
+func (c *client) writePump(h *Hub) {
+	interval := pingInterval
+	if c.pongTimeout > 0 && 2*c.pongTimeout < interval {
+		interval = 2 * c.pongTimeout
+	}
+	ticker := time.NewTicker(interval)
+	var pongDue <-chan time.Time
+	var expired <-chan time.Time
+	if c.lifetime > 0 {
+		timer := time.NewTimer(time.Until(c.connectedAt.Add(c.lifetime)))
+		defer timer.Stop()
+		expired = timer.C
+	}
 	defer func() {
 		ticker.Stop()
 		_ = c.conn.Close()
+		close(c.writeDone)
 	}()
+	write := func(msg []byte, timeout time.Duration) bool {
+		err := c.write(msg, timeout)
+		if errors.Is(err, errSlowConsumer) {
+			h.stats.IncCounter(MetricSlowConsumers)
+			h.logger.Printf("ws: %s took over %s for %d writes in a row, closing", c.id, c.slowWrite, c.slowWrites)
+		}
+		return err == nil
+	}
 
 	for {
+		// Drain the high-priority lane before considering relayed signaling.
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+			if !write(msg, c.writeTimeout) {
+				return
+			}
+			continue
+		default:
+		}
+
 		select {
 		case <-c.ctx.Done():
 			return
@@ -406,26 +2397,220 @@ func (c *client) writePump() {
 				_ = c.conn.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
-			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+			if !write(msg, c.writeTimeout) {
+				return
+			}
+		case msg := <-c.signal:
+			if !write(msg, c.signalWrite) {
 				return
 			}
 		case <-ticker.C:
-			_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+			if c.pongTimeout > 0 {
+				c.pingPending.Store(true)
+				pongDue = time.After(c.pongTimeout)
+			}
+		case <-pongDue:
+			pongDue = nil
+			if c.pingPending.Load() {
+				// Half-open: closing the socket fails the blocked read so readPump cleans up.
+				return
+			}
+		case frame := <-c.kick:
+			c.flushAndClose(frame)
+			return
+		case <-expired:
+			// Hint the client to reconnect (likely landing on another instance).
+			_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+			_ = c.conn.WriteMessage(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseServiceRestart, "max_lifetime"))
+			return
+		}
+	}
+}
+
+type closeFrame struct {
+	code   int
+	reason string
+}
+
+// close asks the connection to flush what is already queued and close with code/reason.
+func (c *client) close(code int, reason string) {
+	select {
+	case c.kick <- closeFrame{code: code, reason: reason}:
+	default:
+	}
+}
+
+// readMessage reads the next message, reassembling its fragments, and returns
+// errMessageTooBig once more than limit bytes arrive. The rest of an oversized
+// message is discarded by the next read.
+func (c *client) readMessage(limit int64) ([]byte, error) {
+	_, r, err := c.conn.NextReader()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errMessageTooBig
+	}
+	return data, nil
+}
+
+// write sends msg within timeout: writeTimeout for the control lane, signalWrite
+// for relayed signaling.
+func (c *client) write(msg []byte, timeout time.Duration) error {
+	if c.compressAbove > 0 {
+		// A no-op unless the client negotiated permessage-deflate.
+		c.conn.EnableWriteCompression(len(msg) >= c.compressAbove)
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(timeout))
+	start := time.Now()
+	if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		return err
+	}
+	if c.maxSlow == 0 {
+		return nil
+	}
+	if time.Since(start) < c.slowWrite {
+		c.slowWrites = 0
+		return nil
+	}
+	c.slowWrites++
+	if c.slowWrites < c.maxSlow {
+		return nil
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "slow_consumer"))
+	return errSlowConsumer
+}
+
+// flushAndClose writes any queued frames (high-priority lane first), then the close frame.
+func (c *client) flushAndClose(frame closeFrame) {
+	lanes := []struct {
+		ch      chan []byte
+		timeout time.Duration
+	}{{c.send, c.writeTimeout}, {c.signal, c.signalWrite}}
+	for _, lane := range lanes {
+		for drained := false; !drained; {
+			select {
+			case msg, ok := <-lane.ch:
+				if !ok {
+					drained = true
+					break
+				}
+				if err := c.write(msg, lane.timeout); err != nil {
+					return
+				}
+			default:
+				drained = true
+			}
+		}
+	}
+	_ = c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(frame.code, frame.reason))
+}
+
+// Relocate tells every peer the room was renamed to newCode and closes their
+// connections (1001 "room_renamed") so they reconnect under the new code.
+func (h *Hub) Relocate(newCode string) {
+	h.closeAll(protocol.RoomRenamedMessage{Type: "room-renamed", Code: newCode}, websocket.CloseGoingAway, "room_renamed")
+	h.logger.Printf("ws: room renamed to %s", newCode)
+}
+
+// Shutdown stops the hub accepting connections (Accept returns ErrHubClosed, ServeWS
+// answers 503) and closes the current ones with 1001 "server_shutdown". It is
+// idempotent.
+func (h *Hub) Shutdown() {
+	if h.closed.Swap(true) {
+		return
+	}
+	h.CloseAll(websocket.CloseGoingAway, "server_shutdown")
+}
+
+// Closed reports whether Shutdown has run.
+func (h *Hub) Closed() bool {
+	return h.closed.Load()
+}
+
+// CloseAll flushes every connection's queue and closes it with code/reason.
+func (h *Hub) CloseAll(code int, reason string) {
+	h.closeAll(nil, code, reason)
+}
+
+// Migrate tells every peer to reconnect to url (a "migrate" message) and closes
+// it with 1012 "migrate", e.g. before this instance is decommissioned. It returns
+// how many peers were told.
+func (h *Hub) Migrate(url string) int {
+	return h.closeAll(protocol.MigrateMessage{Type: "migrate", URL: url}, websocket.CloseServiceRestart, "migrate")
+}
+
+// closeAll queues notice (if non-nil) for every client, then closes them. It returns
+// how many clients it closed.
+func (h *Hub) closeAll(notice interface{}, code int, reason string) int {
+	// Queue under the lock: readPump unregisters before closing c.send, so every
+	// client still in h.clients has an open send lane.
+	h.mu.RLock()
+	n := len(h.clients)
+	for _, c := range h.clients {
+		if notice != nil {
+			c.sendJSON(notice)
+		}
+		if c.conn == nil {
+			// Synthetic peers have no connection to close.
+			c.cancel()
+			go h.unregister(c)
+			continue
 		}
+		c.close(code, reason)
+	}
+	h.mu.RUnlock()
+	h.logger.Printf("ws: closed %d connections (%d %s)", n, code, reason)
+	return n
+}
+
+// connLifetime returns MaxConnLifetime plus up to 10% jitter so connections opened
+// together don't all reconnect at the same moment.
+func (h *Hub) connLifetime() time.Duration {
+	if h.maxLifetime <= 0 {
+		return 0
 	}
+	return h.maxLifetime + time.Duration(rand.Int63n(int64(h.maxLifetime)/10+1))
+}
+
+// sendError replies with an error frame, at most once per errorReplyInterval to avoid feedback loops.
+func (c *client) sendError(reason string) {
+	now := time.Now()
+	if now.Sub(c.lastErrorAt) < errorReplyInterval {
+		return
+	}
+	c.lastErrorAt = now
+	c.sendJSON(protocol.ErrorMessage{Type: "error", Reason: reason})
 }
 
 func (c *client) sendJSON(v interface{}) {
+	c.enqueue(c.send, v)
+}
+
+// sendSignalJSON queues relayed signaling on the low-priority lane so large SDP
+// blobs never delay roster and state updates.
+func (c *client) sendSignalJSON(v interface{}) {
+	c.enqueue(c.signal, v)
+}
+
+func (c *client) enqueue(lane chan []byte, v interface{}) {
 	data, err := json.Marshal(v)
 	if err != nil {
 		return
 	}
 	select {
-	case c.send <- data:
+	case lane <- data:
 	default:
 	}
 }
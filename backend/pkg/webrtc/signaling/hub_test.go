@@ -0,0 +1,1017 @@
+package signaling
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"videochat/pkg/webrtc/protocol"
+)
+
+// memPresence is an in-memory presence.Store; AddPeer fails with addErr when set.
+type memPresence struct {
+	mu     sync.Mutex
+	peers  map[string]bool
+	addErr error
+}
+
+func newMemPresence() *memPresence {
+	return &memPresence{peers: make(map[string]bool)}
+}
+
+func (s *memPresence) Reset(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.peers = make(map[string]bool)
+	return nil
+}
+
+func (s *memPresence) AddPeer(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.addErr != nil {
+		return s.addErr
+	}
+	s.peers[id] = true
+	return nil
+}
+
+func (s *memPresence) RemovePeer(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.peers, id)
+	return nil
+}
+
+func (s *memPresence) Peers(context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]string, 0, len(s.peers))
+	for id := range s.peers {
+		out = append(out, id)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// memUsernames is an in-memory UsernameStore.
+type memUsernames struct {
+	mu    sync.Mutex
+	names map[string]string
+}
+
+func newMemUsernames() *memUsernames {
+	return &memUsernames{names: make(map[string]string)}
+}
+
+func (s *memUsernames) Reset(context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = make(map[string]string)
+	return nil
+}
+
+func (s *memUsernames) RemovePeer(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.names, id)
+	return nil
+}
+
+func (s *memUsernames) SetUsername(_ context.Context, id, username string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if username == "" {
+		delete(s.names, id)
+	} else {
+		s.names[id] = username
+	}
+	return nil
+}
+
+func (s *memUsernames) Usernames(context.Context) (map[string]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make(map[string]string, len(s.names))
+	for id, name := range s.names {
+		out[id] = name
+	}
+	return out, nil
+}
+
+// newTestHub builds a quiet hub over store and serves it over a test WebSocket
+// server, returning the hub and its ws:// URL.
+func newTestHub(t *testing.T, store *memPresence, opts HubOptions) (*Hub, string) {
+	t.Helper()
+	opts.Logger = log.New(io.Discard, "", 0)
+	h := NewHub(store, opts)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// ?id= picks the peer ID, as an authenticated embedder would.
+		h.ServeWS(w, r, ConnOptions{ID: r.URL.Query().Get("id")})
+	}))
+	t.Cleanup(func() {
+		h.Shutdown()
+		srv.Close()
+	})
+	return h, "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	return dialWith(t, websocket.DefaultDialer, url)
+}
+
+func dialWith(t *testing.T, dialer *websocket.Dialer, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readType reads frames until one of type typ arrives and returns it decoded.
+func readType(t *testing.T, conn *websocket.Conn, typ string) map[string]interface{} {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", typ, err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("decode %s: %v", data, err)
+		}
+		if msg["type"] == typ {
+			return msg
+		}
+	}
+}
+
+// waitFor polls cond until it holds or a second has passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFragmentedMessageOverLimitIsRejected(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{MaxMessageSize: 1024})
+	// A small write buffer makes the client send the message as ~512-byte
+	// fragments: each is under the limit, the whole message is not.
+	conn := dialWith(t, &websocket.Dialer{WriteBufferSize: 512}, url)
+	readType(t, conn, "welcome")
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 1536))); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := readType(t, conn, "error"); msg["reason"] != "message_too_big" {
+		t.Fatalf("error reason = %v, want message_too_big", msg["reason"])
+	}
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != websocket.CloseMessageTooBig {
+			t.Fatalf("close = %v, want 1009", err)
+		}
+		break
+	}
+}
+
+func TestFragmentedMessageUnderLimitIsAccepted(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{MaxMessageSize: 4096})
+	conn := dialWith(t, &websocket.Dialer{WriteBufferSize: 512}, url)
+	readType(t, conn, "welcome")
+
+	msg := `{"type":"sync","pad":"` + strings.Repeat("x", 2048) + `"}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	readType(t, conn, "sync")
+}
+
+func TestPresenceFailureRollsBackJoin(t *testing.T) {
+	store := newMemPresence()
+	store.addErr = errors.New("redis down")
+	h, url := newTestHub(t, store, HubOptions{})
+
+	conn := dial(t, url)
+	if msg := readType(t, conn, "error"); msg["reason"] != "join_failed" {
+		t.Fatalf("error reason = %v, want join_failed", msg["reason"])
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) || ce.Code != websocket.CloseTryAgainLater || ce.Text != "join_failed" {
+			t.Fatalf("close = %v, want 1013 join_failed", err)
+		}
+		break
+	}
+	waitFor(t, "the client to be dropped", func() bool { return h.ClientCount() == 0 })
+	if peers, _ := store.Peers(context.Background()); len(peers) != 0 {
+		t.Fatalf("presence = %v, want empty", peers)
+	}
+
+	// Once presence recovers the same hub admits peers again.
+	store.mu.Lock()
+	store.addErr = nil
+	store.mu.Unlock()
+	again := dial(t, url)
+	welcome := readType(t, again, "welcome")
+	if peers, _ := store.Peers(context.Background()); len(peers) != 1 || peers[0] != welcome["id"] {
+		t.Fatalf("presence = %v, want [%v]", peers, welcome["id"])
+	}
+}
+
+func TestEmptyGraceDebouncesOnEmpty(t *testing.T) {
+	emptied := make(chan struct{}, 4)
+	h, url := newTestHub(t, newMemPresence(), HubOptions{
+		EmptyGrace: 100 * time.Millisecond,
+		OnEmpty:    func() { emptied <- struct{}{} },
+	})
+
+	first := dial(t, url)
+	readType(t, first, "welcome")
+	first.Close()
+	waitFor(t, "the first peer to leave", func() bool { return h.ClientCount() == 0 })
+
+	// Rejoining inside the grace cancels the pending OnEmpty.
+	second := dial(t, url)
+	readType(t, second, "welcome")
+	select {
+	case <-emptied:
+		t.Fatal("OnEmpty ran although a peer rejoined within EmptyGrace")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	second.Close()
+	select {
+	case <-emptied:
+	case <-time.After(time.Second):
+		t.Fatal("OnEmpty did not run after the room stayed empty")
+	}
+	select {
+	case <-emptied:
+		t.Fatal("OnEmpty ran twice")
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestOnEmptyRunsAtOnceWithoutGrace(t *testing.T) {
+	emptied := make(chan struct{}, 1)
+	_, url := newTestHub(t, newMemPresence(), HubOptions{
+		OnEmpty: func() { emptied <- struct{}{} },
+	})
+	conn := dial(t, url)
+	readType(t, conn, "welcome")
+	conn.Close()
+	select {
+	case <-emptied:
+	case <-time.After(time.Second):
+		t.Fatal("OnEmpty did not run")
+	}
+}
+
+func TestCloseAllRacesClientDisconnects(t *testing.T) {
+	h, url := newTestHub(t, newMemPresence(), HubOptions{})
+	for round := 0; round < 20; round++ {
+		conns := make([]*websocket.Conn, 8)
+		for i := range conns {
+			conns[i] = dial(t, url)
+			readType(t, conns[i], "welcome")
+		}
+		// Peers hanging up while the hub queues its notices must not send on a
+		// closed lane; run with -race to catch it.
+		var wg sync.WaitGroup
+		for _, conn := range conns {
+			wg.Add(1)
+			go func(conn *websocket.Conn) {
+				defer wg.Done()
+				conn.Close()
+			}(conn)
+		}
+		h.Migrate("wss://elsewhere.example/ws")
+		wg.Wait()
+		waitFor(t, "every peer to leave", func() bool { return h.ClientCount() == 0 })
+	}
+}
+
+// readClose reads until the server closes the connection and returns its close frame.
+func readClose(t *testing.T, conn *websocket.Conn) *websocket.CloseError {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var ce *websocket.CloseError
+		if !errors.As(err, &ce) {
+			t.Fatalf("read = %v, want a close frame", err)
+		}
+		return ce
+	}
+}
+
+func TestDuplicateIDReplacesWithinMaxPeers(t *testing.T) {
+	h, url := newTestHub(t, newMemPresence(), HubOptions{MaxPeers: 2})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	// The room is full, but alice reconnecting takes her own seat back.
+	again := dial(t, url+"?id=alice")
+	if welcome := readType(t, again, "welcome"); welcome["id"] != "alice" {
+		t.Fatalf("welcome id = %v, want alice", welcome["id"])
+	}
+	if msg := readType(t, alice, "error"); msg["reason"] != "session_replaced" {
+		t.Fatalf("error reason = %v, want session_replaced", msg["reason"])
+	}
+	if ce := readClose(t, alice); ce.Code != websocket.CloseNormalClosure || ce.Text != "session_replaced" {
+		t.Fatalf("close = %v, want 1000 session_replaced", ce)
+	}
+	if n := h.ClientCount(); n != 2 {
+		t.Fatalf("ClientCount = %d, want 2", n)
+	}
+	if !h.HasPeer("alice") || !h.HasPeer("bob") {
+		t.Fatal("alice and bob should both still be in the room")
+	}
+}
+
+func TestDuplicateIDRejected(t *testing.T) {
+	h, url := newTestHub(t, newMemPresence(), HubOptions{MaxPeers: 2, DuplicateSessions: "reject"})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+
+	again := dial(t, url+"?id=alice")
+	if ce := readClose(t, again); ce.Code != websocket.ClosePolicyViolation || ce.Text != "duplicate_session" {
+		t.Fatalf("close = %v, want 1008 duplicate_session", ce)
+	}
+	if n := h.ClientCount(); n != 1 {
+		t.Fatalf("ClientCount = %d, want 1", n)
+	}
+}
+
+func TestReplaceRacesOldConnectionClosing(t *testing.T) {
+	h, url := newTestHub(t, newMemPresence(), HubOptions{DuplicateSessions: "replace"})
+	prev := dial(t, url+"?id=alice")
+	readType(t, prev, "welcome")
+	for i := 0; i < 20; i++ {
+		// The old socket hangs up while the new one replaces it; run with -race.
+		go prev.Close()
+		next := dial(t, url+"?id=alice")
+		readType(t, next, "welcome")
+		prev = next
+	}
+	waitFor(t, "a single alice", func() bool { return h.ClientCount() == 1 })
+}
+
+func TestServeWSRefusalsRunOnCloseOnce(t *testing.T) {
+	h := NewHub(newMemPresence(), HubOptions{MaxPeers: 1, Logger: log.New(io.Discard, "", 0)})
+	var closes sync.WaitGroup
+	var mu sync.Mutex
+	calls := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.URL.Query().Get("id")
+		closes.Add(1)
+		h.ServeWS(w, r, ConnOptions{ID: id, OnClose: func() {
+			mu.Lock()
+			calls[id]++
+			mu.Unlock()
+			closes.Done()
+		}})
+	}))
+	t.Cleanup(func() {
+		h.Shutdown()
+		srv.Close()
+	})
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	h.SetLocked(true)
+	for _, tc := range []struct {
+		id     string
+		status int
+	}{
+		{"bob", http.StatusForbidden},                      // locked
+		{strings.Repeat("x", 1000), http.StatusBadRequest}, // invalid ID
+		{"carol", http.StatusForbidden},                    // locked outranks full
+	} {
+		_, resp, err := websocket.DefaultDialer.Dial(url+"?id="+tc.id, nil)
+		if err == nil || resp == nil || resp.StatusCode != tc.status {
+			t.Fatalf("%q: dial = %v, want status %d", tc.id, err, tc.status)
+		}
+	}
+	h.SetLocked(false)
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"?id=dave", nil); err == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dave: dial = %v, want 503 room full", err)
+	}
+	alice.Close()
+	closes.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	for id, n := range calls {
+		if n != 1 {
+			t.Fatalf("OnClose for %q ran %d times, want once", id, n)
+		}
+	}
+}
+
+func TestHoldRefusesJoins(t *testing.T) {
+	h, url := newTestHub(t, newMemPresence(), HubOptions{})
+	release, ok := h.Hold()
+	if !ok {
+		t.Fatal("Hold failed on an empty room")
+	}
+	if _, ok := h.Hold(); ok {
+		t.Fatal("a second Hold succeeded")
+	}
+	if _, resp, err := websocket.DefaultDialer.Dial(url, nil); err == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("dial while held = %v, want 503", err)
+	}
+
+	release()
+	release() // idempotent
+	conn := dial(t, url)
+	readType(t, conn, "welcome")
+	if _, ok := h.Hold(); ok {
+		t.Fatal("Hold succeeded with a peer connected")
+	}
+}
+
+// send writes v to conn as a JSON text frame.
+func send(t *testing.T, conn *websocket.Conn, v interface{}) {
+	t.Helper()
+	if err := conn.WriteJSON(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReactionsAndRecordingFollowFeatureFlags(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	send(t, alice, map[string]interface{}{"type": "reaction", "text": "👍"})
+	if msg := readType(t, bob, "reaction"); msg["from"] != "alice" || msg["emoji"] != "👍" {
+		t.Fatalf("reaction = %v, want alice's 👍", msg)
+	}
+	send(t, alice, map[string]interface{}{"type": "recording", "enabled": true})
+	if msg := readType(t, bob, "recording"); msg["id"] != "alice" || msg["enabled"] != true {
+		t.Fatalf("recording = %v, want alice recording", msg)
+	}
+}
+
+func TestDisabledReactionsAndRecordingAreRefused(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{
+		Features: map[string]bool{"reactions": false, "recording": false},
+	})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	send(t, alice, map[string]interface{}{"type": "recording", "enabled": true})
+	if msg := readType(t, alice, "error"); msg["reason"] != "recording_disabled" {
+		t.Fatalf("error reason = %v, want recording_disabled", msg["reason"])
+	}
+	send(t, alice, map[string]interface{}{"type": "reaction", "text": "👍"})
+	// Chat still works and is relayed in order, so nothing dropped came before it.
+	send(t, alice, map[string]interface{}{"type": "chat", "text": "hi"})
+	_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := bob.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		switch msg["type"] {
+		case "reaction", "recording":
+			t.Fatalf("disabled %s frame was relayed: %s", msg["type"], data)
+		case "chat":
+			return
+		}
+	}
+}
+
+func TestPresenceDiffMatchesFullList(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{})
+	full := dial(t, url+"?id=full")
+	readType(t, full, "welcome")
+	diff := dial(t, url+"?id=diff&v=2")
+	if msg := readType(t, diff, "welcome"); len(msg["peers"].([]interface{})) != 2 {
+		t.Fatalf("welcome should stay a full snapshot, got %v", msg["peers"])
+	}
+	readType(t, full, "peer-joined")
+
+	joiner := dial(t, url+"?id=joiner")
+	readType(t, joiner, "welcome")
+	fullJoin := readType(t, full, "peer-joined")
+	diffJoin := readType(t, diff, "peer-joined")
+	if got := fullJoin["peers"]; len(got.([]interface{})) != 3 {
+		t.Fatalf("legacy peer-joined peers = %v, want all three", got)
+	}
+	if diffJoin["peers"] != nil {
+		t.Fatalf("diff peer-joined carried the full list: %v", diffJoin["peers"])
+	}
+	if added := diffJoin["added"].([]interface{}); len(added) != 1 || added[0] != "joiner" {
+		t.Fatalf("added = %v, want [joiner]", added)
+	}
+	if diffJoin["peerCount"] != float64(3) || diffJoin["peerCount"] != fullJoin["peerCount"] ||
+		diffJoin["stateVersion"] != fullJoin["stateVersion"] {
+		t.Fatalf("diff and full disagree: %v vs %v", diffJoin, fullJoin)
+	}
+
+	joiner.Close()
+	fullLeave := readType(t, full, "peer-left")
+	diffLeave := readType(t, diff, "peer-left")
+	if got := fullLeave["peers"]; len(got.([]interface{})) != 2 {
+		t.Fatalf("legacy peer-left peers = %v, want the two remaining", got)
+	}
+	if removed := diffLeave["removed"].([]interface{}); len(removed) != 1 || removed[0] != "joiner" {
+		t.Fatalf("removed = %v, want [joiner]", removed)
+	}
+	if diffLeave["peerCount"] != fullLeave["peerCount"] {
+		t.Fatalf("peerCount: diff %v, full %v", diffLeave["peerCount"], fullLeave["peerCount"])
+	}
+}
+
+func TestDisabledChatIsDropped(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{
+		Features: map[string]bool{"chat": false},
+	})
+	alice := dial(t, url+"?id=alice")
+	if msg := readType(t, alice, "welcome"); msg["features"].(map[string]interface{})["chat"] != false {
+		t.Fatalf("welcome features = %v, want chat off", msg["features"])
+	}
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	send(t, alice, map[string]interface{}{"type": "chat", "text": "hi"})
+	// Reactions are still on; one sent after the chat marks where it would have been.
+	send(t, alice, map[string]interface{}{"type": "reaction", "text": "👍"})
+	_ = bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := bob.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		switch msg["type"] {
+		case "chat":
+			t.Fatalf("chat was relayed in a room with chat disabled: %s", data)
+		case "reaction":
+			return
+		}
+	}
+}
+
+func TestMalformedJSONGetsErrorAndKeepsConnection(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{})
+	conn := dial(t, url)
+	readType(t, conn, "welcome")
+
+	for i := 0; i < 3; i++ {
+		if err := conn.WriteMessage(websocket.TextMessage, []byte("{not json")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(t, conn, map[string]interface{}{"type": "sync"})
+
+	// One error for the burst (replies are rate limited), then the sync reply: the
+	// connection is still being served.
+	replies := 0
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("connection closed after bad frames: %v", err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg["type"] == "error" {
+			if msg["reason"] != "invalid_json" {
+				t.Fatalf("error reason = %v, want invalid_json", msg["reason"])
+			}
+			replies++
+			continue
+		}
+		if msg["type"] == "sync" {
+			break
+		}
+	}
+	if replies != 1 {
+		t.Fatalf("got %d invalid_json replies, want 1", replies)
+	}
+}
+
+func TestChatAckReportsDelivery(t *testing.T) {
+	h, url := newTestHub(t, newMemPresence(), HubOptions{})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	send(t, alice, map[string]interface{}{"type": "chat", "id": "m1", "text": "hi"})
+	ack := readType(t, alice, "ack")
+	if ack["id"] != "m1" || ack["delivered"] != float64(2) || ack["partial"] != nil {
+		t.Fatalf("ack = %v, want m1 delivered to both", ack)
+	}
+
+	// A peer whose send buffer can take nothing stands in for a stalled browser.
+	ctx, cancel := context.WithCancel(context.Background())
+	stuck := &client{
+		id:      "stuck",
+		send:    make(chan []byte),
+		signal:  make(chan []byte),
+		ctx:     ctx,
+		cancel:  cancel,
+		version: protocol.VersionFull,
+	}
+	if err := h.register(context.Background(), stuck); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.unregister(stuck) })
+
+	send(t, alice, map[string]interface{}{"type": "chat", "id": "m2", "text": "anyone?"})
+	ack = readType(t, alice, "ack")
+	dropped, _ := ack["dropped"].([]interface{})
+	if ack["id"] != "m2" || ack["partial"] != true || len(dropped) != 1 || dropped[0] != "stuck" {
+		t.Fatalf("ack = %v, want m2 partial with stuck dropped", ack)
+	}
+	if ack["delivered"] != float64(2) {
+		t.Fatalf("delivered = %v, want 2", ack["delivered"])
+	}
+
+	// No id, no ack.
+	send(t, alice, map[string]interface{}{"type": "chat", "text": "quiet"})
+	send(t, alice, map[string]interface{}{"type": "chat", "id": "m3", "text": "loud"})
+	if ack := readType(t, alice, "ack"); ack["id"] != "m3" {
+		t.Fatalf("ack id = %v, want m3 (the id-less chat must not be acked)", ack["id"])
+	}
+}
+
+func TestSpoofedFromIsReplacedWithConnectionID(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	send(t, alice, map[string]interface{}{
+		"type": "signal",
+		"from": "mallory",
+		"to":   "bob",
+		"data": map[string]string{"sdp": "offer"},
+	})
+	msg := readType(t, bob, "signal")
+	if msg["from"] != "alice" {
+		t.Fatalf("forwarded from = %v, want the sender's real id", msg["from"])
+	}
+	if msg["data"].(map[string]interface{})["sdp"] != "offer" {
+		t.Fatalf("payload = %v, want it relayed untouched", msg["data"])
+	}
+}
+
+func TestRenameCooldownThrottlesRapidRenames(t *testing.T) {
+	names := newMemUsernames()
+	_, url := newTestHub(t, newMemPresence(), HubOptions{Usernames: names, RenameCooldown: time.Hour})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	send(t, alice, map[string]interface{}{"type": "set-username", "username": "Alice"})
+	msg := readType(t, bob, "usernames")
+	if got := msg["usernames"].(map[string]interface{})["alice"]; got != "Alice" {
+		t.Fatalf("first rename: usernames[alice] = %v, want Alice", got)
+	}
+
+	send(t, alice, map[string]interface{}{"type": "set-username", "username": "Mallory"})
+	if msg := readType(t, alice, "rename-throttled"); msg["reason"] != "cooldown" {
+		t.Fatalf("throttle reply = %v", msg)
+	}
+	if got, _ := names.Usernames(context.Background()); got["alice"] != "Alice" {
+		t.Fatalf("throttled rename was stored: %v", got)
+	}
+}
+
+func TestReconnectWithinLeaveGraceSuppressesLeave(t *testing.T) {
+	store := newMemPresence()
+	h, url := newTestHub(t, store, HubOptions{LeaveGrace: time.Minute})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+	readType(t, alice, "peer-joined")
+
+	bob.Close()
+	waitFor(t, "bob's disconnect", func() bool { return h.ClientCount() == 1 })
+	if peers, _ := store.Peers(context.Background()); len(peers) != 2 {
+		t.Fatalf("presence during grace = %v, want bob kept", peers)
+	}
+
+	bob = dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+	send(t, bob, map[string]interface{}{"type": "chat", "text": "back"})
+	_ = alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := alice.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		switch msg["type"] {
+		case "peer-left", "peer-joined":
+			t.Fatalf("reconnect within grace announced %s", data)
+		case "chat":
+			return
+		}
+	}
+}
+
+func TestLeaveGraceExpiryAnnouncesLeave(t *testing.T) {
+	store := newMemPresence()
+	_, url := newTestHub(t, store, HubOptions{LeaveGrace: 50 * time.Millisecond})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+	readType(t, alice, "peer-joined")
+
+	bob.Close()
+	if msg := readType(t, alice, "peer-left"); msg["id"] != "bob" {
+		t.Fatalf("peer-left id = %v, want bob", msg["id"])
+	}
+	if peers, _ := store.Peers(context.Background()); len(peers) != 1 {
+		t.Fatalf("presence after grace = %v, want bob removed", peers)
+	}
+}
+
+func TestHighPriorityLaneOvertakesSignalBacklog(t *testing.T) {
+	h := NewHub(newMemPresence(), HubOptions{Logger: log.New(io.Discard, "", 0)})
+	t.Cleanup(h.Shutdown)
+	conns := make(chan *websocket.Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Upgrade(w, r, nil, 0, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- conn
+	}))
+	t.Cleanup(srv.Close)
+	peer := dial(t, "ws"+strings.TrimPrefix(srv.URL, "http"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &client{
+		id:           "busy",
+		conn:         <-conns,
+		send:         make(chan []byte, 8),
+		signal:       make(chan []byte, 64),
+		ctx:          ctx,
+		cancel:       cancel,
+		kick:         make(chan closeFrame, 1),
+		writeDone:    make(chan struct{}),
+		writeTimeout: time.Second,
+		signalWrite:  time.Second,
+	}
+	// A backlog of relayed signaling is already queued when a roster update arrives.
+	const backlog = 50
+	for i := 0; i < backlog; i++ {
+		c.signal <- []byte(`{"type":"signal"}`)
+	}
+	c.send <- []byte(`{"type":"peer-joined"}`)
+	go c.writePump(h)
+	t.Cleanup(func() {
+		cancel()
+		<-c.writeDone
+	})
+
+	_ = peer.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var order []string
+	for len(order) < backlog+1 {
+		var msg map[string]interface{}
+		if err := peer.ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		order = append(order, msg["type"].(string))
+	}
+	if order[0] != "peer-joined" {
+		t.Fatalf("first frame = %s, want the roster update ahead of %d signals", order[0], backlog)
+	}
+	for _, typ := range order[1:] {
+		if typ != "signal" {
+			t.Fatalf("unexpected frame order %v", order)
+		}
+	}
+}
+
+func TestDeeplyNestedFrameIsRejected(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{MaxJSONDepth: 8})
+	conn := dial(t, url)
+	readType(t, conn, "welcome")
+
+	frame := `{"type":"signal","to":"x","data":` + strings.Repeat("[", 100) + strings.Repeat("]", 100) + `}`
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readType(t, conn, "error"); msg["reason"] != "frame_too_complex" {
+		t.Fatalf("error reason = %v, want frame_too_complex", msg["reason"])
+	}
+	// The connection stays usable.
+	send(t, conn, map[string]interface{}{"type": "sync"})
+	readType(t, conn, "sync")
+}
+
+// expectNoneBeforeSync asks conn for a sync and fails if a frame of one of types
+// arrives before the reply.
+func expectNoneBeforeSync(t *testing.T, conn *websocket.Conn, types ...string) {
+	t.Helper()
+	send(t, conn, map[string]interface{}{"type": "sync"})
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg["type"] == "sync" {
+			return
+		}
+		for _, typ := range types {
+			if msg["type"] == typ {
+				t.Fatalf("unexpected frame before sync: %s", data)
+			}
+		}
+	}
+}
+
+func TestReadyGatesPeerJoined(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{ReadyTimeout: time.Minute})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	send(t, alice, map[string]interface{}{"type": "ready"})
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	expectNoneBeforeSync(t, alice, "peer-joined")
+	send(t, bob, map[string]interface{}{"type": "ready"})
+	if msg := readType(t, alice, "peer-joined"); msg["id"] != "bob" {
+		t.Fatalf("peer-joined id = %v, want bob", msg["id"])
+	}
+}
+
+func TestReadyTimeoutAnnouncesAnyway(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{ReadyTimeout: 50 * time.Millisecond})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	// Bob never sends ready.
+	if msg := readType(t, alice, "peer-joined"); msg["id"] != "bob" {
+		t.Fatalf("peer-joined id = %v, want bob", msg["id"])
+	}
+}
+
+func TestLockedRoomRejectsNewJoins(t *testing.T) {
+	var mu sync.Mutex
+	var changes []bool
+	_, url := newTestHub(t, newMemPresence(), HubOptions{OnLockChange: func(locked bool) {
+		mu.Lock()
+		changes = append(changes, locked)
+		mu.Unlock()
+	}})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	bob := dial(t, url+"?id=bob")
+	readType(t, bob, "welcome")
+
+	send(t, bob, map[string]interface{}{"type": "lock", "locked": true})
+	if msg := readType(t, bob, "error"); msg["reason"] != "not_owner" {
+		t.Fatalf("non-owner lock: reason = %v, want not_owner", msg["reason"])
+	}
+	send(t, alice, map[string]interface{}{"type": "lock", "locked": true})
+	if msg := readType(t, bob, "room-locked"); msg["enabled"] != true {
+		t.Fatalf("room-locked = %v, want enabled", msg)
+	}
+
+	_, resp, err := websocket.DefaultDialer.Dial(url+"?id=carol", nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("join of a locked room: err %v, resp %v; want 403", err, resp)
+	}
+	// Peers already in the room may reconnect.
+	bob2 := dial(t, url+"?id=bob")
+	if msg := readType(t, bob2, "welcome"); msg["locked"] != true {
+		t.Fatalf("welcome locked = %v, want true", msg["locked"])
+	}
+
+	send(t, alice, map[string]interface{}{"type": "lock", "locked": false})
+	readType(t, alice, "room-locked") // her own lock
+	if msg := readType(t, alice, "room-locked"); msg["enabled"] != false {
+		t.Fatalf("room-locked = %v, want the unlock", msg)
+	}
+	carol := dial(t, url+"?id=carol")
+	if msg := readType(t, carol, "welcome"); msg["locked"] != nil {
+		t.Fatalf("welcome after unlock reports locked = %v", msg["locked"])
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Fatalf("OnLockChange calls = %v, want [true false]", changes)
+	}
+}
+
+func TestRequireNameHoldsJoinUntilNamed(t *testing.T) {
+	_, url := newTestHub(t, newMemPresence(), HubOptions{Usernames: newMemUsernames(), RequireName: true})
+	alice := dial(t, url+"?id=alice")
+	readType(t, alice, "welcome")
+	send(t, alice, map[string]interface{}{"type": "set-username", "username": "Alice"})
+	readType(t, alice, "usernames")
+
+	bob := dial(t, url+"?id=bob")
+	if msg := readType(t, bob, "welcome"); msg["requireName"] != true {
+		t.Fatalf("welcome requireName = %v, want true", msg["requireName"])
+	}
+	if msg := readType(t, bob, "error"); msg["reason"] != "name_required" {
+		t.Fatalf("error reason = %v, want name_required", msg["reason"])
+	}
+	// Unnamed peers may not talk yet.
+	send(t, bob, map[string]interface{}{"type": "chat", "text": "anonymous"})
+	expectNoneBeforeSync(t, alice, "peer-joined", "chat")
+
+	send(t, bob, map[string]interface{}{"type": "set-username", "username": "Bob"})
+	msg := readType(t, alice, "peer-joined")
+	if msg["id"] != "bob" {
+		t.Fatalf("peer-joined id = %v, want bob", msg["id"])
+	}
+	send(t, bob, map[string]interface{}{"type": "chat", "text": "hello"})
+	if msg := readType(t, alice, "chat"); msg["text"] != "hello" {
+		t.Fatalf("first chat alice saw = %v, want the one sent after naming", msg["text"])
+	}
+}
+
+func TestBroadcastReturnsMarshalError(t *testing.T) {
+	h, url := newTestHub(t, newMemPresence(), HubOptions{})
+	conn := dial(t, url)
+	readType(t, conn, "welcome")
+
+	err := h.Broadcast(map[string]interface{}{"type": "announcement", "bad": make(chan int)})
+	var unsupported *json.UnsupportedTypeError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("Broadcast = %v, want the marshal error", err)
+	}
+
+	// Nothing went out for the failed call; the next one is delivered.
+	if err := h.Broadcast(map[string]string{"type": "announcement", "text": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := readType(t, conn, "announcement"); msg["text"] != "hi" {
+		t.Fatalf("first announcement = %v, want the valid one", msg)
+	}
+}
@@ -0,0 +1,102 @@
+package signaling
+
+import "errors"
+
+const (
+	defaultMaxJSONDepth = 32
+	// defaultMaxJSONToken bounds object keys and number literals; string values
+	// (SDP blobs) are only bounded by the read limit.
+	defaultMaxJSONToken = 256
+)
+
+var (
+	errJSONTooDeep  = errors.New("json nested too deeply")
+	errJSONTokenLen = errors.New("json key or number too long")
+)
+
+// jsonLimits caps the structure of an inbound frame before it reaches json.Unmarshal.
+type jsonLimits struct {
+	depth int
+	token int
+}
+
+func newJSONLimits(depth, token int) jsonLimits {
+	if depth <= 0 {
+		depth = defaultMaxJSONDepth
+	}
+	if token <= 0 {
+		token = defaultMaxJSONToken
+	}
+	return jsonLimits{depth: depth, token: token}
+}
+
+// check scans data once and rejects frames nested deeper than
+// l.depth or carrying an object key or number literal longer than l.token bytes.
+// It is not a validator: malformed JSON that passes is still rejected by Unmarshal.
+func (l jsonLimits) check(data []byte) error {
+	depth := 0
+	inString := false
+	escaped := false
+	// run is the length of the current key or number; isKey marks a string that
+	// opened right after '{' or ',' inside an object.
+	run := 0
+	isKey := false
+	var stack []byte
+	for _, b := range data {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+				isKey = false
+				run = 0
+				continue
+			}
+			if isKey {
+				run++
+				if run > l.token {
+					return errJSONTokenLen
+				}
+			}
+			continue
+		}
+		switch {
+		case b == '"':
+			inString = true
+			isKey = len(stack) > 0 && stack[len(stack)-1] == '{' && run == -1
+			run = 0
+		case b == '{' || b == '[':
+			depth++
+			if depth > l.depth {
+				return errJSONTooDeep
+			}
+			stack = append(stack, b)
+			run = -1
+		case b == '}' || b == ']':
+			depth--
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			run = 0
+		case b == ',':
+			run = -1
+		case b == '-' || b == '+' || b == '.' || b == 'e' || b == 'E' || (b >= '0' && b <= '9'):
+			if run < 0 {
+				run = 0
+			}
+			run++
+			if run > l.token {
+				return errJSONTokenLen
+			}
+		default:
+			// Whitespace keeps a pending key position; anything else ends a number.
+			if b != ' ' && b != '\t' && b != '\n' && b != '\r' {
+				run = 0
+			}
+		}
+	}
+	return nil
+}
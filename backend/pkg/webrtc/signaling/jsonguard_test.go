@@ -0,0 +1,40 @@
+package signaling
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJSONLimitsCheck(t *testing.T) {
+	l := newJSONLimits(4, 16)
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"plain frame", `{"type":"signal","to":"bob","data":{"sdp":"v=0"}}`, nil},
+		{"at the depth limit", `{"a":[{"b":[1]}]}`, nil},
+		{"too deep", `{"a":[{"b":[[1]]}]}`, errJSONTooDeep},
+		{"deep arrays", strings.Repeat("[", 1000) + strings.Repeat("]", 1000), errJSONTooDeep},
+		{"brackets inside strings", `{"text":"` + strings.Repeat("[{", 50) + `"}`, nil},
+		{"escaped quote in string", `{"text":"a\"[[[[[[","k":1}`, nil},
+		{"long key", `{"` + strings.Repeat("k", 17) + `":1}`, errJSONTokenLen},
+		{"long number", `{"n":` + strings.Repeat("9", 17) + `}`, errJSONTokenLen},
+		{"long string value", `{"sdp":"` + strings.Repeat("x", 4096) + `"}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := l.check([]byte(tt.data)); !errors.Is(err, tt.want) {
+				t.Fatalf("check = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONLimitsDefaults(t *testing.T) {
+	l := newJSONLimits(0, -1)
+	if l.depth != defaultMaxJSONDepth || l.token != defaultMaxJSONToken {
+		t.Fatalf("limits = %+v, want the defaults", l)
+	}
+}
@@ -0,0 +1,77 @@
+package signaling
+
+import (
+	"sync"
+	"time"
+)
+
+// MetricInboundShed counts inbound frames dropped by the InboundLimiter.
+const MetricInboundShed = "signaling_inbound_shed_total"
+
+// Priority classes for inbound frames. Under saturation lower classes are shed first:
+// each class may only spend tokens above the share of the bucket reserved for the
+// classes above it.
+const (
+	priorityLow = iota
+	priorityNormal
+	priorityHigh
+)
+
+// reserve is the fraction of the bucket a class must leave untouched.
+var reserve = [...]float64{
+	priorityLow:    0.5,
+	priorityNormal: 0.2,
+	priorityHigh:   0,
+}
+
+// InboundLimiter is a token bucket capping the inbound frame rate across every
+// connection of the hubs sharing it. A nil limiter allows everything.
+type InboundLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewInboundLimiter allows perSecond frames on average with bursts of the same size.
+// A non-positive rate returns nil (unlimited).
+func NewInboundLimiter(perSecond int) *InboundLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	r := float64(perSecond)
+	return &InboundLimiter{rate: r, burst: r, tokens: r, last: time.Now()}
+}
+
+func (l *InboundLimiter) allow(priority int) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens-1 < reserve[priority]*l.burst {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// inboundPriority ranks frame types: WebRTC negotiation and presence changes first,
+// chat and renames next, cosmetic updates (typing, reactions, media/meta hints) last.
+func inboundPriority(msgType string) int {
+	switch msgType {
+	case "signal", "ice-restart", "broadcast", "sync", "ready":
+		return priorityHigh
+	case "chat", "set-username", "lock", "recording":
+		return priorityNormal
+	default:
+		return priorityLow
+	}
+}
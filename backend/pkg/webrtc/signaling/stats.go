@@ -0,0 +1,31 @@
+package signaling
+
+// Metric names reported through Stats.
+const (
+	MetricJoins            = "signaling_joins_total"
+	MetricLeaves           = "signaling_leaves_total"
+	MetricClients          = "signaling_clients"
+	MetricSignalsForwarded = "signaling_signals_forwarded_total"
+	MetricSignalsDropped   = "signaling_signals_target_missing_total"
+	MetricSignalBytes      = "signaling_signal_bytes"
+	MetricSendDropped      = "signaling_send_dropped_total"
+	MetricICERestarts      = "signaling_ice_restarts_forwarded_total"
+	MetricSlowConsumers    = "signaling_slow_consumers_closed_total"
+	MetricQueueDepthMax    = "signaling_send_queue_depth_max"
+)
+
+// Stats is a minimal metrics sink the hub reports to. Adapters for Prometheus,
+// StatsD, OpenTelemetry, etc. live outside this package. Implementations must be
+// safe for concurrent use.
+type Stats interface {
+	IncCounter(name string)
+	ObserveHistogram(name string, value float64)
+	SetGauge(name string, value float64)
+}
+
+// NopStats discards every measurement; it is the default when HubOptions.Stats is nil.
+type NopStats struct{}
+
+func (NopStats) IncCounter(string)                {}
+func (NopStats) ObserveHistogram(string, float64) {}
+func (NopStats) SetGauge(string, float64)         {}
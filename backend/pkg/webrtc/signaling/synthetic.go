@@ -0,0 +1,61 @@
+package signaling
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+
+	"videochat/pkg/webrtc/protocol"
+)
+
+// SyntheticPrefix tags the IDs of synthetic peers created by SpawnSynthetic.
+const SyntheticPrefix = "synthetic-"
+
+// SpawnSynthetic registers count synthetic peers for load testing. They have no
+// WebSocket: frames sent to them are discarded. Each one joins, starts broadcasting
+// (when a broadcast store is configured) and leaves again after ttl, exercising the
+// same register/broadcast/unregister fanout as a real browser. It returns the IDs.
+func (h *Hub) SpawnSynthetic(ctx context.Context, count int, ttl time.Duration) ([]string, error) {
+	ids := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		cctx, cancel := context.WithCancel(context.Background())
+		c := &client{
+			id:       SyntheticPrefix + uuid.NewString(),
+			send:     make(chan []byte, 32),
+			signal:   make(chan []byte, 64),
+			ctx:      cctx,
+			cancel:   cancel,
+			version:  protocol.VersionFull,
+			username: fmt.Sprintf("synthetic %d", i+1),
+		}
+		go c.discard()
+		if err := h.register(ctx, c); err != nil {
+			cancel()
+			return ids, err
+		}
+		if h.broadcasts != nil {
+			h.updateBroadcast(c.id, true)
+		}
+		time.AfterFunc(ttl, func() {
+			c.cancel()
+			h.unregister(c)
+		})
+		ids = append(ids, c.id)
+	}
+	h.logger.Printf("debug: spawned %d synthetic peers (ttl=%s)", len(ids), ttl)
+	return ids, nil
+}
+
+// discard drains a synthetic client's send queue until it is cancelled.
+func (c *client) discard() {
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.send:
+		case <-c.signal:
+		}
+	}
+}
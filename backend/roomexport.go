@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"videochat/internal/app/httpapi"
+)
+
+// ExportRoom reads a room's metadata and every per-room store straight from Redis.
+func (m *hubManager) ExportRoom(ctx context.Context, code string) (*httpapi.RoomExport, error) {
+	room, err := m.roomStore.Get(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+	stores := m.roomStores(code)
+	blob := &httpapi.RoomExport{
+		Version:    httpapi.RoomExportVersion,
+		Code:       room.Code,
+		ExportedAt: time.Now().UTC(),
+		CreatedAt:  room.CreatedAt,
+		Features:   room.Features,
+		Paused:     room.Paused,
+	}
+	if blob.Peers, err = stores.presence.Peers(ctx); err != nil {
+		return nil, fmt.Errorf("presence: %w", err)
+	}
+	if blob.Broadcasting, err = stores.bcast.Broadcasting(ctx); err != nil {
+		return nil, fmt.Errorf("broadcast: %w", err)
+	}
+	if blob.BroadcastMeta, err = stores.bcast.BroadcastMeta(ctx); err != nil {
+		return nil, fmt.Errorf("broadcast meta: %w", err)
+	}
+	if blob.Usernames, err = stores.names.Usernames(ctx); err != nil {
+		return nil, fmt.Errorf("usernames: %w", err)
+	}
+	if blob.PeerMeta, err = stores.names.PeerMeta(ctx); err != nil {
+		return nil, fmt.Errorf("peer meta: %w", err)
+	}
+	if stores.chat != nil {
+		msgs, err := stores.chat.Recent(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("chat: %w", err)
+		}
+		for _, msg := range msgs {
+			blob.Chat = append(blob.Chat, json.RawMessage(msg))
+		}
+	}
+	return blob, nil
+}
+
+// ImportRoom restores blob into code, which must exist and have no connected peers;
+// joins are refused with 503 (or 1013 "room_busy") while it runs.
+// Features are not imported (rooms cannot change them after creation), so create
+// the target room with the blob's features. Presence and broadcast flags are not
+// restored either: no connection stands behind the exported peers, so they would
+// be ghosts that keep the room from ever being cleaned up. Their names and
+// metadata are, and apply again when they rejoin under the same IDs. The hub
+// started for the import is cleaned up as usual if nobody joins.
+func (m *hubManager) ImportRoom(ctx context.Context, code string, blob *httpapi.RoomExport) error {
+	if _, err := m.roomStore.Get(ctx, code); err != nil {
+		return err
+	}
+	hub := m.hubForRoom(code)
+	if hub == nil {
+		return fmt.Errorf("no hub for room %s", code)
+	}
+	defer m.scheduleCleanup(code)
+	// Keep joins out until the import is done, so nobody sees half of it.
+	release, ok := hub.Hold()
+	if !ok {
+		return httpapi.ErrRoomBusy
+	}
+	defer release()
+	m.mu.Lock()
+	entry := m.hubs[code]
+	m.mu.Unlock()
+	if entry == nil {
+		return fmt.Errorf("hub for room %s went away", code)
+	}
+
+	for id, meta := range blob.BroadcastMeta {
+		if err := entry.bcast.SetBroadcastMeta(ctx, id, meta); err != nil {
+			return fmt.Errorf("broadcast meta: %w", err)
+		}
+	}
+	if len(blob.Usernames) > 0 {
+		if err := hub.SetUsernames(ctx, blob.Usernames); err != nil {
+			return fmt.Errorf("usernames: %w", err)
+		}
+	}
+	for id, meta := range blob.PeerMeta {
+		if err := entry.names.SetPeerMeta(ctx, id, meta); err != nil {
+			return fmt.Errorf("peer meta: %w", err)
+		}
+	}
+	if entry.chat != nil {
+		if err := entry.chat.Reset(ctx); err != nil {
+			return fmt.Errorf("chat: %w", err)
+		}
+		for _, msg := range blob.Chat {
+			if err := entry.chat.Append(ctx, msg); err != nil {
+				return fmt.Errorf("chat: %w", err)
+			}
+		}
+	} else if len(blob.Chat) > 0 {
+		log.Printf("room %s import: chat history disabled, skipping %d messages", code, len(blob.Chat))
+	}
+	if err := m.roomStore.SetPaused(ctx, code, blob.Paused); err != nil {
+		return fmt.Errorf("paused: %w", err)
+	}
+	hub.SetPaused(blob.Paused)
+	return nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"videochat/internal/app/httpapi"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	m, _, store := newTestManagerWith(t, hubManagerConfig{ChatCapacity: 10, ChatTTL: time.Hour})
+	ctx := context.Background()
+	src, dst := createRoom(t, store), createRoom(t, store)
+
+	stores := m.roomStores(src)
+	if err := stores.names.SetUsernames(ctx, map[string]string{"alice": "Alice", "bob": "Bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.names.SetPeerMeta(ctx, "alice", []byte(`{"hand":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stores.bcast.SetBroadcastMeta(ctx, "bob", []byte(`{"screen":true}`)); err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{`{"text":"hi"}`, `{"text":"there"}`} {
+		if err := stores.chat.Append(ctx, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SetPaused(ctx, src, true); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := m.ExportRoom(ctx, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Go through JSON like the admin endpoints do.
+	data, err := json.Marshal(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded httpapi.RoomExport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ImportRoom(ctx, dst, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.ExportRoom(ctx, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.Usernames, blob.Usernames) {
+		t.Errorf("usernames = %v, want %v", got.Usernames, blob.Usernames)
+	}
+	if string(got.PeerMeta["alice"]) != `{"hand":true}` {
+		t.Errorf("peer meta = %s, want alice's", got.PeerMeta)
+	}
+	if string(got.BroadcastMeta["bob"]) != `{"screen":true}` {
+		t.Errorf("broadcast meta = %s, want bob's", got.BroadcastMeta)
+	}
+	if len(got.Chat) != 2 || string(got.Chat[0]) != string(blob.Chat[0]) || string(got.Chat[1]) != string(blob.Chat[1]) {
+		t.Errorf("chat = %s, want %s", got.Chat, blob.Chat)
+	}
+	if !got.Paused {
+		t.Error("paused flag was not imported")
+	}
+}
+
+func TestImportRefusesConcurrentImport(t *testing.T) {
+	m, _, store := newTestManager(t)
+	ctx := context.Background()
+	code := createRoom(t, store)
+
+	hub := m.hubForRoom(code)
+	release, ok := hub.Hold()
+	if !ok {
+		t.Fatal("Hold failed on an empty room")
+	}
+	blob := &httpapi.RoomExport{Version: httpapi.RoomExportVersion, Usernames: map[string]string{"alice": "Alice"}}
+	if err := m.ImportRoom(ctx, code, blob); !errors.Is(err, httpapi.ErrRoomBusy) {
+		t.Fatalf("import during a hold = %v, want ErrRoomBusy", err)
+	}
+	release()
+	if err := m.ImportRoom(ctx, code, blob); err != nil {
+		t.Fatalf("import after release: %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"videochat/pkg/webrtc/signaling"
+)
+
+// startPresenceSweeper periodically removes ghost entries (peers with no
+// connection) from the presence sets of the rooms this instance serves alone. It
+// stops when the manager is closed.
+func (m *hubManager) startPresenceSweeper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stopLeases: // closed by Close
+				return
+			case <-ticker.C:
+			}
+			m.sweepPresence()
+		}
+	}()
+}
+
+func (m *hubManager) sweepPresence() {
+	m.mu.Lock()
+	hubs := make(map[string]*signaling.Hub, len(m.hubs))
+	for code, entry := range m.hubs {
+		if entry.cleaning == nil {
+			hubs[code] = entry.hub
+		}
+	}
+	m.mu.Unlock()
+
+	for code, hub := range hubs {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		// Peers connected to other instances share the presence set; leave those rooms be.
+		if m.soleOwner(ctx, code) {
+			if ghosts, err := hub.SweepGhosts(ctx); err != nil {
+				log.Printf("presence sweep for room %s: %v", code, err)
+			} else if len(ghosts) > 0 {
+				log.Printf("presence sweep removed %d ghost peers from room %s", len(ghosts), code)
+			}
+		}
+		cancel()
+	}
+}
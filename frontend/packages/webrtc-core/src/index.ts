@@ -13,9 +13,26 @@ export type StateMessage = {
   enabled?: boolean;
   iceServers?: RTCIceServer[];
   iceMode?: string;
+  iceTransportPolicy?: RTCIceTransportPolicy;
+  topology?: "mesh" | "relay";
+  relay?: string;
+  peerCount?: number;
+  stateVersion?: number;
+  // peer-joined only: everything known about the joiner.
+  peer?: PeerEntry;
   [key: string]: unknown;
 };
 
+export type PeerEntry = {
+  id: string;
+  username?: string;
+  role: "owner" | "participant" | "synthetic";
+  broadcasting: boolean;
+  audio?: boolean;
+  video?: boolean;
+  meta?: unknown;
+};
+
 export type IncomingMessage = StateMessage | SignalMessage;
 
 export type WebRTCEventMap = {
@@ -71,7 +88,14 @@ export class WebRTCClient {
   private peerId?: string;
   private iceServers: RTCIceServer[];
   private iceMode?: string;
+  private iceTransportPolicy?: RTCIceTransportPolicy;
+  private topology?: string;
+  private relay?: string;
+  private stateVersion = 0;
   private wsURL: string;
+  // Set by a "migrate" message: the socket reconnects here once the server closes it.
+  private migrateTo?: string;
+  private rebroadcast = false;
   private socketFactory: (url: string) => WebSocket;
   private negotiation = new Map<
     string,
@@ -118,6 +142,7 @@ export class WebRTCClient {
       broadcastEnabled: this.broadcastEnabled,
       iceServers: this.iceServers,
       iceMode: this.iceMode,
+      iceTransportPolicy: this.iceTransportPolicy,
       localStream: this.localStream,
       remoteStreams: new Map(this.remoteStreams)
     };
@@ -140,6 +165,10 @@ export class WebRTCClient {
         };
         socket.onclose = (ev) => {
           log("[webrtc] ws close", { code: ev.code, reason: ev.reason, wasClean: ev.wasClean });
+          if (this.migrateTo && socket === this.socket) {
+            this.migrate(this.migrateTo);
+            return;
+          }
           this.emit("disconnected", undefined);
           this.emit("status", "Disconnected from signaling server");
         };
@@ -195,7 +224,7 @@ export class WebRTCClient {
     this.broadcastEnabled = true;
     this.send({ type: "broadcast", enabled: true });
     this.peers
-      .filter((id) => id !== this.peerId)
+      .filter((id) => id !== this.peerId && this.shouldConnect(id))
       .forEach((id) => {
         void this.sendOffer(id);
       });
@@ -276,7 +305,10 @@ export class WebRTCClient {
     let pc = this.connections.get(id);
     if (pc) return pc;
 
-    pc = new RTCPeerConnection({ iceServers: this.iceServers });
+    pc = new RTCPeerConnection({
+      iceServers: this.iceServers,
+      iceTransportPolicy: this.iceTransportPolicy ?? "all"
+    });
     log("[webrtc] created RTCPeerConnection", { id });
 
     const negotiation = this.getNegotiationState(id);
@@ -486,6 +518,7 @@ export class WebRTCClient {
   private handleState(msg: StateMessage) {
     this.peers = msg.peers || this.peers;
     this.broadcasting = msg.broadcasting || this.broadcasting;
+    this.checkStateVersion(msg);
 
     if (msg.type === "welcome" && msg.id) {
       this.peerId = msg.id;
@@ -494,12 +527,40 @@ export class WebRTCClient {
         this.iceServers = msg.iceServers;
         this.iceMode = msg.iceMode;
       }
+      this.iceTransportPolicy = msg.iceTransportPolicy;
+      this.topology = msg.topology;
+      // Peers are only told we joined once we can answer their offers.
+      this.send({ type: "ready" });
+      if (this.rebroadcast) {
+        this.rebroadcast = false;
+        this.send({ type: "broadcast", enabled: true });
+      }
+    }
+
+    if (msg.relay !== undefined) {
+      this.relay = msg.relay || undefined;
+    }
+
+    if (msg.type === "relay-elected" && this.topology === "relay") {
+      // Drop links the new relay layout no longer wants; the new relay dials everyone.
+      Array.from(this.connections.keys())
+        .filter((id) => !this.shouldConnect(id))
+        .forEach((id) => this.removePeer(id));
+      if (this.peerId && this.peerId === this.relay) {
+        this.peers
+          .filter((id) => id !== this.peerId && !this.connections.has(id))
+          .forEach((id) => void this.sendOffer(id));
+      }
     }
 
     if (msg.type === "peer-left" && msg.id) {
       this.removePeer(msg.id);
     }
 
+    if (msg.type === "migrate" && typeof msg.url === "string") {
+      this.migrateTo = this.migrationURL(msg.url);
+    }
+
     if (msg.type === "broadcast-state" && msg.id && msg.enabled === false) {
       if (msg.id === this.peerId) {
         this.broadcastEnabled = false;
@@ -511,17 +572,79 @@ export class WebRTCClient {
       this.removeRemoteStream(msg.id);
     }
 
-    if (msg.type === "peer-joined" && msg.id) {
+    if (msg.type === "peer-joined" && msg.id && this.shouldConnect(msg.id)) {
       void this.sendOffer(msg.id);
     }
 
     this.emit("state", msg);
   }
 
+  // The server is draining: keep the local stream, drop every peer link (the
+  // room is rebuilt on the new instance) and reconnect there.
+  private migrate(url: string) {
+    log("[webrtc] migrating", { url });
+    this.migrateTo = undefined;
+    this.wsURL = url;
+    this.socket = null;
+    this.connections.forEach((pc) => pc.close());
+    this.connections.clear();
+    Array.from(this.remoteStreams.keys()).forEach((id) => this.removeRemoteStream(id));
+    for (const [, state] of this.negotiation) {
+      if (state.offerRetryTimer) {
+        clearTimeout(state.offerRetryTimer);
+      }
+    }
+    this.negotiation.clear();
+    this.stateVersion = 0;
+    this.rebroadcast = this.broadcastEnabled && !!this.localStream;
+    this.emit("status", "Moving to another server...");
+    this.connect();
+  }
+
+  // Carries the room and session query parameters over to the migration target.
+  private migrationURL(target: string) {
+    try {
+      const next = new URL(target);
+      const current = new URL(this.wsURL);
+      current.searchParams.forEach((value, key) => {
+        if (!next.searchParams.has(key)) {
+          next.searchParams.set(key, value);
+        }
+      });
+      return next.toString();
+    } catch {
+      return target;
+    }
+  }
+
   sendAppMessage(payload: any) {
     this.send(payload);
   }
 
+  // A roster version that skips ahead means a join or leave was missed: ask the
+  // server for a full sync.
+  private checkStateVersion(msg: StateMessage) {
+    if (msg.stateVersion === undefined) {
+      return;
+    }
+    const full = msg.type === "welcome" || msg.type === "sync" || msg.type === "roster";
+    const skipped = !full && this.stateVersion > 0 && msg.stateVersion > this.stateVersion + 1;
+    if (msg.stateVersion > this.stateVersion || full) {
+      this.stateVersion = msg.stateVersion;
+    }
+    if (skipped) {
+      this.send({ type: "sync" });
+    }
+  }
+
+  // In a relay-topology room only links to or from the relay are kept.
+  private shouldConnect(id: string) {
+    if (this.topology !== "relay" || !this.relay) {
+      return true;
+    }
+    return this.peerId === this.relay || id === this.relay;
+  }
+
   private removeRemoteStream(id: string) {
     const stream = this.remoteStreams.get(id);
     if (stream) {
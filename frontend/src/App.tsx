@@ -250,6 +250,20 @@ const RoomUI = (props: { username: string; roomCode: string; roomURL: string; ws
 
 const JoinRoomPrompt = (props: { onJoin: (username: string) => void; roomCode?: string; disabled?: boolean }) => {
   const [name, setName] = createSignal("");
+  // The server's display-name limit, so over-long names are stopped here instead of rejected.
+  const [maxLength, setMaxLength] = createSignal<number | undefined>();
+
+  onMount(async () => {
+    try {
+      const res = await fetch("/api/settings", { headers: { Accept: "application/json" } });
+      if (res.ok) {
+        const data = (await res.json()) as { maxUsernameLength?: number };
+        setMaxLength(data.maxUsernameLength);
+      }
+    } catch {
+      // keep the input unbounded
+    }
+  });
 
   const handleSubmit = (evt: Event) => {
     evt.preventDefault();
@@ -272,6 +286,7 @@ const JoinRoomPrompt = (props: { onJoin: (username: string) => void; roomCode?:
           name="username"
           autocomplete="name"
           value={name()}
+          maxLength={maxLength()}
           onInput={(evt) => setName(evt.currentTarget.value)}
           placeholder="e.g. Paul"
           required
@@ -326,7 +341,23 @@ const defaultRoomURL = (code: string) => {
   return `${base}/rooms/${encodeURIComponent(code)}`;
 };
 
+// browserSession is a per-browser ID (shared by its tabs) that lets the server spot the
+// same browser joining a room twice.
+const browserSession = () => {
+  try {
+    let id = localStorage.getItem("videochat:session");
+    if (!id) {
+      id = crypto.randomUUID();
+      localStorage.setItem("videochat:session", id);
+    }
+    return id;
+  } catch {
+    return "";
+  }
+};
+
 const resolveRoomWsURL = async (code: string) => {
+  const session = browserSession();
   try {
     const res = await fetch("/api/settings", { headers: { Accept: "application/json" } });
     if (res.ok) {
@@ -336,6 +367,7 @@ const resolveRoomWsURL = async (code: string) => {
         try {
           const url = new URL(base);
           url.searchParams.set("room", code);
+          if (session) url.searchParams.set("session", session);
           return url.toString();
         } catch {
           // fall through
@@ -351,6 +383,7 @@ const resolveRoomWsURL = async (code: string) => {
   const wsBase = `${proto}://${host}/ws`;
   const url = new URL(wsBase);
   url.searchParams.set("room", code);
+  if (session) url.searchParams.set("session", session);
   return url.toString();
 };
 